@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// exclusion marks a commit as one that will not be backported to a branch
+// or, if the branch is master, forward-ported from a release branch.
+type exclusion struct {
+	CreatedBy string
+	CreatedAt time.Time
+	Reason    string
+}
+
+type comment struct {
+	CreatedAt time.Time
+	SHA       sha
+	UserEmail string
+	Body      string
+}
+
+// actionTarget is the commit that a mutating request acts upon: a master
+// commit that is a candidate for a release branch, or a release branch commit
+// that is a candidate for forward-porting to master.
+type actionTarget struct {
+	repo   repo
+	branch string
+	commit commit
+}
+
+// parseActionTarget extracts the target of a mutating request from its form.
+func parseActionTarget(r *http.Request) (actionTarget, error) {
+	repoLock.RLock()
+	defer repoLock.RUnlock()
+
+	re, err := findRepo(r.PostFormValue("repo"))
+	if err != nil {
+		return actionTarget{}, err
+	}
+	sha, err := parseSHA(r.PostFormValue("sha"))
+	if err != nil {
+		return actionTarget{}, err
+	}
+	if r.PostFormValue("branch") == "master" {
+		c, ok := re.findForwardPort(sha)
+		if !ok {
+			return actionTarget{}, fmt.Errorf("%s is not a commit awaiting a forward-port", sha)
+		}
+		return actionTarget{repo: re, branch: "master", commit: c}, nil
+	}
+	branch, err := findBranch(re, r.PostFormValue("branch"))
+	if err != nil {
+		return actionTarget{}, err
+	}
+	c, ok := re.masterCommits.find(sha)
+	if !ok {
+		return actionTarget{}, fmt.Errorf("%s is not a commit on master", sha)
+	}
+	return actionTarget{repo: re, branch: branch, commit: c}, nil
+}
+
+// checkMutation verifies that r is a POST by a user permitted to perform a
+// on the target's branch.
+func (s *server) checkMutation(r *http.Request, t actionTarget, a action) error {
+	if r.Method != http.MethodPost {
+		return errMethodNotAllowed
+	}
+	return authorize(r.Context(), s.store, t.repo.id, t.branch, identityFromContext(r.Context()), a)
+}
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// redirectBack returns the user to the page from which they submitted a form.
+func redirectBack(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, localRedirect(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+func (s *server) serveExclude(w http.ResponseWriter, r *http.Request) error {
+	t, err := parseActionTarget(r)
+	if err != nil {
+		return err
+	}
+	if err := s.checkMutation(r, t, actionExclude); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	id := identityFromContext(ctx)
+	ev := event{
+		RepoID:    t.repo.id,
+		Branch:    t.branch,
+		Actor:     id.Login,
+		MessageID: t.commit.MessageID(),
+		SHA:       t.commit.sha,
+	}
+	if r.PostFormValue("undo") == "" {
+		e := exclusion{
+			CreatedBy: id.Login,
+			CreatedAt: time.Now(),
+			Reason:    strings.TrimSpace(r.PostFormValue("reason")),
+		}
+		ev.Kind, ev.Detail = eventExcluded, e.Reason
+		err = s.store.putExclusion(ctx, t.repo.id, t.branch, t.commit.MessageID(), e, ev)
+	} else {
+		ev.Kind = eventUnexcluded
+		err = s.store.deleteExclusion(ctx, t.repo.id, t.branch, t.commit.MessageID(), ev)
+	}
+	if err != nil {
+		return err
+	}
+	redirectBack(w, r)
+	return nil
+}
+
+func (s *server) serveComment(w http.ResponseWriter, r *http.Request) error {
+	t, err := parseActionTarget(r)
+	if err != nil {
+		return err
+	}
+	if err := s.checkMutation(r, t, actionComment); err != nil {
+		return err
+	}
+	body := strings.TrimSpace(r.PostFormValue("body"))
+	if body == "" {
+		return errors.New("empty comment")
+	}
+	ctx := r.Context()
+	id := identityFromContext(ctx)
+	c := comment{
+		CreatedAt: time.Now(),
+		SHA:       t.commit.sha,
+		UserEmail: id.email(),
+		Body:      body,
+	}
+	if err := s.store.addComment(ctx, t.repo.id, t.commit.MessageID(), c, event{
+		RepoID:    t.repo.id,
+		Branch:    t.branch,
+		Actor:     id.Login,
+		Kind:      eventCommented,
+		MessageID: t.commit.MessageID(),
+		SHA:       t.commit.sha,
+		Detail:    body,
+	}); err != nil {
+		return err
+	}
+	redirectBack(w, r)
+	return nil
+}
+
+// serveGrantRole assigns a role to a user on a repo or, if a branch is
+// given, on one of its release branches.
+func (s *server) serveGrantRole(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return errMethodNotAllowed
+	}
+	repoLock.RLock()
+	re, err := findRepo(r.PostFormValue("repo"))
+	var branch string
+	if err == nil && r.PostFormValue("branch") != "" {
+		branch, err = findBranch(re, r.PostFormValue("branch"))
+	}
+	repoLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	username := strings.TrimSpace(r.PostFormValue("username"))
+	if username == "" {
+		return errors.New("missing username")
+	}
+	ro, err := parseRole(r.PostFormValue("role"))
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	id := identityFromContext(ctx)
+	if err := authorize(ctx, s.store, re.id, branch, id, actionManageRoles); err != nil {
+		return err
+	}
+	if err := s.store.grantRole(ctx, re.id, branch, username, ro, event{
+		RepoID: re.id,
+		Branch: branch,
+		Actor:  id.Login,
+		Kind:   eventRoleGranted,
+		Detail: fmt.Sprintf("%s is now %s", username, ro),
+	}); err != nil {
+		return err
+	}
+	redirectBack(w, r)
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+var activityTemplate = template.Must(template.New("activity.html").Parse(`<!doctype html>
+<html>
+<head>
+    <style>
+        body {
+            font-family: helvetica, sans-serif;
+            font-size: 14px;
+        }
+
+        h1 {
+            margin: 0 0 10px;
+        }
+
+        h1 a {
+            color: inherit;
+            text-decoration: none;
+        }
+
+        .header {
+            margin: 0 auto;
+            text-align: center;
+        }
+
+        #event-table {
+            border-collapse: collapse;
+            margin: 1em auto 0;
+        }
+
+        #event-table td {
+            border-top: 1px solid #bbb;
+            padding: 0.3em 0.3em;
+        }
+
+        .sha {
+            font-family: monospace;
+        }
+
+        .more {
+            margin: 1em;
+            text-align: center;
+        }
+    </style>
+    <title>{{.Title}} · backboard</title>
+</head>
+<body>
+<div class="header">
+    <h1><a href="/">backboard</a></h1>
+    <h2>{{.Title}}</h2>
+</div>
+<table id="event-table">
+    <thead>
+    <tr>
+        <th>When</th>
+        <th>Repo</th>
+        <th>Branch</th>
+        <th>Who</th>
+        <th>What</th>
+        <th>PR</th>
+        <th>SHA</th>
+        <th>Detail</th>
+    </tr>
+    </thead>
+    <tbody>
+    {{range .Events}}
+        <tr>
+            <td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Repo}}</td>
+            <td>{{.Branch}}</td>
+            <td>{{.Actor}}</td>
+            <td>{{.Kind}}</td>
+            <td>{{with .PR}}<a href="{{.URL}}">{{.}}</a>{{end}}</td>
+            <td class="sha">{{if .SHA}}<a href="{{.HistoryURL}}" title="{{.SHA}}">{{.SHA.Short}}</a>{{end}}</td>
+            <td>{{.Detail}}</td>
+        </tr>
+    {{else}}
+        <tr><td colspan="8">No activity.</td></tr>
+    {{end}}
+    </tbody>
+</table>
+{{with .MoreURL}}<div class="more"><a href="{{.}}">older</a></div>{{end}}
+</body>
+</html>`))
+
+const eventsPerPage = 100
+
+// eventView decorates an event with what's needed to render it.
+type eventView struct {
+	event
+	Repo repo
+	PR   *pr
+}
+
+func (ev eventView) HistoryURL() string {
+	return historyURL(ev.Repo, ev.SHA)
+}
+
+func historyURL(re repo, sha sha) string {
+	return "/history?repo=" + strconv.FormatInt(re.id, 10) + "&sha=" + sha.String()
+}
+
+// serveActivity renders the feed of every event, optionally restricted to a
+// repo.
+func (s *server) serveActivity(w http.ResponseWriter, r *http.Request) error {
+	var f eventFilter
+	title := "Activity"
+	if s := r.URL.Query().Get("repo"); s != "" {
+		repoLock.RLock()
+		re, err := findRepo(s)
+		repoLock.RUnlock()
+		if err != nil {
+			return err
+		}
+		f.repoID = re.id
+		title += " in " + re.String()
+	}
+	return s.serveEvents(w, r, title, f)
+}
+
+// serveHistory renders the events that concern a single commit.
+func (s *server) serveHistory(w http.ResponseWriter, r *http.Request) error {
+	repoLock.RLock()
+	re, err := findRepo(r.URL.Query().Get("repo"))
+	repoLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	sha, err := parseSHA(r.URL.Query().Get("sha"))
+	if err != nil {
+		return err
+	}
+	c, ok := re.masterCommits.find(sha)
+	if !ok {
+		c, ok = re.findForwardPort(sha)
+	}
+	if !ok {
+		return errNotFound
+	}
+	f := eventFilter{repoID: re.id, messageID: c.MessageID()}
+	return s.serveEvents(w, r, "History of "+sha.Short()+" "+c.Title(), f)
+}
+
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request, title string, f eventFilter) error {
+	if s := r.URL.Query().Get("before"); s != "" {
+		before, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.before = before
+	}
+	evs, err := s.store.events(r.Context(), f, eventsPerPage)
+	if err != nil {
+		return err
+	}
+
+	repoLock.RLock()
+	reposByID := map[int64]*repo{}
+	for i := range repos {
+		re := repos[i]
+		reposByID[re.id] = &re
+	}
+	repoLock.RUnlock()
+
+	var views []eventView
+	for _, ev := range evs {
+		v := eventView{event: ev}
+		if re := reposByID[ev.RepoID]; re != nil {
+			v.Repo = *re
+			if ev.PRNumber != 0 {
+				v.PR = &pr{repo: re, number: ev.PRNumber}
+			}
+		}
+		views = append(views, v)
+	}
+
+	var moreURL string
+	if len(evs) == eventsPerPage {
+		q := r.URL.Query()
+		q.Set("before", strconv.FormatInt(evs[len(evs)-1].ID, 10))
+		moreURL = r.URL.Path + "?" + q.Encode()
+	}
+	return activityTemplate.Execute(w, struct {
+		Title   string
+		Events  []eventView
+		MoreURL string
+	}{
+		Title:   title,
+		Events:  views,
+		MoreURL: moreURL,
+	})
+}
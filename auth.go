@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+	githuboauth "golang.org/x/oauth2/github"
+)
+
+// identity is a signed-in user of the board.
+type identity struct {
+	Login string `json:"login"`
+	// Emails are the addresses under which the user authors commits, most
+	// preferred first.
+	Emails []string `json:"emails"`
+}
+
+// hasEmail reports whether email belongs to id.
+func (id *identity) hasEmail(email string) bool {
+	if id == nil {
+		return false
+	}
+	for _, e := range id.Emails {
+		if strings.EqualFold(e, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// email returns the address that best identifies id, falling back to the
+// login if id has no known addresses.
+func (id *identity) email() string {
+	if len(id.Emails) == 0 {
+		return id.Login
+	}
+	return id.Emails[0]
+}
+
+func (id *identity) String() string {
+	if id == nil {
+		return "anonymous"
+	}
+	return id.Login
+}
+
+type identityKey struct{}
+
+// identityFromContext returns the identity of the user making the request,
+// or nil if the request is anonymous.
+func identityFromContext(ctx context.Context) *identity {
+	id, _ := ctx.Value(identityKey{}).(*identity)
+	return id
+}
+
+// authConfig describes how the board identifies its users. Either mode may be
+// disabled; if both are, every request is anonymous.
+type authConfig struct {
+	// oauth configures login via a GitHub OAuth app. It is nil if OAuth login
+	// is disabled.
+	oauth *oauth2.Config
+	// sessionKey signs session cookies.
+	sessionKey []byte
+
+	// trustedUserHeader and trustedEmailHeader name the headers from which
+	// the identity is read when running behind an authenticating proxy. They
+	// are empty if trusted-header mode is disabled.
+	trustedUserHeader  string
+	trustedEmailHeader string
+
+	// secureCookies marks cookies Secure even on requests that did not
+	// arrive over TLS, for boards behind a proxy that terminates it.
+	secureCookies bool
+}
+
+// authConfigFromEnv builds an authConfig from the BACKBOARD_GITHUB_CLIENT_ID,
+// BACKBOARD_GITHUB_CLIENT_SECRET, BACKBOARD_SESSION_KEY,
+// BACKBOARD_TRUSTED_USER_HEADER, BACKBOARD_TRUSTED_EMAIL_HEADER and
+// BACKBOARD_SECURE_COOKIES env vars.
+func authConfigFromEnv() (authConfig, error) {
+	var ac authConfig
+	if clientID := os.Getenv("BACKBOARD_GITHUB_CLIENT_ID"); clientID != "" {
+		clientSecret := os.Getenv("BACKBOARD_GITHUB_CLIENT_SECRET")
+		if clientSecret == "" {
+			return authConfig{}, errors.New("missing BACKBOARD_GITHUB_CLIENT_SECRET env var")
+		}
+		sessionKey := os.Getenv("BACKBOARD_SESSION_KEY")
+		if len(sessionKey) < 32 {
+			return authConfig{}, errors.New("BACKBOARD_SESSION_KEY env var must be at least 32 bytes")
+		}
+		ac.oauth = &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     githuboauth.Endpoint,
+			Scopes:       []string{"read:user", "user:email"},
+		}
+		ac.sessionKey = []byte(sessionKey)
+	}
+	ac.trustedUserHeader = os.Getenv("BACKBOARD_TRUSTED_USER_HEADER")
+	ac.trustedEmailHeader = os.Getenv("BACKBOARD_TRUSTED_EMAIL_HEADER")
+	if ac.trustedUserHeader == "" && ac.trustedEmailHeader != "" {
+		return authConfig{}, errors.New("BACKBOARD_TRUSTED_EMAIL_HEADER requires BACKBOARD_TRUSTED_USER_HEADER")
+	}
+	if v := os.Getenv("BACKBOARD_SECURE_COOKIES"); v != "" {
+		secure, err := strconv.ParseBool(v)
+		if err != nil {
+			return authConfig{}, fmt.Errorf("parsing BACKBOARD_SECURE_COOKIES env var: %s", err)
+		}
+		ac.secureCookies = secure
+	}
+	return ac, nil
+}
+
+// secure reports whether cookies set in response to r should be marked
+// Secure: if r arrived over TLS, whether directly or through a proxy that
+// says so, or if secureCookies is set.
+func (ac authConfig) secure(r *http.Request) bool {
+	return ac.secureCookies || r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// loginEnabled reports whether users can sign in through the board itself.
+func (ac authConfig) loginEnabled() bool {
+	return ac.oauth != nil
+}
+
+const (
+	sessionCookie    = "backboard_session"
+	oauthStateCookie = "backboard_oauth_state"
+	sessionDuration  = 30 * 24 * time.Hour
+)
+
+type session struct {
+	identity
+	Expires time.Time `json:"expires"`
+}
+
+// authenticate returns the identity of the user making r, or nil if the
+// request is anonymous.
+func (ac authConfig) authenticate(r *http.Request) *identity {
+	if ac.trustedUserHeader != "" {
+		login := r.Header.Get(ac.trustedUserHeader)
+		if login == "" {
+			return nil
+		}
+		id := &identity{Login: login}
+		if ac.trustedEmailHeader != "" {
+			if email := r.Header.Get(ac.trustedEmailHeader); email != "" {
+				id.Emails = append(id.Emails, email)
+			}
+		}
+		return id
+	}
+	if ac.oauth == nil {
+		return nil
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	var s session
+	if err := ac.verify(c.Value, &s); err != nil || time.Now().After(s.Expires) {
+		return nil
+	}
+	return &s.identity
+}
+
+// sign serializes v and appends an HMAC so that the client cannot tamper
+// with it.
+func (ac authConfig) sign(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, ac.sessionKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify is the inverse of sign.
+func (ac authConfig) verify(s string, v interface{}) error {
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return errors.New("malformed signed value")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(s[:i])
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s[i+1:])
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, ac.sessionKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("bad signature")
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// localRedirect returns next if it is a path on this server, or "/"
+// otherwise, to avoid redirecting users to arbitrary sites after login.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return "/"
+	}
+	return next
+}
+
+func (s *server) serveLogin(w http.ResponseWriter, r *http.Request) error {
+	if !s.auth.loginEnabled() {
+		http.NotFound(w, r)
+		return nil
+	}
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	state := base64.RawURLEncoding.EncodeToString(nonce[:])
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state + "|" + localRedirect(r.URL.Query().Get("next")),
+		Path:     "/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   s.auth.secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, s.auth.oauth.AuthCodeURL(state), http.StatusFound)
+	return nil
+}
+
+func (s *server) serveOAuthCallback(w http.ResponseWriter, r *http.Request) error {
+	if !s.auth.loginEnabled() {
+		http.NotFound(w, r)
+		return nil
+	}
+	c, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		http.Error(w, "login expired; please try again", http.StatusBadRequest)
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/", MaxAge: -1})
+	parts := strings.SplitN(c.Value, "|", 2)
+	if len(parts) != 2 || parts[0] != r.URL.Query().Get("state") {
+		http.Error(w, "login state mismatch; please try again", http.StatusBadRequest)
+		return nil
+	}
+	next := parts[1]
+
+	ctx := r.Context()
+	tok, err := s.auth.oauth.Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		return fmt.Errorf("exchanging oauth code: %s", err)
+	}
+	id, err := fetchIdentity(ctx, github.NewClient(s.auth.oauth.Client(ctx, tok)))
+	if err != nil {
+		return err
+	}
+	value, err := s.auth.sign(session{identity: *id, Expires: time.Now().Add(sessionDuration)})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(sessionDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   s.auth.secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, localRedirect(next), http.StatusFound)
+	return nil
+}
+
+func (s *server) serveLogout(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusFound)
+	return nil
+}
+
+// fetchIdentity looks up the GitHub user that ghClient is authenticated as,
+// along with every verified email address under which they might author
+// commits.
+func fetchIdentity(ctx context.Context, ghClient *github.Client) (*identity, error) {
+	u, _, err := ghClient.Users.Get(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	id := &identity{Login: u.GetLogin()}
+	emails, _, err := ghClient.Users.ListEmails(ctx, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range emails {
+		if !e.GetVerified() {
+			continue
+		}
+		if e.GetPrimary() {
+			id.Emails = append([]string{e.GetEmail()}, id.Emails...)
+		} else {
+			id.Emails = append(id.Emails, e.GetEmail())
+		}
+	}
+	// Commits made through GitHub's web UI are attributed to the user's
+	// noreply address.
+	id.Emails = append(id.Emails,
+		fmt.Sprintf("%s@users.noreply.github.com", u.GetLogin()),
+		fmt.Sprintf("%d+%s@users.noreply.github.com", u.GetID(), u.GetLogin()))
+	return id, nil
+}
+
+// loginURL returns the URL that signs the user in and then returns them to
+// the page requested by r.
+func loginURL(r *http.Request) string {
+	return "/login?next=" + url.QueryEscape(r.URL.RequestURI())
+}
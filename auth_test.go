@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestSessionSigning(t *testing.T) {
+	ac := authConfig{sessionKey: []byte(strings.Repeat("k", 32))}
+	in := session{identity: identity{Login: "alice", Emails: []string{"alice@example.com"}}, Expires: time.Unix(1e9, 0).UTC()}
+	signed, err := ac.sign(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out session
+	if err := ac.verify(signed, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+
+	// Tampering with the payload, the signature or the key is detected.
+	forged, err := ac.sign(session{identity: identity{Login: "mallory"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := authConfig{sessionKey: []byte(strings.Repeat("x", 32))}
+	for name, verify := range map[string]func() error{
+		"payload": func() error {
+			return ac.verify(forged[:strings.IndexByte(forged, '.')]+signed[strings.IndexByte(signed, '.'):], &out)
+		},
+		"signature": func() error { return ac.verify(signed[:len(signed)-2]+"AA", &out) },
+		"key":       func() error { return other.verify(signed, &out) },
+		"malformed": func() error { return ac.verify("nodot", &out) },
+	} {
+		if err := verify(); err == nil {
+			t.Errorf("%s: expected tampering to be detected", name)
+		}
+	}
+}
+
+// fakeGitHubOAuth serves just enough of GitHub's OAuth and user APIs to sign
+// in as alice, and returns a context whose HTTP client routes every request
+// to it.
+func fakeGitHubOAuth(t *testing.T) (context.Context, *oauth2.Config) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/login/oauth/access_token":
+			if r.FormValue("code") != "good-code" {
+				http.Error(w, `{"error":"bad_verification_code"}`, http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"tok","token_type":"bearer"}`))
+		case "/user":
+			w.Write([]byte(`{"login":"alice","id":7}`))
+		case "/user/emails":
+			w.Write([]byte(`[{"email":"old@example.com","verified":true},` +
+				`{"email":"alice@example.com","verified":true,"primary":true},` +
+				`{"email":"spoofed@example.com","verified":false}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	return ctx, &oauth2.Config{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://github.com/login/oauth/authorize",
+			TokenURL: "https://github.com/login/oauth/access_token",
+		},
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestOAuthLogin(t *testing.T) {
+	ctx, oauth := fakeGitHubOAuth(t)
+	s := &server{auth: authConfig{oauth: oauth, sessionKey: []byte(strings.Repeat("k", 32))}}
+	do := func(target string, cookies []*http.Cookie, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", target, nil).WithContext(ctx)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	login := func(header http.Header) (state string, cookie *http.Cookie) {
+		t.Helper()
+		w := do("/login?next=/search", nil, header)
+		if w.Code != http.StatusFound {
+			t.Fatalf("expected a redirect to GitHub, got %d", w.Code)
+		}
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != oauthStateCookie {
+			t.Fatalf("expected the state cookie, got %v", cookies)
+		}
+		return loc.Query().Get("state"), cookies[0]
+	}
+
+	// Cookies are Secure if the request arrived over TLS at a proxy.
+	if _, c := login(nil); c.Secure {
+		t.Error("expected an insecure state cookie over plain http")
+	}
+	if _, c := login(http.Header{"X-Forwarded-Proto": {"https"}}); !c.Secure {
+		t.Error("expected a Secure state cookie behind a TLS-terminating proxy")
+	}
+
+	// The callback rejects a missing or mismatched state.
+	state, stateCookie := login(nil)
+	if w := do("/oauth/callback?code=good-code&state="+state, nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a missing state cookie to be rejected, got %d", w.Code)
+	}
+	if w := do("/oauth/callback?code=good-code&state=forged", []*http.Cookie{stateCookie}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a mismatched state to be rejected, got %d", w.Code)
+	}
+
+	// A successful callback signs the user in with their verified emails,
+	// primary first, and returns them to the page they came from.
+	w := do("/oauth/callback?code=good-code&state="+state, []*http.Cookie{stateCookie}, nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/search" {
+		t.Fatalf("expected a redirect to /search, got %d %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	var sessionCookies []*http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			sessionCookies = append(sessionCookies, c)
+		}
+	}
+	if len(sessionCookies) != 1 {
+		t.Fatalf("expected a session cookie, got %v", w.Result().Cookies())
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(sessionCookies[0])
+	id := s.auth.authenticate(r)
+	if expected := (&identity{Login: "alice", Emails: []string{
+		"alice@example.com", "old@example.com",
+		"alice@users.noreply.github.com", "7+alice@users.noreply.github.com",
+	}}); !reflect.DeepEqual(id, expected) {
+		t.Errorf("expected identity %+v, got %+v", expected, id)
+	}
+
+	// Expired sessions are ignored.
+	expired, err := s.auth.sign(session{identity: identity{Login: "alice"}, Expires: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: expired})
+	if id := s.auth.authenticate(r); id != nil {
+		t.Errorf("expected an expired session to be ignored, got %+v", id)
+	}
+}
+
+func TestTrustedHeaderLogin(t *testing.T) {
+	ac := authConfig{trustedUserHeader: "X-User", trustedEmailHeader: "X-Email"}
+	r := httptest.NewRequest("GET", "/", nil)
+	if id := ac.authenticate(r); id != nil {
+		t.Errorf("expected an anonymous request, got %+v", id)
+	}
+	r.Header.Set("X-User", "bob")
+	r.Header.Set("X-Email", "bob@example.com")
+	if id, expected := ac.authenticate(r), (&identity{Login: "bob", Emails: []string{"bob@example.com"}}); !reflect.DeepEqual(id, expected) {
+		t.Errorf("expected identity %+v, got %+v", expected, id)
+	}
+
+	// Session cookies are ignored in trusted-header mode, since the proxy
+	// is the only source of identities.
+	ac.oauth, ac.sessionKey = &oauth2.Config{}, []byte(strings.Repeat("k", 32))
+	signed, err := ac.sign(session{identity: identity{Login: "mallory"}, Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: signed})
+	if id := ac.authenticate(r); id != nil {
+		t.Errorf("expected the session cookie to be ignored, got %+v", id)
+	}
+}
@@ -2,74 +2,45 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"time"
 
-	"golang.org/x/oauth2"
-
 	"github.com/google/go-github/github"
-	_ "github.com/lib/pq" // activate postgres database adapter
+	_ "github.com/lib/pq"           // activate postgres database adapter
+	_ "github.com/mattn/go-sqlite3" // activate sqlite database adapter
 )
 
-var repos = []repo{
-	{githubOwner: "cockroachdb", githubRepo: "cockroach"},
-}
+// repos are the repos being served, loaded from the store by loadRepos.
+var repos []repo
 
 func main() {
-	if err := run(os.Args); err != nil {
+	if err := runCommand(context.Background(), os.Args[1:]); err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
-		os.Exit(1)
-	}
-}
-
-func run(args []string) error {
-	if len(args) < 2 || len(args) > 3 {
-		return fmt.Errorf("usage: %s <conn-string> [<listen-addr>]", args[0])
-	}
-
-	githubToken := os.Getenv("BACKBOARD_GITHUB_TOKEN")
-	if githubToken == "" {
-		return errors.New("missing BACKBOARD_GITHUB_TOKEN env var")
-	}
-
-	connString := args[1]
-	db, err := sql.Open("postgres", connString)
-	if err != nil {
-		return err
-	}
-	ctx := context.Background()
-	if err := db.PingContext(ctx); err != nil {
-		return err
-	}
-
-	if err := bootstrap(ctx, db); err != nil {
-		return fmt.Errorf("while bootstrapping: %s", err)
-	}
-
-	ghClient := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: githubToken},
-	)))
-
-	if len(args) == 2 {
-		return syncAll(ctx, ghClient, db)
+		switch err.(type) {
+		case usageError:
+			os.Exit(2)
+		case missingBackportsError, branchGapsError:
+			os.Exit(3)
+		default:
+			os.Exit(1)
+		}
 	}
-	listenAddr := args[2]
-	go syncLoop(ctx, ghClient, db)
-	http.Handle("/", &server{db: db})
-	return http.ListenAndServe(listenAddr, nil)
 }
 
-func syncLoop(ctx context.Context, ghClient *github.Client, db *sql.DB) {
+// syncLoop syncs every interval, reporting backport progress with reporter
+// after each sync if it is not nil.
+func syncLoop(ctx context.Context, ghClient *github.Client, st store, interval time.Duration, reporter *statusReporter) {
 	for {
-		if err := syncAll(ctx, ghClient, db); err != nil {
+		if err := syncAll(ctx, ghClient, st); err != nil {
 			log.Printf("sync error: %s", err)
 		}
-		// TODO(benesch): webhook support?
-		<-time.After(30 * time.Second)
+		if reporter != nil {
+			if err := reporter.reportAll(ctx); err != nil {
+				log.Printf("commit status error: %s", err)
+			}
+		}
+		<-time.After(interval)
 	}
 }
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// backportSelection is a set of master commits chosen for backporting to a
+// release branch.
+type backportSelection struct {
+	repo   repo
+	branch string
+	shas   map[string]bool
+}
+
+// parseBackportSelection extracts a backport selection from a request's form,
+// which names the commits in repeated "sha" fields.
+func parseBackportSelection(r *http.Request) (backportSelection, error) {
+	repoLock.RLock()
+	defer repoLock.RUnlock()
+
+	if err := r.ParseForm(); err != nil {
+		return backportSelection{}, err
+	}
+	re, err := findRepo(r.Form.Get("repo"))
+	if err != nil {
+		return backportSelection{}, err
+	}
+	branch, err := findBranch(re, r.Form.Get("branch"))
+	if err != nil {
+		return backportSelection{}, err
+	}
+	sel := backportSelection{repo: re, branch: branch, shas: map[string]bool{}}
+	for _, s := range r.Form["sha"] {
+		sha, err := parseSHA(s)
+		if err != nil {
+			return backportSelection{}, err
+		}
+		if _, ok := re.masterCommits.find(sha); !ok {
+			return backportSelection{}, fmt.Errorf("%s is not a commit on master", sha)
+		}
+		sel.shas[string(sha)] = true
+	}
+	if len(sel.shas) == 0 {
+		return backportSelection{}, errors.New("no commits selected")
+	}
+	return sel, nil
+}
+
+// backportGroup is a master PR with at least one selected commit.
+type backportGroup struct {
+	pr *pr
+	// commits are all of the PR's commits, and included and skipped its
+	// selected and unselected commits, oldest first.
+	commits  []commit
+	included []commit
+	skipped  []commit
+}
+
+// groups arranges the selection by master PR, in the order the PRs merged.
+func (sel backportSelection) groups() ([]backportGroup, error) {
+	candidates := sel.repo.masterCommits.truncate(sel.repo.branchMergeBases[sel.branch])
+	selectedPRs := map[int]bool{}
+	for _, c := range candidates {
+		if !sel.shas[string(c.sha)] {
+			continue
+		}
+		masterPR := sel.repo.masterPRs[string(c.sha)]
+		if masterPR == nil {
+			return nil, fmt.Errorf("%s does not belong to a master PR", c.sha.Short())
+		}
+		selectedPRs[masterPR.number] = true
+	}
+	if len(selectedPRs) == 0 {
+		return nil, fmt.Errorf("no selected commits are candidates for %s", sel.branch)
+	}
+
+	var groups []backportGroup
+	index := map[int]int{}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		c := candidates[i]
+		masterPR := sel.repo.masterPRs[string(c.sha)]
+		if masterPR == nil || !selectedPRs[masterPR.number] {
+			continue
+		}
+		j, ok := index[masterPR.number]
+		if !ok {
+			j = len(groups)
+			index[masterPR.number] = j
+			groups = append(groups, backportGroup{pr: masterPR})
+		}
+		groups[j].commits = append(groups[j].commits, c)
+		if sel.shas[string(c.sha)] {
+			groups[j].included = append(groups[j].included, c)
+		} else {
+			groups[j].skipped = append(groups[j].skipped, c)
+		}
+	}
+	return groups, nil
+}
+
+// includePrerequisites adds to sel the commits that its commits depend on,
+// directly or transitively, that have yet to land on sel.branch. deps are the
+// dependencies of the branch's candidate commits, by SHA.
+func (sel backportSelection) includePrerequisites(deps map[string][]sha) {
+	var queue []string
+	for s := range sel.shas {
+		queue = append(queue, s)
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, c := range sel.repo.prerequisites(sel.branch, deps[s]) {
+			if !sel.shas[string(c.sha)] {
+				sel.shas[string(c.sha)] = true
+				queue = append(queue, string(c.sha))
+			}
+		}
+	}
+}
+
+// selectionDependencies returns the dependencies of the candidate commits
+// for sel.branch, by SHA. If the request's form sets "prerequisites", it also
+// adds the selected commits' prerequisites to sel.
+func (s *server) selectionDependencies(r *http.Request, sel backportSelection) (map[string][]sha, error) {
+	deps, err := s.store.dependencies(r.Context(), sel.repo.id, sel.repo.branchMergeBases[sel.branch])
+	if err != nil {
+		return nil, err
+	}
+	if r.Form.Get("prerequisites") != "" {
+		sel.includePrerequisites(deps)
+	}
+	return deps, nil
+}
+
+// releaseNotes returns the release notes in a PR or commit message body. A
+// note begins with a line that starts with "Release note", in any case, and
+// continues until the next blank line or the next note.
+func releaseNotes(body string) []string {
+	var notes []string
+	var note []string
+	flush := func() {
+		if len(note) > 0 {
+			notes = append(notes, strings.Join(note, "\n"))
+			note = nil
+		}
+	}
+	body = strings.Replace(body, "\r\n", "\n", -1)
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(strings.ToLower(line), "release note"):
+			flush()
+			note = append(note, line)
+		case len(note) > 0:
+			note = append(note, line)
+		}
+	}
+	flush()
+	return notes
+}
+
+// backportTitle is the title of a PR that backports originals to branch.
+func backportTitle(branch string, originals []*github.PullRequest) string {
+	var titles []string
+	for _, o := range originals {
+		titles = append(titles, o.GetTitle())
+	}
+	return branch + ": " + strings.Join(titles, "; ")
+}
+
+// backportBody is the body of a PR that backports groups to branch.
+// originals are the groups' master PRs as returned by GitHub.
+func backportBody(branch string, groups []backportGroup, originals []*github.PullRequest) string {
+	var b strings.Builder
+	var summaries []string
+	for _, g := range groups {
+		summaries = append(summaries, fmt.Sprintf("%d/%d commits from #%d",
+			len(g.included), len(g.included)+len(g.skipped), g.pr.number))
+	}
+	fmt.Fprintf(&b, "Backport %s to %s.\n", strings.Join(summaries, ", "), branch)
+	for i, g := range groups {
+		fmt.Fprintf(&b, "\n### #%d: %s\n", g.pr.number, originals[i].GetTitle())
+		writeCommitList(&b, "Included commits", g.included)
+		writeCommitList(&b, "Skipped commits", g.skipped)
+		for _, note := range releaseNotes(originals[i].GetBody()) {
+			fmt.Fprintf(&b, "\n%s\n", note)
+		}
+	}
+	return b.String()
+}
+
+func writeCommitList(b *strings.Builder, heading string, cs []commit) {
+	if len(cs) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n%s:\n", heading)
+	for _, c := range cs {
+		fmt.Fprintf(b, "- %s %s\n", c.sha.Short(), c.title)
+	}
+}
+
+// serveCreateBackport opens a PR against a release branch that backports
+// the selected commits, which the user has already cherry-picked onto the
+// branch named by the "head" form field.
+func (s *server) serveCreateBackport(w http.ResponseWriter, r *http.Request) error {
+	sel, err := parseBackportSelection(r)
+	if err != nil {
+		return err
+	}
+	if err := s.checkMutation(r, actionTarget{repo: sel.repo, branch: sel.branch}, actionExecuteBackport); err != nil {
+		return err
+	}
+	if s.gh == nil {
+		return errors.New("opening PRs requires a GitHub client")
+	}
+	head := strings.TrimSpace(r.PostFormValue("head"))
+	if head == "" {
+		return errors.New("missing head branch")
+	}
+	if _, err := s.selectionDependencies(r, sel); err != nil {
+		return err
+	}
+	groups, err := sel.groups()
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	owner, name := sel.repo.githubOwner, sel.repo.githubRepo
+	var originals []*github.PullRequest
+	labels := map[string]bool{}
+	var labelNames, assignees []string
+	for _, g := range groups {
+		o, _, err := s.gh.PullRequests.Get(ctx, owner, name, g.pr.number)
+		if err != nil {
+			return fmt.Errorf("fetching #%d: %s", g.pr.number, err)
+		}
+		originals = append(originals, o)
+		for _, l := range o.Labels {
+			if !labels[l.GetName()] {
+				labels[l.GetName()] = true
+				labelNames = append(labelNames, l.GetName())
+			}
+		}
+		if login := o.GetUser().GetLogin(); login != "" && !containsString(assignees, login) {
+			assignees = append(assignees, login)
+		}
+	}
+
+	title := strings.TrimSpace(r.PostFormValue("title"))
+	if title == "" {
+		title = backportTitle(sel.branch, originals)
+	}
+	created, _, err := s.gh.PullRequests.Create(ctx, owner, name, &github.NewPullRequest{
+		Title: github.String(title),
+		Head:  github.String(head),
+		Base:  github.String(sel.branch),
+		Body:  github.String(backportBody(sel.branch, groups, originals)),
+	})
+	if err != nil {
+		return fmt.Errorf("opening backport PR: %s", err)
+	}
+	// The PR exists now, so failing to decorate it shouldn't fail the request.
+	if len(labelNames) > 0 {
+		if _, _, err := s.gh.Issues.AddLabelsToIssue(ctx, owner, name, created.GetNumber(), labelNames); err != nil {
+			log.Printf("copying labels to #%d: %s", created.GetNumber(), err)
+		}
+	}
+	if len(assignees) > 0 {
+		if _, _, err := s.gh.Issues.AddAssignees(ctx, owner, name, created.GetNumber(), assignees); err != nil {
+			log.Printf("assigning #%d: %s", created.GetNumber(), err)
+		}
+	}
+
+	id := identityFromContext(ctx)
+	if err := s.store.recordEvent(ctx, event{
+		RepoID:   sel.repo.id,
+		Branch:   sel.branch,
+		Actor:    id.Login,
+		Kind:     eventBackportCreated,
+		PRNumber: created.GetNumber(),
+		Detail:   title,
+	}); err != nil {
+		return err
+	}
+	if err := syncOnePR(ctx, s.store, sel.repo.id, created); err != nil {
+		log.Printf("syncing #%d: %s", created.GetNumber(), err)
+	}
+	redirectBack(w, r)
+	return nil
+}
+
+// syncOnePR fetches and syncs pr without waiting for the next sync of the
+// repo with the given ID. If that sync is underway, syncOnePR waits for it.
+func syncOnePR(ctx context.Context, st store, repoID int64, pr *github.PullRequest) error {
+	defer lockRepoSync(repoID)()
+	repoLock.RLock()
+	re, err := findRepo(fmt.Sprint(repoID))
+	repoLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := re.spawnRemote("-C", re.path(), "fetch"); err != nil {
+		return err
+	}
+	if err := syncPR(ctx, st, &re, pr); err != nil {
+		return err
+	}
+	if err := re.refresh(ctx, st); err != nil {
+		return err
+	}
+
+	repoLock.Lock()
+	defer repoLock.Unlock()
+	for i := range repos {
+		if repos[i].id == repoID {
+			repos[i] = re
+		}
+	}
+	return nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, s0 := range ss {
+		if s0 == s {
+			return true
+		}
+	}
+	return false
+}
+
+// backportCommand is the response of the backport command builder.
+type backportCommand struct {
+	Command string `json:"command"`
+	// Problems are reasons the command is likely to go wrong, like selected
+	// commits that depend on commits the command leaves out.
+	Problems []string `json:"problems"`
+	// Warnings flag selected commits that probably shouldn't be backported.
+	Warnings []string `json:"warnings"`
+	// Selected are the SHAs of the commits the command backports, oldest
+	// first, which include any prerequisites the request asked for.
+	Selected []string `json:"selected"`
+}
+
+// Command styles supported by the backport command builder.
+const (
+	// styleBackport invokes the backport tool, which cherry-picks the
+	// commits of whole PRs onto a new branch, narrowed down by -c flags.
+	styleBackport = "backport"
+	// styleCherryPick is a plain git cherry-pick of the selected commits.
+	styleCherryPick = "cherry-pick"
+)
+
+// buildBackportCommand returns the command that backports groups, the master
+// PRs of a selection, in the given style.
+func buildBackportCommand(groups []backportGroup, style string) (string, error) {
+	switch style {
+	case styleBackport, "":
+		var prs, selected, unselected []string
+		for _, g := range groups {
+			prs = append(prs, fmt.Sprint(g.pr.number))
+			for _, c := range g.included {
+				selected = append(selected, c.sha.String()[:7])
+			}
+			for _, c := range g.skipped {
+				unselected = append(unselected, c.sha.String()[:7])
+			}
+		}
+		// Name whichever of the selected and unselected commits is shorter.
+		args := append([]string{"backport"}, prs...)
+		if len(selected) > len(unselected) {
+			for _, s := range unselected {
+				args = append(args, "-c", "'!"+s+"'")
+			}
+		} else if len(unselected) > 0 {
+			for _, s := range selected {
+				args = append(args, "-c", s)
+			}
+		}
+		return strings.Join(args, " "), nil
+	case styleCherryPick:
+		args := []string{"git", "cherry-pick", "-x"}
+		for _, g := range groups {
+			for _, c := range g.included {
+				args = append(args, c.sha.String())
+			}
+		}
+		return strings.Join(args, " "), nil
+	default:
+		return "", fmt.Errorf("unknown command style %q", style)
+	}
+}
+
+// checkBackport reports the problems and warnings with backporting groups,
+// a selection's master PRs, to sel.branch. deps are the dependencies of the
+// branch's candidate commits, by SHA.
+func checkBackport(sel backportSelection, groups []backportGroup, exclusions map[string]exclusion, deps map[string][]sha) (problems, warnings []string) {
+	for _, g := range groups {
+		// A commit may build on any earlier commit in its PR, so leaving out
+		// a commit that precedes a selected one is suspect.
+		var skipped []commit
+		for _, c := range g.commits {
+			if !sel.shas[string(c.sha)] {
+				skipped = append(skipped, c)
+				continue
+			}
+			for _, s := range skipped {
+				problems = append(problems, fmt.Sprintf("%s %q is not selected, but later commits in #%d may depend on it",
+					s.sha.Short(), s.title, g.pr.number))
+			}
+			skipped = nil
+		}
+		for _, c := range g.included {
+			for _, p := range sel.repo.prerequisites(sel.branch, deps[string(c.sha)]) {
+				// Skipped commits in the same PR were reported above.
+				if sel.shas[string(p.sha)] || containsCommit(g.skipped, p) {
+					continue
+				}
+				problems = append(problems, fmt.Sprintf("%s %q depends on %s %q, which is not selected and has not landed on %s",
+					c.sha.Short(), c.title, p.sha.Short(), p.title, sel.branch))
+			}
+		}
+		for _, c := range g.included {
+			if backportPR := sel.repo.branchPRs[c.MessageID()][sel.branch]; backportPR != nil {
+				warnings = append(warnings, fmt.Sprintf("%s %q is already backported in %s", c.sha.Short(), c.title, backportPR))
+			} else if _, ok := sel.repo.branchCommits[sel.branch].messageIDs[c.MessageID()]; ok {
+				warnings = append(warnings, fmt.Sprintf("%s %q is already on %s", c.sha.Short(), c.title, sel.branch))
+			} else if e, ok := exclusions[c.MessageID()]; ok {
+				msg := fmt.Sprintf("%s %q was excluded from %s by %s", c.sha.Short(), c.title, sel.branch, e.CreatedBy)
+				if e.Reason != "" {
+					msg += ": " + e.Reason
+				}
+				warnings = append(warnings, msg)
+			}
+		}
+	}
+	return problems, warnings
+}
+
+func containsCommit(cs []commit, c commit) bool {
+	for _, c0 := range cs {
+		if string(c0.sha) == string(c.sha) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveBackportCommand returns, as JSON, the command that backports the
+// selected commits, along with any problems with the selection.
+func (s *server) serveBackportCommand(w http.ResponseWriter, r *http.Request) error {
+	sel, err := parseBackportSelection(r)
+	if err != nil {
+		return errBadRequest{err}
+	}
+	deps, err := s.selectionDependencies(r, sel)
+	if err != nil {
+		return err
+	}
+	groups, err := sel.groups()
+	if err != nil {
+		return errBadRequest{err}
+	}
+	command, err := buildBackportCommand(groups, r.Form.Get("style"))
+	if err != nil {
+		return errBadRequest{err}
+	}
+	exclusions, err := s.store.exclusions(r.Context(), sel.repo.id, sel.branch)
+	if err != nil {
+		return err
+	}
+	res := backportCommand{Command: command, Problems: []string{}, Warnings: []string{}, Selected: []string{}}
+	problems, warnings := checkBackport(sel, groups, exclusions, deps)
+	res.Problems = append(res.Problems, problems...)
+	res.Warnings = append(res.Warnings, warnings...)
+	for _, g := range groups {
+		for _, c := range g.included {
+			res.Selected = append(res.Selected, c.sha.String())
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(res)
+}
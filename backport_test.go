@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// postForm sends a form to s as the user with the given login.
+func postForm(s *server, path, login string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-User", login)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateBackport(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		setupBackports(e)
+		e.gh.updatePR(3, func(pr *github.PullRequest) {
+			pr.Body = github.String("Fixes c.\n\nRelease note (bug fix): c no longer breaks.")
+			pr.Labels = []*github.Label{{Name: github.String("backport-1.0")}}
+		})
+		e.sync()
+
+		c := e.board(boardOptions{branch: "release-1.0"}).Commits[0]
+		if c.Title() != "c" {
+			t.Fatalf("expected c to be the newest commit, got %s", c.Title())
+		}
+		e.upstream.git("", "checkout", "-q", "-b", "backport-c", "release-1.0")
+		e.upstream.git("carol@example.com", "cherry-pick", "-x", c.SHA().String())
+		e.upstream.git("", "checkout", "-q", "master")
+
+		repoID := e.repo().id
+		if err := st.grantRole(e.ctx, repoID, "", "carol", roleReleaseManager, event{
+			RepoID: repoID, Kind: eventRoleGranted,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		s := &server{store: st, auth: authConfig{trustedUserHeader: "X-User"}, gh: e.gh.client()}
+		form := url.Values{
+			"repo":   {fmt.Sprint(repoID)},
+			"branch": {"release-1.0"},
+			"sha":    {c.SHA().String()},
+			"head":   {"carol:backport-c"},
+			"next":   {"/"},
+		}
+
+		if w := postForm(s, "/backport", "dave", form); w.Code != http.StatusForbidden {
+			t.Errorf("expected contributor to be forbidden, got %d: %s", w.Code, w.Body)
+		}
+		if w := postForm(s, "/backport", "carol", form); w.Code != http.StatusSeeOther {
+			t.Fatalf("expected redirect, got %d: %s", w.Code, w.Body)
+		}
+
+		pr := e.gh.pr(6)
+		if pr == nil {
+			t.Fatal("backport PR was not opened")
+		}
+		if title := pr.GetTitle(); title != "release-1.0: fix c" {
+			t.Errorf("unexpected title %q", title)
+		}
+		if base := pr.GetBase().GetRef(); base != "release-1.0" {
+			t.Errorf("unexpected base %q", base)
+		}
+		for _, s := range []string{"1/1 commits from #3", c.SHA().Short(), "Release note (bug fix): c no longer breaks."} {
+			if !strings.Contains(pr.GetBody(), s) {
+				t.Errorf("body does not mention %q:\n%s", s, pr.GetBody())
+			}
+		}
+		if len(pr.Labels) != 1 || pr.Labels[0].GetName() != "backport-1.0" {
+			t.Errorf("labels were not copied: %v", pr.Labels)
+		}
+		if len(pr.Assignees) != 1 || pr.Assignees[0].GetLogin() != "alice" {
+			t.Errorf("author was not assigned: %v", pr.Assignees)
+		}
+
+		// The board reflects the PR without waiting for a sync.
+		row := boardRows(e.board(boardOptions{branch: "release-1.0"}))[0]
+		if row.backportPR != 6 || row.status != "◷" {
+			t.Errorf("expected c to be in progress in #6, got %+v", row)
+		}
+
+		// Syncing a single PR doesn't race with a sync of the whole repo.
+		synced := make(chan error, 1)
+		go func() { synced <- syncAll(e.ctx, e.gh.client(), st) }()
+		if err := syncOnePR(e.ctx, st, repoID, pr); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-synced; err != nil {
+			t.Fatal(err)
+		}
+
+		evs, err := st.events(e.ctx, eventFilter{repoID: repoID}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, ev := range evs {
+			if ev.Kind == eventBackportCreated && ev.Actor == "carol" && ev.PRNumber == 6 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no backport-created event in %+v", evs)
+		}
+	})
+}
+
+func TestReleaseNotes(t *testing.T) {
+	for _, tc := range []struct {
+		body     string
+		expected []string
+	}{
+		{
+			"Fixes a thing.\r\n\r\nRelease note (bug fix): the thing\r\nis fixed.\r\n\r\nRelease note: None",
+			[]string{"Release note (bug fix): the thing\nis fixed.", "Release note: None"},
+		},
+		// Notes needn't start a paragraph, and end at the next blank line
+		// or note.
+		{
+			"Fixes a thing.\nRELEASE NOTE (bug fix): the thing\n  is fixed.\nrelease note: and another\n\nNot a note.",
+			[]string{"RELEASE NOTE (bug fix): the thing\nis fixed.", "release note: and another"},
+		},
+		{"No notes here.", nil},
+	} {
+		if notes := releaseNotes(tc.body); !reflect.DeepEqual(notes, tc.expected) {
+			t.Errorf("%q: expected %q, got %q", tc.body, tc.expected, notes)
+		}
+	}
+}
+
+func TestBackportCommand(t *testing.T) {
+	e := newTestEnv(t, newMemStore())
+	setupBackports(e)
+	e.sync()
+
+	shas := map[string]string{}
+	for _, c := range e.board(boardOptions{branch: "release-1.0"}).Commits {
+		shas[c.Title()] = c.SHA().String()
+	}
+	repoID := e.repo().id
+	if err := e.store.putExclusion(e.ctx, repoID, "release-1.0", e.board(boardOptions{branch: "release-1.0"}).Commits[0].MessageID(),
+		exclusion{CreatedBy: "carol", Reason: "too risky"}, event{RepoID: repoID, Kind: eventExcluded}); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{store: e.store}
+
+	for _, tc := range []struct {
+		style         string
+		titles        []string
+		prerequisites bool
+		expected      backportCommand
+	}{
+		{
+			titles: []string{"b2"},
+			expected: backportCommand{
+				Command:  "backport 2 -c " + shas["b2"][:7],
+				Problems: []string{fmt.Sprintf("%s \"b1\" is not selected, but later commits in #2 may depend on it", shas["b1"][:9])},
+				Warnings: []string{},
+				Selected: []string{shas["b2"]},
+			},
+		},
+		{
+			titles:        []string{"b2"},
+			prerequisites: true,
+			expected: backportCommand{
+				Command:  "backport 2",
+				Problems: []string{},
+				Warnings: []string{fmt.Sprintf("%s \"b1\" is already backported in #5", shas["b1"][:9])},
+				Selected: []string{shas["b1"], shas["b2"]},
+			},
+		},
+		{
+			titles: []string{"c", "b1", "b2"},
+			expected: backportCommand{
+				Command:  "backport 2 3",
+				Problems: []string{},
+				Warnings: []string{
+					fmt.Sprintf("%s \"b1\" is already backported in #5", shas["b1"][:9]),
+					fmt.Sprintf("%s \"c\" was excluded from release-1.0 by carol: too risky", shas["c"][:9]),
+				},
+				Selected: []string{shas["b1"], shas["b2"], shas["c"]},
+			},
+		},
+		{
+			style:  "cherry-pick",
+			titles: []string{"c", "a"},
+			expected: backportCommand{
+				Command:  "git cherry-pick -x " + shas["a"] + " " + shas["c"],
+				Problems: []string{},
+				Warnings: []string{
+					fmt.Sprintf("%s \"a\" is already backported in #4", shas["a"][:9]),
+					fmt.Sprintf("%s \"c\" was excluded from release-1.0 by carol: too risky", shas["c"][:9]),
+				},
+				Selected: []string{shas["a"], shas["c"]},
+			},
+		},
+	} {
+		q := url.Values{"repo": {fmt.Sprint(repoID)}, "branch": {"release-1.0"}, "style": {tc.style}}
+		for _, title := range tc.titles {
+			q.Add("sha", shas[title])
+		}
+		if tc.prerequisites {
+			q.Set("prerequisites", "1")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/backport/command?"+q.Encode(), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: expected status 200, got %d: %s", tc.titles, w.Code, w.Body)
+		}
+		var actual backportCommand
+		if err := json.Unmarshal(w.Body.Bytes(), &actual); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%v: expected %+v, got %+v", tc.titles, tc.expected, actual)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/backport/command?style=bogus&sha="+shas["a"], nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown style, got %d", w.Code)
+	}
+}
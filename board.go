@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// boardOptions selects the commits shown on a board.
+type boardOptions struct {
+	branch string
+	// author restricts the board to the commits of the author with this
+	// email. If empty, the board shows every author's commits, unless me is
+	// set and has authored commits on the board, in which case it shows only
+	// theirs.
+	author string
+	me     *identity
+	// showExcluded includes commits that have been excluded from the branch.
+	showExcluded bool
+	// path restricts the board to commits that change a file whose path
+	// begins with this prefix.
+	path string
+	// component restricts the board to commits that change a file in the
+	// repo's component with this name.
+	component string
+	// sinceLastTag restricts the board to commits that landed on the branch
+	// after its latest tag.
+	sinceLastTag bool
+}
+
+// board is the list of master commits that are candidates for backporting
+// to a release branch, annotated with their backport status.
+type board struct {
+	Commits []acommit
+	// Authors are the authors of every candidate commit, whether or not they
+	// were filtered out.
+	Authors []user
+	// Author is the author the board was restricted to, if any.
+	Author user
+	// Components are the repo's components.
+	Components []component
+	// LatestTag is the newest tag merged into the branch, if any.
+	LatestTag string
+}
+
+// buildBoard computes the board for opts.branch of re. Callers must hold
+// repoLock.
+func buildBoard(ctx context.Context, st store, re repo, opts boardOptions) (*board, error) {
+	branch := opts.branch
+	exclusions, err := st.exclusions(ctx, re.id, branch)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := st.comments(ctx, re.id)
+	if err != nil {
+		return nil, err
+	}
+	predictions, err := st.predictions(ctx, re.id, re.branchTips[branch])
+	if err != nil {
+		return nil, err
+	}
+	deps, err := st.dependencies(ctx, re.id, re.branchMergeBases[branch])
+	if err != nil {
+		return nil, err
+	}
+	components, err := st.components(ctx, re.id)
+	if err != nil {
+		return nil, err
+	}
+	branchGaps, err := findBranchGaps(ctx, st, re)
+	if err != nil {
+		return nil, err
+	}
+	gaps := map[string][]string{}
+	for _, g := range branchGaps {
+		gaps[string(g.sha)] = g.Missing
+	}
+
+	commits := re.masterCommits.truncate(re.branchMergeBases[branch])
+
+	authors := map[user]struct{}{}
+	for _, c := range commits {
+		authors[c.Author] = struct{}{}
+	}
+	// mine reports whether a commit's author is to be shown. The board is
+	// restricted to opts.author if set, or otherwise to every address of
+	// opts.me that has commits on the board; author is then the one that
+	// appears in the author selector.
+	var author user
+	var mine func(user) bool
+	if opts.author != "" {
+		for a := range authors {
+			if a.Email == opts.author {
+				author = a
+			}
+		}
+		if author == (user{}) {
+			return nil, fmt.Errorf("%q is not a recognized author", opts.author)
+		}
+		mine = func(a user) bool { return a == author }
+	} else if opts.me != nil {
+	emails:
+		for _, email := range opts.me.Emails {
+			for a := range authors {
+				if strings.EqualFold(a.Email, email) {
+					author = a
+					break emails
+				}
+			}
+		}
+		mine = func(a user) bool { return opts.me.hasEmail(a.Email) }
+	}
+	if author != (user{}) {
+		var newCommits []commit
+		for _, c := range commits {
+			if mine(c.Author) {
+				newCommits = append(newCommits, c)
+			}
+		}
+		commits = newCommits
+	}
+	if opts.path != "" {
+		var newCommits []commit
+		for _, c := range commits {
+			if touchesPrefix(c.paths, opts.path) {
+				newCommits = append(newCommits, c)
+			}
+		}
+		commits = newCommits
+	}
+	if opts.component != "" {
+		var comp *component
+		for i := range components {
+			if components[i].Name == opts.component {
+				comp = &components[i]
+			}
+		}
+		if comp == nil {
+			return nil, fmt.Errorf("%q is not a recognized component", opts.component)
+		}
+		var newCommits []commit
+		for _, c := range commits {
+			if comp.touches(c.paths) {
+				newCommits = append(newCommits, c)
+			}
+		}
+		commits = newCommits
+	}
+	if opts.sinceLastTag {
+		var newCommits []commit
+		for _, c := range commits {
+			_, landed := re.branchCommits[branch].messageIDs[c.MessageID()]
+			if _, tagged := re.firstTags[branch][c.MessageID()]; landed && !tagged {
+				newCommits = append(newCommits, c)
+			}
+		}
+		commits = newCommits
+	}
+	if !opts.showExcluded {
+		var newCommits []commit
+		for _, c := range commits {
+			_, excluded := exclusions[c.MessageID()]
+			_, backported := re.branchCommits[branch].messageIDs[c.MessageID()]
+			if !excluded || backported || re.branchPRs[c.MessageID()][branch] != nil {
+				newCommits = append(newCommits, c)
+			}
+		}
+		commits = newCommits
+	}
+	var sortedAuthors []user
+	for a := range authors {
+		sortedAuthors = append(sortedAuthors, a)
+	}
+	sort.Slice(sortedAuthors, func(i, j int) bool {
+		return strings.Compare(sortedAuthors[i].Email, sortedAuthors[j].Email) < 0
+	})
+
+	var acommits []acommit
+	var lastMasterPR *pr
+	masterPRStart := -1
+	var lastBackportPR *pr
+	backportPRStart := -1
+	for i, c := range commits {
+		// TODO(benesch): these rowspan computations hurt to look at.
+		masterPR := re.masterPRs[string(c.sha)]
+		// TODO(benesch): masterPR should never be nil!
+		if masterPR != nil && (lastMasterPR == nil || lastMasterPR.number != masterPR.number) {
+			if masterPRStart >= 0 {
+				acommits[masterPRStart].MasterPRRowSpan = i - masterPRStart
+			}
+			masterPRStart = i
+			lastMasterPR = masterPR
+		}
+		backportPR := re.branchPRs[c.MessageID()][branch]
+		if !((lastBackportPR == nil && backportPR == nil && lastMasterPR != masterPR) || (lastBackportPR != nil && backportPR != nil && lastBackportPR.number == backportPR.number)) {
+			if backportPRStart >= 0 {
+				acommits[backportPRStart].BackportPRRowSpan = i - backportPRStart
+			}
+			backportPRStart = i
+			lastBackportPR = backportPR
+		}
+
+		var backportStatus string
+		if backportPR != nil {
+			if backportPR.mergedAt.Valid {
+				backportStatus = "✓"
+			} else {
+				backportStatus = "◷"
+			}
+		}
+		// TODO(benesch): redundant. which to keep?
+		_, backported := re.branchCommits[branch].messageIDs[c.MessageID()]
+		if backported {
+			backportStatus = "✓"
+		}
+		var excl *exclusion
+		if e, ok := exclusions[c.MessageID()]; ok && backportPR == nil && !backported {
+			excl = &e
+			backportStatus = "✗"
+		}
+		ac := acommit{
+			commit:         c,
+			BackportStatus: backportStatus,
+			MasterPR:       masterPR,
+			BackportPR:     backportPR,
+			Backportable:   backportPR == nil && excl == nil,
+			Exclusion:      excl,
+			Comments:       comments[c.MessageID()],
+			Gaps:           gaps[string(c.sha)],
+		}
+		if p, ok := predictions[string(c.sha)]; ok && ac.Backportable && !backported {
+			ac.Prediction = &p
+		}
+		if backported {
+			ac.Tag = re.firstTags[branch][c.MessageID()]
+		}
+		if backportStatus != "✓" {
+			ac.Prerequisites = re.prerequisites(branch, deps[string(c.sha)])
+		}
+		for _, comp := range components {
+			if comp.touches(c.paths) {
+				ac.Components = append(ac.Components, comp.Name)
+			}
+		}
+		acommits = append(acommits, ac)
+	}
+	if masterPRStart >= 0 {
+		acommits[masterPRStart].MasterPRRowSpan = len(acommits) - masterPRStart
+	}
+	if backportPRStart >= 0 {
+		acommits[backportPRStart].BackportPRRowSpan = len(acommits) - backportPRStart
+	}
+
+	return &board{
+		Commits:    acommits,
+		Authors:    sortedAuthors,
+		Author:     author,
+		Components: components,
+		LatestTag:  re.latestTag(branch),
+	}, nil
+}
+
+// Missing reports whether c has yet to land on the board's branch and has not
+// been excluded from it.
+func (c acommit) Missing() bool {
+	return c.BackportStatus != "✓" && c.Exclusion == nil
+}
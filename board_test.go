@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// boardRow summarizes an acommit for comparison in tests.
+type boardRow struct {
+	title             string
+	masterPR          int
+	masterPRRowSpan   int
+	backportPR        int
+	backportPRRowSpan int
+	status            string
+	backportable      bool
+}
+
+func boardRows(b *board) []boardRow {
+	var rows []boardRow
+	for _, c := range b.Commits {
+		row := boardRow{
+			title:             c.Title(),
+			masterPRRowSpan:   c.MasterPRRowSpan,
+			backportPRRowSpan: c.BackportPRRowSpan,
+			status:            c.BackportStatus,
+			backportable:      c.Backportable,
+		}
+		if c.MasterPR != nil {
+			row.masterPR = c.MasterPR.number
+		}
+		if c.BackportPR != nil {
+			row.backportPR = c.BackportPR.number
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func checkBoard(t *testing.T, b *board, expected []boardRow) {
+	t.Helper()
+	actual := boardRows(b)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d rows, got %d: %+v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("row %d: expected %+v, got %+v", i, expected[i], actual[i])
+		}
+	}
+}
+
+// setupBackports builds an upstream with a release branch cut from the
+// initial commit, three master PRs, and two backport PRs:
+//
+//	#1 alice  a             merged to master, backported by #4 (merged)
+//	#2 bob    b1, b2        merged to master, b1 backported by #5 (open)
+//	#3 alice  c             merged to master
+func setupBackports(e *testEnv) {
+	u := e.upstream
+	u.branch("release-1.0")
+	pr1, shas1 := u.openPR("alice@example.com", "master", "fix a",
+		testCommit{title: "a", file: "a.txt", content: "a\n"})
+	u.merge(pr1)
+	pr2, shas2 := u.openPR("bob@example.com", "master", "feature b",
+		testCommit{title: "b1", file: "b.txt", content: "b1\n"},
+		testCommit{title: "b2", file: "b.txt", content: "b1\nb2\n"})
+	u.merge(pr2)
+	pr3, _ := u.openPR("alice@example.com", "master", "fix c",
+		testCommit{title: "c", file: "c.txt", content: "c\n"})
+	u.merge(pr3)
+	pr4, _ := u.openPR("alice@example.com", "release-1.0", "release-1.0: fix a",
+		testCommit{cherryPick: shas1[0]})
+	u.merge(pr4)
+	u.openPR("bob@example.com", "release-1.0", "release-1.0: feature b",
+		testCommit{cherryPick: shas2[0]})
+}
+
+func TestBoard(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		setupBackports(e)
+		e.sync()
+
+		if branches := e.repo().releaseBranches; len(branches) != 1 || branches[0] != "release-1.0" {
+			t.Fatalf("unexpected release branches %v", branches)
+		}
+
+		t.Run("all authors", func(t *testing.T) {
+			checkBoard(t, e.board(boardOptions{branch: "release-1.0"}), []boardRow{
+				{title: "c", masterPR: 3, masterPRRowSpan: 1, backportPRRowSpan: 1, backportable: true},
+				{title: "b2", masterPR: 2, masterPRRowSpan: 2, backportPRRowSpan: 1, backportable: true},
+				{title: "b1", masterPR: 2, backportPR: 5, backportPRRowSpan: 1, status: "◷"},
+				{title: "a", masterPR: 1, masterPRRowSpan: 1, backportPR: 4, backportPRRowSpan: 1, status: "✓"},
+			})
+		})
+
+		t.Run("author filter", func(t *testing.T) {
+			b := e.board(boardOptions{branch: "release-1.0", author: "bob@example.com"})
+			checkBoard(t, b, []boardRow{
+				{title: "b2", masterPR: 2, masterPRRowSpan: 2, backportPRRowSpan: 1, backportable: true},
+				{title: "b1", masterPR: 2, backportPR: 5, backportPRRowSpan: 1, status: "◷"},
+			})
+			if len(b.Authors) != 2 {
+				t.Errorf("expected both authors to remain selectable, got %v", b.Authors)
+			}
+		})
+
+		t.Run("my commits", func(t *testing.T) {
+			me := &identity{Login: "alice", Emails: []string{"alice@example.com"}}
+			checkBoard(t, e.board(boardOptions{branch: "release-1.0", me: me}), []boardRow{
+				{title: "c", masterPR: 3, masterPRRowSpan: 1, backportPRRowSpan: 1, backportable: true},
+				{title: "a", masterPR: 1, masterPRRowSpan: 1, backportPR: 4, backportPRRowSpan: 1, status: "✓"},
+			})
+
+			// Commits under each of my addresses are shown, and the most
+			// preferred address is selected.
+			me = &identity{Login: "alice", Emails: []string{"nobody@example.com", "BOB@example.com", "alice@example.com"}}
+			b := e.board(boardOptions{branch: "release-1.0", me: me})
+			if len(b.Commits) != 4 {
+				t.Errorf("expected the commits of both addresses, got %d", len(b.Commits))
+			}
+			if b.Author.Email != "bob@example.com" {
+				t.Errorf("expected bob@example.com to be selected, got %q", b.Author)
+			}
+		})
+
+		t.Run("serve", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			(&server{store: st}).ServeHTTP(w, httptest.NewRequest("GET", "/?branch=release-1.0", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+			for _, c := range e.board(boardOptions{branch: "release-1.0"}).Commits {
+				if !strings.Contains(w.Body.String(), c.SHA().String()) {
+					t.Errorf("board does not mention %s", c.SHA())
+				}
+			}
+		})
+
+		t.Run("status", func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := printStatus(&buf, e.board(boardOptions{branch: "release-1.0"}), "json", true); err != nil {
+				t.Fatal(err)
+			}
+			var rows []statusRow
+			if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, r := range rows {
+				actual = append(actual, r.Title+": "+r.Status)
+			}
+			expected := []string{"c: missing", "b2: missing", "b1: in progress"}
+			if strings.Join(actual, ", ") != strings.Join(expected, ", ") {
+				t.Errorf("expected %q, got %q", expected, actual)
+			}
+		})
+
+		t.Run("merging a backport", func(t *testing.T) {
+			e.upstream.merge(e.gh.prs[5])
+			e.sync()
+			rows := boardRows(e.board(boardOptions{branch: "release-1.0", author: "bob@example.com"}))
+			if rows[1].title != "b1" || rows[1].status != "✓" {
+				t.Errorf("expected b1 to be backported, got %+v", rows[1])
+			}
+		})
+	})
+}
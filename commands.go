@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+// A command is a backboard subcommand.
+type command struct {
+	name string
+	// args is a synopsis of the command's positional arguments.
+	args    string
+	summary string
+	// setup registers the command's flags on fs and returns a function that
+	// runs the command with the positional arguments that remain once the
+	// flags are parsed.
+	setup func(fs *flag.FlagSet, cfg *config) func(ctx context.Context, args []string) error
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init because setupHelp refers to commands.
+	commands = []command{
+		{name: "serve", summary: "serve the board, syncing tracked repos in the background", setup: setupServe},
+		{name: "sync", summary: "sync tracked repos once", setup: setupSync},
+		{name: "migrate", args: "[status|up]", summary: "show or apply database migrations", setup: setupMigrate},
+		{name: "repos add", args: "<owner>/<name>", summary: "track a GitHub repo", setup: setupReposAdd},
+		{name: "repos remove", args: "<owner>/<name>", summary: "stop tracking a GitHub repo", setup: setupReposRemove},
+		{name: "repos list", summary: "list tracked GitHub repos", setup: setupReposList},
+		{name: "components set", args: "<owner>/<name> <component> <glob>...", summary: "define a component of a repo by the globs its files match", setup: setupComponentsSet},
+		{name: "components remove", args: "<owner>/<name> <component>", summary: "remove a component of a repo", setup: setupComponentsRemove},
+		{name: "components list", args: "<owner>/<name>", summary: "list the components of a repo", setup: setupComponentsList},
+		{name: "roles grant", args: "<owner>/<name> <user> <role>", summary: "grant a user a role on a repo or one of its release branches", setup: setupRolesGrant},
+		{name: "roles list", args: "<owner>/<name>", summary: "list the roles granted on a repo", setup: setupRolesList},
+		{name: "webhooks add", args: "<url>", summary: "post events about backports to a URL", setup: setupWebhooksAdd},
+		{name: "webhooks remove", args: "<url>", summary: "stop posting events to a URL", setup: setupWebhooksRemove},
+		{name: "webhooks list", summary: "list the URLs that events are posted to", setup: setupWebhooksList},
+		{name: "status", summary: "print the board for a release branch, failing if backports are missing", setup: setupStatus},
+		{name: "notify", summary: "remind authors of the backports their PRs requested that nobody has opened", setup: setupNotify},
+		{name: "check", summary: "check for backports that skipped a newer release branch, failing if any did", setup: setupCheck},
+		{name: "release-notes", summary: "draft release notes for the changes that landed on a release branch", setup: setupReleaseNotes},
+		{name: "help", args: "[<command>]", summary: "show help for a command", setup: setupHelp},
+	}
+}
+
+// config is the configuration shared by several commands. Each setting can be
+// provided by a flag or by the environment variable in flagEnvVars, with the
+// flag taking precedence.
+type config struct {
+	db           string
+	githubToken  string
+	listenAddr   string
+	syncInterval time.Duration
+	// staleAfter is the time after which authors are reminded of requested
+	// backports, every notifyInterval.
+	staleAfter     time.Duration
+	notifyInterval time.Duration
+	// commitStatuses is the commitStatuses* mode in which backport progress
+	// is reported on master PRs.
+	commitStatuses string
+}
+
+var flagEnvVars = map[string]string{
+	"db":              "BACKBOARD_DB",
+	"github-token":    "BACKBOARD_GITHUB_TOKEN",
+	"listen":          "BACKBOARD_LISTEN_ADDR",
+	"clone-dir":       "BACKBOARD_CLONE_DIR",
+	"sync-interval":   "BACKBOARD_SYNC_INTERVAL",
+	"stale-after":     "BACKBOARD_STALE_AFTER",
+	"notify-interval": "BACKBOARD_NOTIFY_INTERVAL",
+	"commit-statuses": "BACKBOARD_COMMIT_STATUSES",
+}
+
+func envUsage(name, usage string) string {
+	return fmt.Sprintf("%s (env %s)", usage, flagEnvVars[name])
+}
+
+func (c *config) dbFlag(fs *flag.FlagSet) {
+	fs.StringVar(&c.db, "db", "", envUsage("db",
+		"database: a postgres URL for CockroachDB or PostgreSQL, or sqlite:<path>"))
+}
+
+func (c *config) githubTokenFlag(fs *flag.FlagSet) {
+	fs.StringVar(&c.githubToken, "github-token", "", envUsage("github-token", "GitHub API token"))
+}
+
+func cloneDirFlag(fs *flag.FlagSet) {
+	fs.StringVar(&cloneDir, "clone-dir", cloneDir, envUsage("clone-dir", "directory for repo mirrors"))
+}
+
+// applyEnv sets each flag in fs that was not given on the command line from
+// its environment variable, if set.
+func applyEnv(fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env, ok := flagEnvVars[f.Name]
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if v := os.Getenv(env); v != "" {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid %s: %s", env, setErr)
+			}
+		}
+	})
+	return err
+}
+
+func (c *config) openStore(ctx context.Context) (store, error) {
+	if c.db == "" {
+		return nil, errors.New("no database specified; set --db or BACKBOARD_DB")
+	}
+	return openStore(ctx, c.db)
+}
+
+func (c *config) githubClient(ctx context.Context) (*github.Client, error) {
+	if c.githubToken == "" {
+		return nil, errors.New("no GitHub token specified; set --github-token or BACKBOARD_GITHUB_TOKEN")
+	}
+	// Repos that clone with token credentials read the token from the
+	// environment, so make a token given as a flag visible there too.
+	if os.Getenv(defaultTokenEnvVar) == "" {
+		os.Setenv(defaultTokenEnvVar, c.githubToken)
+	}
+	httpClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: c.githubToken},
+	))
+	httpClient.Transport = githubTransport{base: httpClient.Transport}
+	return github.NewClient(httpClient), nil
+}
+
+// usageError is returned when a command is invoked incorrectly.
+type usageError struct {
+	cmd string
+	msg string
+}
+
+func (e usageError) Error() string {
+	if e.cmd == "" {
+		return fmt.Sprintf("%s; see \"backboard help\"", e.msg)
+	}
+	return fmt.Sprintf("%s; see \"backboard help %s\"", e.msg, e.cmd)
+}
+
+// findCommand returns the command named by the leading words of args, and
+// the remaining arguments.
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func runCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		printCommands(os.Stderr)
+		return usageError{msg: "no command specified"}
+	}
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		return usageError{msg: fmt.Sprintf("unknown command %q", strings.Join(args, " "))}
+	}
+	var cfg config
+	fs := flag.NewFlagSet("backboard "+cmd.name, flag.ContinueOnError)
+	runFn := cmd.setup(fs, &cfg)
+	fs.Usage = func() { printCommandHelp(fs.Output(), cmd, fs) }
+	if err := fs.Parse(rest); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return usageError{cmd: cmd.name, msg: err.Error()}
+	}
+	if err := applyEnv(fs); err != nil {
+		return err
+	}
+	return runFn(ctx, fs.Args())
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "usage: backboard <command> [<flags>] [<args>]\n\ncommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun \"backboard help <command>\" for a command's flags.\n")
+}
+
+func printCommandHelp(w io.Writer, cmd *command, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "usage: backboard %s", cmd.name)
+	var hasFlags bool
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(w, " [<flags>]")
+	}
+	if cmd.args != "" {
+		fmt.Fprintf(w, " %s", cmd.args)
+	}
+	fmt.Fprintf(w, "\n\n%s.\n", strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
+	if hasFlags {
+		fmt.Fprintf(w, "\nflags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+// checkArgs returns a usage error if args does not have between min and max
+// elements.
+func checkArgs(cmd string, args []string, min, max int) error {
+	if len(args) < min {
+		return usageError{cmd: cmd, msg: "missing arguments"}
+	} else if len(args) > max {
+		return usageError{cmd: cmd, msg: fmt.Sprintf("unexpected arguments %q", args[max:])}
+	}
+	return nil
+}
+
+func setupHelp(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	return func(ctx context.Context, args []string) error {
+		if len(args) == 0 {
+			printCommands(os.Stdout)
+			return nil
+		}
+		cmd, rest := findCommand(args)
+		if cmd == nil || len(rest) > 0 {
+			return usageError{msg: fmt.Sprintf("unknown command %q", strings.Join(args, " "))}
+		}
+		cmdFS := flag.NewFlagSet("backboard "+cmd.name, flag.ContinueOnError)
+		cmd.setup(cmdFS, &config{})
+		printCommandHelp(os.Stdout, cmd, cmdFS)
+		return nil
+	}
+}
+
+func setupServe(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	cfg.githubTokenFlag(fs)
+	cloneDirFlag(fs)
+	fs.StringVar(&cfg.listenAddr, "listen", ":8080", envUsage("listen", "address to serve the board on"))
+	fs.DurationVar(&cfg.syncInterval, "sync-interval", 30*time.Second,
+		envUsage("sync-interval", "time to wait between syncs"))
+	cfg.staleAfterFlag(fs)
+	fs.DurationVar(&cfg.notifyInterval, "notify-interval", time.Hour,
+		envUsage("notify-interval", "time to wait between checks for stale backports, if notifications are configured"))
+	cfg.commitStatusesFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("serve", args, 0, 0); err != nil {
+			return err
+		}
+		notifiers, err := notifiersFromEnv()
+		if err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		ghClient, err := cfg.githubClient(ctx)
+		if err != nil {
+			return err
+		}
+		auth, err := authConfigFromEnv()
+		if err != nil {
+			return err
+		}
+		reporter, err := newStatusReporter(cfg.commitStatuses, ghClient, st)
+		if err != nil {
+			return usageError{cmd: "serve", msg: err.Error()}
+		}
+		if err := loadRepos(ctx, st, ""); err != nil {
+			return err
+		}
+		if err := bootstrap(ctx, st); err != nil {
+			return fmt.Errorf("while bootstrapping: %s", err)
+		}
+		go syncLoop(ctx, ghClient, st, cfg.syncInterval, reporter)
+		go webhookLoop(ctx, st, webhookPollInterval)
+		if len(notifiers) > 0 {
+			go notifyLoop(ctx, st, notifiers, cfg.staleAfter, cfg.notifyInterval)
+		}
+		http.Handle("/", &server{store: st, auth: auth, gh: ghClient})
+		log.Printf("listening on %s", cfg.listenAddr)
+		return http.ListenAndServe(cfg.listenAddr, nil)
+	}
+}
+
+func setupSync(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	cfg.githubTokenFlag(fs)
+	cloneDirFlag(fs)
+	only := fs.String("repo", "", "sync only this `owner/name` repo")
+	cfg.commitStatusesFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("sync", args, 0, 0); err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		ghClient, err := cfg.githubClient(ctx)
+		if err != nil {
+			return err
+		}
+		if err := loadRepos(ctx, st, *only); err != nil {
+			return err
+		}
+		reporter, err := newStatusReporter(cfg.commitStatuses, ghClient, st)
+		if err != nil {
+			return usageError{cmd: "sync", msg: err.Error()}
+		}
+		if err := bootstrap(ctx, st); err != nil {
+			return fmt.Errorf("while bootstrapping: %s", err)
+		}
+		if err := syncAll(ctx, ghClient, st); err != nil {
+			return err
+		}
+		if reporter != nil {
+			return reporter.reportAll(ctx)
+		}
+		return nil
+	}
+}
+
+func setupMigrate(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("migrate", args, 0, 1); err != nil {
+			return err
+		}
+		if cfg.db == "" {
+			return errors.New("no database specified; set --db or BACKBOARD_DB")
+		}
+		db, err := openDatabase(ctx, cfg.db)
+		if err != nil {
+			return err
+		}
+		cmd := "status"
+		if len(args) == 1 {
+			cmd = args[0]
+		}
+		switch cmd {
+		case "status":
+			return printMigrationStatus(ctx, db, os.Stdout)
+		case "up":
+			if err := migrate(ctx, db); err != nil {
+				return err
+			}
+			return printMigrationStatus(ctx, db, os.Stdout)
+		default:
+			return usageError{cmd: "migrate", msg: fmt.Sprintf("unknown migrate command %q", cmd)}
+		}
+	}
+}
+
+// parseRepoName splits an "owner/name" repo name.
+func parseRepoName(cmd, s string) (owner, name string, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", usageError{cmd: cmd, msg: fmt.Sprintf("malformed repo %q, want <owner>/<name>", s)}
+	}
+	return parts[0], parts[1], nil
+}
+
+func setupReposAdd(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	spec := fs.String("credentials", "none",
+		"`spec` for authenticating clones: none, token[:ENV_VAR] or ssh:KEY_PATH")
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("repos add", args, 1, 1); err != nil {
+			return err
+		}
+		owner, name, err := parseRepoName("repos add", args[0])
+		if err != nil {
+			return err
+		}
+		creds, err := parseCredentials(*spec)
+		if err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		return st.putRepo(ctx, repoRecord{owner: owner, name: name, credentials: creds.String()})
+	}
+}
+
+func setupReposRemove(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("repos remove", args, 1, 1); err != nil {
+			return err
+		}
+		owner, name, err := parseRepoName("repos remove", args[0])
+		if err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		return st.untrackRepo(ctx, owner, name)
+	}
+}
+
+func setupReposList(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("repos list", args, 0, 0); err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		records, err := st.trackedRepos(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "REPO\tCREDENTIALS\n")
+		for _, r := range records {
+			fmt.Fprintf(tw, "%s/%s\t%s\n", r.owner, r.name, r.credentials)
+		}
+		return tw.Flush()
+	}
+}
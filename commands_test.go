@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestReposCommands(t *testing.T) {
+	ctx := context.Background()
+	db := sqlitePrefix + filepath.Join(tempDir(t), "backboard.db")
+	oldRepos := repos
+	defer func() { repos = oldRepos }()
+
+	for _, args := range [][]string{
+		{"repos", "add", "--db", db, "acme/widget"},
+		{"repos", "add", "--db", db, "--credentials", "ssh:/keys/gadget", "acme/gadget"},
+		{"repos", "add", "--db", db, "acme/gizmo"},
+		{"repos", "remove", "--db", db, "acme/gizmo"},
+	} {
+		if err := runCommand(ctx, args); err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+	}
+	if err := runCommand(ctx, []string{"repos", "remove", "--db", db, "acme/gizmo"}); err == nil {
+		t.Errorf("expected removing an untracked repo to fail")
+	}
+	if err := runCommand(ctx, []string{"repos", "add", "--db", db, "widget"}); err == nil {
+		t.Errorf("expected a malformed repo name to be rejected")
+	}
+
+	st, err := openStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.(*sqlStore).db.Close()
+	if err := loadRepos(ctx, st, ""); err != nil {
+		t.Fatal(err)
+	}
+	var loaded []string
+	for _, re := range repos {
+		loaded = append(loaded, re.String()+" "+re.credentials.String())
+	}
+	expected := []string{"acme/gadget ssh:/keys/gadget", "acme/widget none"}
+	if len(loaded) != len(expected) || loaded[0] != expected[0] || loaded[1] != expected[1] {
+		t.Errorf("expected repos %q, got %q", expected, loaded)
+	}
+
+	if err := loadRepos(ctx, st, "acme/gizmo"); err == nil {
+		t.Errorf("expected loading an untracked repo to fail")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// commitStatusContext labels backboard's commit statuses on GitHub.
+const commitStatusContext = "backboard/backports"
+
+// commitStatus is a GitHub commit status.
+type commitStatus struct {
+	State       string
+	Description string
+}
+
+// backportProgress is the progress of the backports of a master PR.
+type backportProgress struct {
+	PR prRecord
+	// Done are the release branches that every commit of the PR has been
+	// backported or excluded from, and Pending the others. Both are ordered
+	// newest first.
+	Done    []string
+	Pending []string
+}
+
+// status returns the commit status that reports p, e.g. "backports: 2/3
+// branches done, release-22.2 pending".
+func (p backportProgress) status() commitStatus {
+	s := commitStatus{
+		State:       "success",
+		Description: fmt.Sprintf("backports: %d/%d branches done", len(p.Done), len(p.Done)+len(p.Pending)),
+	}
+	if len(p.Pending) > 0 {
+		s.State = "pending"
+		s.Description += ", " + strings.Join(p.Pending, ", ") + " pending"
+	}
+	// GitHub rejects descriptions longer than 140 characters.
+	if r := []rune(s.Description); len(r) > 140 {
+		s.Description = string(r[:139]) + "…"
+	}
+	return s
+}
+
+// findBackportProgress returns the progress of the backports of the master
+// PRs in re that have any: those that requested a backport, or whose commits
+// have a backport PR or have landed on a release branch. Callers must hold
+// repoLock or own re.
+func findBackportProgress(ctx context.Context, st store, re repo) ([]backportProgress, error) {
+	prs, prCommits, err := backportableMasterPRs(ctx, st, re)
+	if err != nil {
+		return nil, err
+	}
+	candidates := map[string]map[string]bool{}
+	exclusions := map[string]map[string]exclusion{}
+	for _, branch := range re.releaseBranches {
+		candidates[branch] = re.candidateSHAs(branch)
+		exclusions[branch], err = st.exclusions(ctx, re.id, branch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out []backportProgress
+	for _, p := range prs {
+		requested := map[string]bool{}
+		for _, branch := range requestedBranches(re, p) {
+			requested[branch] = true
+		}
+		progress := backportProgress{PR: p}
+		for _, branch := range re.releaseBranches {
+			target, done := requested[branch], true
+			for _, c := range prCommits[p.number] {
+				if !candidates[branch][string(c.sha)] {
+					continue
+				}
+				_, landed := re.branchCommits[branch].messageIDs[c.MessageID()]
+				_, excluded := exclusions[branch][c.MessageID()]
+				bp := re.branchPRs[c.MessageID()][branch]
+				if landed || bp != nil {
+					target = true
+				}
+				if !landed && !excluded && (bp == nil || !bp.mergedAt.Valid) {
+					done = false
+				}
+			}
+			switch {
+			case !target:
+			case done:
+				progress.Done = append(progress.Done, branch)
+			default:
+				progress.Pending = append(progress.Pending, branch)
+			}
+		}
+		if len(progress.Done)+len(progress.Pending) > 0 {
+			out = append(out, progress)
+		}
+	}
+	return out, nil
+}
+
+const (
+	commitStatusesOff    = "off"
+	commitStatusesDryRun = "dry-run"
+	commitStatusesOn     = "on"
+)
+
+// statusReporter reports the progress of master PRs' backports in commit
+// statuses on their heads.
+type statusReporter struct {
+	gh *github.Client
+	st store
+	// dryRun, if set, makes the reporter log the statuses it would post
+	// instead. logged remembers them, so that each is logged once.
+	dryRun bool
+	logged map[statusKey]commitStatus
+}
+
+type statusKey struct {
+	repoID int64
+	sha    string
+}
+
+// newStatusReporter returns a reporter for the given mode, which is one of
+// commitStatusesOff, commitStatusesDryRun or commitStatusesOn. It returns nil
+// if mode is commitStatusesOff.
+func newStatusReporter(mode string, gh *github.Client, st store) (*statusReporter, error) {
+	switch mode {
+	case commitStatusesOff:
+		return nil, nil
+	case commitStatusesDryRun, commitStatusesOn:
+		return &statusReporter{
+			gh:     gh,
+			st:     st,
+			dryRun: mode == commitStatusesDryRun,
+			logged: map[statusKey]commitStatus{},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown commit status mode %q", mode)
+	}
+}
+
+// report posts the statuses of the master PRs in re whose backports have
+// progressed since their statuses were last posted. Callers must hold
+// repoLock or own re.
+func (r *statusReporter) report(ctx context.Context, re repo) error {
+	progress, err := findBackportProgress(ctx, r.st, re)
+	if err != nil {
+		return err
+	}
+	posted, err := r.st.commitStatuses(ctx, re.id)
+	if err != nil {
+		return err
+	}
+	for _, p := range progress {
+		if p.PR.headSHA == "" {
+			// Synced before head SHAs were recorded.
+			continue
+		}
+		s, key := p.status(), statusKey{re.id, p.PR.headSHA}
+		if posted[p.PR.headSHA] == s || (r.dryRun && r.logged[key] == s) {
+			continue
+		}
+		if r.dryRun {
+			log.Printf("dry run: would set status of %s#%d (%s) to %s: %s",
+				re, p.PR.number, p.PR.headSHA, s.State, s.Description)
+			r.logged[key] = s
+			continue
+		}
+		if _, _, err := r.gh.Repositories.CreateStatus(ctx, re.githubOwner, re.githubRepo, p.PR.headSHA,
+			&github.RepoStatus{
+				State:       github.String(s.State),
+				Description: github.String(s.Description),
+				Context:     github.String(commitStatusContext),
+			}); err != nil {
+			return fmt.Errorf("setting status of %s#%d: %s", re, p.PR.number, err)
+		}
+		if err := r.st.putCommitStatus(ctx, re.id, p.PR.headSHA, s, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportAll reports the statuses of the master PRs in every repo.
+func (r *statusReporter) reportAll(ctx context.Context) error {
+	repoLock.RLock()
+	snapshot := append([]repo(nil), repos...)
+	repoLock.RUnlock()
+	for _, re := range snapshot {
+		if err := r.report(ctx, re); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *config) commitStatusesFlag(fs *flag.FlagSet) {
+	fs.StringVar(&c.commitStatuses, "commit-statuses", commitStatusesOff,
+		envUsage("commit-statuses", "whether to report the progress of each merged master PR's backports in a commit "+
+			"status on its head: off, on, or dry-run to log the statuses instead"))
+}
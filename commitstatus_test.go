@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestCommitStatuses(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		setupBackports(e)
+		e.gh.updatePR(3, func(pr *github.PullRequest) {
+			pr.Labels = []*github.Label{{Name: github.String("backport-1.0")}}
+		})
+		e.sync()
+
+		report := func(mode string) []string {
+			t.Helper()
+			r, err := newStatusReporter(mode, e.gh.client(), st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.reportAll(e.ctx); err != nil {
+				t.Fatal(err)
+			}
+			return e.gh.takeStatuses()
+		}
+		expect := func(actual []string, statuses map[int]string) {
+			t.Helper()
+			var expected []string
+			for number, s := range statuses {
+				expected = append(expected, e.gh.pr(number).GetHead().GetSHA()+" backboard/backports "+s)
+			}
+			sort.Strings(expected)
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected statuses %q, got %q", expected, actual)
+			}
+		}
+
+		// A dry run posts nothing.
+		expect(report(commitStatusesDryRun), nil)
+
+		// #1's backport merged and #2's is open. #3 requested a backport
+		// that nobody has opened.
+		expect(report(commitStatusesOn), map[int]string{
+			1: "success: backports: 1/1 branches done",
+			2: "pending: backports: 0/1 branches done, release-1.0 pending",
+			3: "pending: backports: 0/1 branches done, release-1.0 pending",
+		})
+		expect(report(commitStatusesOn), nil)
+
+		// #2 remains pending until b2 is backported too, or excluded.
+		e.upstream.merge(e.gh.pr(5))
+		e.sync()
+		expect(report(commitStatusesOn), nil)
+		re := e.repo()
+		for _, c := range re.masterCommits.commits {
+			if c.title != "b2" {
+				continue
+			}
+			if err := st.putExclusion(e.ctx, re.id, "release-1.0", c.MessageID(), exclusion{CreatedBy: "carol"},
+				event{RepoID: re.id, Branch: "release-1.0", Kind: eventExcluded}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expect(report(commitStatusesOn), map[int]string{
+			2: "success: backports: 1/1 branches done",
+		})
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+)
+
+// A component is a named part of a repo, like "sql" or "storage", made up of
+// the files that match any of its globs. Globs are matched against paths
+// relative to the root of the repo, using path.Match syntax for each path
+// segment, plus "**", which matches any number of segments. "pkg/sql/**"
+// matches every file beneath pkg/sql.
+type component struct {
+	Name  string
+	Globs []string
+}
+
+func parseGlobs(encoded string) []string {
+	if encoded == "" {
+		return nil
+	}
+	return strings.Split(encoded, "\n")
+}
+
+// encodeGlobs returns the representation of globs stored by the store.
+func encodeGlobs(globs []string) string {
+	return strings.Join(globs, "\n")
+}
+
+// validateGlob returns an error if glob is malformed.
+func validateGlob(glob string) error {
+	if glob == "" {
+		return errors.New("empty glob")
+	}
+	for _, seg := range strings.Split(glob, "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("malformed glob %q", glob)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the file p matches glob.
+func matchGlob(glob, p string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(globSegs, pathSegs []string) bool {
+	for len(globSegs) > 0 {
+		if globSegs[0] == "**" {
+			for i := 0; i <= len(pathSegs); i++ {
+				if matchSegments(globSegs[1:], pathSegs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(pathSegs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(globSegs[0], pathSegs[0]); !ok {
+			return false
+		}
+		globSegs, pathSegs = globSegs[1:], pathSegs[1:]
+	}
+	return len(pathSegs) == 0
+}
+
+// touches reports whether any of paths belongs to comp.
+func (comp component) touches(paths []string) bool {
+	for _, p := range paths {
+		for _, g := range comp.Globs {
+			if matchGlob(g, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// touchesPrefix reports whether any of paths begins with prefix.
+func touchesPrefix(paths []string, prefix string) bool {
+	for _, p := range paths {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackedRepoID returns the ID of the tracked repo owner/name.
+func trackedRepoID(ctx context.Context, st store, owner, name string) (int64, error) {
+	records, err := st.trackedRepos(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range records {
+		if r.owner == owner && r.name == name {
+			return r.id, nil
+		}
+	}
+	return 0, fmt.Errorf("%s/%s is not tracked", owner, name)
+}
+
+func setupComponentsSet(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("components set", args, 3, len(args)); err != nil {
+			return err
+		}
+		owner, name, err := parseRepoName("components set", args[0])
+		if err != nil {
+			return err
+		}
+		comp := component{Name: args[1], Globs: args[2:]}
+		for _, g := range comp.Globs {
+			if err := validateGlob(g); err != nil {
+				return usageError{cmd: "components set", msg: err.Error()}
+			}
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		repoID, err := trackedRepoID(ctx, st, owner, name)
+		if err != nil {
+			return err
+		}
+		return st.putComponent(ctx, repoID, comp)
+	}
+}
+
+func setupComponentsRemove(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("components remove", args, 2, 2); err != nil {
+			return err
+		}
+		owner, name, err := parseRepoName("components remove", args[0])
+		if err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		repoID, err := trackedRepoID(ctx, st, owner, name)
+		if err != nil {
+			return err
+		}
+		return st.deleteComponent(ctx, repoID, args[1])
+	}
+}
+
+func setupComponentsList(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("components list", args, 1, 1); err != nil {
+			return err
+		}
+		owner, name, err := parseRepoName("components list", args[0])
+		if err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		repoID, err := trackedRepoID(ctx, st, owner, name)
+		if err != nil {
+			return err
+		}
+		comps, err := st.components(ctx, repoID)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "COMPONENT\tGLOBS\n")
+		for _, comp := range comps {
+			fmt.Fprintf(tw, "%s\t%s\n", comp.Name, strings.Join(comp.Globs, " "))
+		}
+		return tw.Flush()
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		glob, path string
+		expected   bool
+	}{
+		{"pkg/sql/**", "pkg/sql/parser/parse.go", true},
+		{"pkg/sql/**", "pkg/sql/exec.go", true},
+		{"pkg/sql/**", "pkg/sqlmigrations/m.go", false},
+		{"pkg/*/BUILD", "pkg/sql/BUILD", true},
+		{"pkg/*/BUILD", "pkg/sql/parser/BUILD", false},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/rfcs/x.md", true},
+		{"docs/**/*.png", "docs/a.png", true},
+		{"Makefile", "build/Makefile", false},
+	} {
+		if actual := matchGlob(tc.glob, tc.path); actual != tc.expected {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", tc.glob, tc.path, actual, tc.expected)
+		}
+	}
+}
+
+func TestComponentFilters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		setupBackports(e)
+		e.sync()
+		repoID := e.repo().id
+		if err := st.putComponent(e.ctx, repoID, component{Name: "bee", Globs: []string{"b.*"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.putComponent(e.ctx, repoID, component{Name: "vowels", Globs: []string{"a.txt", "**/e.txt"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		titles := func(opts boardOptions) []string {
+			t.Helper()
+			opts.branch = "release-1.0"
+			var out []string
+			for _, c := range e.board(opts).Commits {
+				out = append(out, c.Title())
+			}
+			return out
+		}
+		if actual, expected := titles(boardOptions{component: "bee"}), []string{"b2", "b1"}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("component filter: expected %v, got %v", expected, actual)
+		}
+		if actual, expected := titles(boardOptions{path: "c."}), []string{"c"}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("path filter: expected %v, got %v", expected, actual)
+		}
+		if actual := titles(boardOptions{component: "vowels", path: "b"}); len(actual) != 0 {
+			t.Errorf("combined filters: expected no commits, got %v", actual)
+		}
+
+		// Paths are only loaded for the commits made since the branch was
+		// cut.
+		re := e.repo()
+		candidates := map[string]bool{}
+		for _, c := range re.masterCommits.truncate(re.branchMergeBases["release-1.0"]) {
+			candidates[string(c.sha)] = true
+		}
+		for _, c := range re.masterCommits.commits {
+			if !c.merge && candidates[string(c.sha)] != (len(c.paths) > 0) {
+				t.Errorf("%s: unexpected paths %q", c.title, c.paths)
+			}
+		}
+
+		s := &server{store: st}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/?repo=%d&component=vowels&format=json", repoID), nil))
+		var rows []statusRow
+		if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+			t.Fatalf("%s: %s", err, w.Body)
+		}
+		if len(rows) != 1 || rows[0].Title != "a" || !reflect.DeepEqual(rows[0].Components, []string{"vowels"}) {
+			t.Errorf("unexpected JSON board %+v", rows)
+		}
+	})
+}
+
+func TestComponentsCommands(t *testing.T) {
+	ctx := context.Background()
+	db := sqlitePrefix + filepath.Join(tempDir(t), "backboard.db")
+	for _, args := range [][]string{
+		{"repos", "add", "--db", db, "acme/widget"},
+		{"components", "set", "--db", db, "acme/widget", "sql", "pkg/sql/**"},
+		{"components", "set", "--db", db, "acme/widget", "kv", "pkg/kv/**"},
+		{"components", "set", "--db", db, "acme/widget", "sql", "pkg/sql/**", "pkg/cmd/sql/**"},
+		{"components", "remove", "--db", db, "acme/widget", "kv"},
+	} {
+		if err := runCommand(ctx, args); err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"components", "remove", "--db", db, "acme/widget", "kv"},
+		{"components", "set", "--db", db, "acme/gadget", "sql", "pkg/sql/**"},
+		{"components", "set", "--db", db, "acme/widget", "sql", "pkg/[sql/**"},
+	} {
+		if err := runCommand(ctx, args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+
+	st, err := openStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.(*sqlStore).db.Close()
+	repoID, err := trackedRepoID(ctx, st, "acme", "widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comps, err := st.components(ctx, repoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []component{{Name: "sql", Globs: []string{"pkg/sql/**", "pkg/cmd/sql/**"}}}
+	if !reflect.DeepEqual(comps, expected) {
+		t.Errorf("expected components %+v, got %+v", expected, comps)
+	}
+}
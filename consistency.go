@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// branchGap is a master commit that was backported to a release branch but
+// skipped newer release branches, which will regress on upgrade from the
+// older branch.
+type branchGap struct {
+	commit
+	// Backported are the release branches the commit was backported to, and
+	// Missing the newer release branches that lack it. Both are ordered
+	// newest first.
+	Backported []string
+	Missing    []string
+}
+
+// findBranchGaps returns the commits whose backports skip one of re's release
+// branches, newest first. A commit is backported to a branch if it landed
+// there or its backport PR merged. A newer branch is not considered to lack
+// it if it was cut after the commit merged, if it has an open backport PR for
+// it, or if the commit has been excluded from it. The gaps are found when re
+// is refreshed; only exclusions, which can change at any time, are applied
+// here. Callers must hold repoLock.
+func findBranchGaps(ctx context.Context, st store, re repo) ([]branchGap, error) {
+	exclusions := map[string]map[string]exclusion{}
+	var gaps []branchGap
+	for _, g := range re.branchGaps {
+		var missing []string
+		for _, branch := range g.Missing {
+			if _, ok := exclusions[branch]; !ok {
+				var err error
+				exclusions[branch], err = st.exclusions(ctx, re.id, branch)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if _, excluded := exclusions[branch][g.MessageID()]; !excluded {
+				missing = append(missing, branch)
+			}
+		}
+		if len(missing) > 0 {
+			g.Missing = missing
+			gaps = append(gaps, g)
+		}
+	}
+	return gaps, nil
+}
+
+// findUnexcludedBranchGaps finds the gaps for findBranchGaps, without regard
+// to exclusions.
+func (r repo) findUnexcludedBranchGaps() []branchGap {
+	candidates := map[string]map[string]bool{}
+	for _, branch := range r.releaseBranches {
+		candidates[branch] = r.candidateSHAs(branch)
+	}
+
+	var gaps []branchGap
+	for _, c := range r.masterCommits.commits {
+		if c.merge {
+			continue
+		}
+		backported := func(branch string) bool {
+			if _, ok := r.branchCommits[branch].messageIDs[c.MessageID()]; ok {
+				return true
+			}
+			p := r.branchPRs[c.MessageID()][branch]
+			return p != nil && p.mergedAt.Valid
+		}
+		// releaseBranches are ordered newest first, so the gaps are the
+		// branches before the last one the commit was backported to.
+		oldest := -1
+		for i, branch := range r.releaseBranches {
+			if candidates[branch][string(c.sha)] && backported(branch) {
+				oldest = i
+			}
+		}
+		if oldest < 0 {
+			continue
+		}
+		g := branchGap{commit: c}
+		for _, branch := range r.releaseBranches[:oldest+1] {
+			if !candidates[branch][string(c.sha)] {
+				continue
+			}
+			switch {
+			case backported(branch):
+				g.Backported = append(g.Backported, branch)
+			case r.branchPRs[c.MessageID()][branch] == nil:
+				g.Missing = append(g.Missing, branch)
+			}
+		}
+		if len(g.Missing) > 0 {
+			gaps = append(gaps, g)
+		}
+	}
+	return gaps
+}
+
+// candidateSHAs returns the SHAs of the master commits that are candidates
+// for backporting to branch, as strings.
+func (r repo) candidateSHAs(branch string) map[string]bool {
+	shas := map[string]bool{}
+	for _, c := range r.masterCommits.truncate(r.branchMergeBases[branch]) {
+		shas[string(c.sha)] = true
+	}
+	return shas
+}
+
+// branchGapsError is returned by the check command when backports skip a
+// release branch.
+type branchGapsError struct {
+	count int
+}
+
+func (e branchGapsError) Error() string {
+	return fmt.Sprintf("%d commits were backported to a release branch but skipped a newer one", e.count)
+}
+
+func setupCheck(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	cloneDirFlag(fs)
+	repoName := fs.String("repo", "", "`owner/name` of the repo (optional if only one repo is tracked)")
+	format := fs.String("format", "table", "output `format`: table or json")
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("check", args, 0, 0); err != nil {
+			return err
+		}
+		if *format != "table" && *format != "json" {
+			return usageError{cmd: "check", msg: fmt.Sprintf("unknown format %q", *format)}
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		if err := loadRepos(ctx, st, *repoName); err != nil {
+			return err
+		}
+		if len(repos) > 1 {
+			return usageError{cmd: "check", msg: "several repos are tracked; choose one with --repo"}
+		}
+		if err := bootstrap(ctx, st); err != nil {
+			return fmt.Errorf("while bootstrapping: %s", err)
+		}
+		gaps, err := findBranchGaps(ctx, st, repos[0])
+		if err != nil {
+			return err
+		}
+		if err := printBranchGaps(os.Stdout, gaps, *format); err != nil {
+			return err
+		}
+		if len(gaps) > 0 {
+			return branchGapsError{count: len(gaps)}
+		}
+		return nil
+	}
+}
+
+// branchGapRow is the JSON representation of a branchGap.
+type branchGapRow struct {
+	SHA        string   `json:"sha"`
+	Title      string   `json:"title"`
+	Author     string   `json:"author"`
+	Backported []string `json:"backported"`
+	Missing    []string `json:"missing"`
+}
+
+// printBranchGaps writes gaps to w in the given format.
+func printBranchGaps(w io.Writer, gaps []branchGap, format string) error {
+	switch format {
+	case "json":
+		rows := []branchGapRow{}
+		for _, g := range gaps {
+			rows = append(rows, branchGapRow{
+				SHA:        g.sha.String(),
+				Title:      g.title,
+				Author:     g.Author.Email,
+				Backported: g.Backported,
+				Missing:    g.Missing,
+			})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "SHA\tBACKPORTED TO\tMISSING FROM\tAUTHOR\tTITLE\n")
+		for _, g := range gaps {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", g.sha.Short(), strings.Join(g.Backported, ","),
+				strings.Join(g.Missing, ","), g.Author.Email, g.title)
+		}
+		return tw.Flush()
+	default:
+		return errors.New("unknown format " + format)
+	}
+}
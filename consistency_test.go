@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBranchGaps(t *testing.T) {
+	e := newTestEnv(t, newMemStore())
+	u := e.upstream
+	for _, branch := range []string{"release-1.0", "release-1.1", "release-2.0"} {
+		u.branch(branch)
+	}
+	shas := map[string]string{}
+	for _, title := range []string{"a", "b", "c"} {
+		pr, s := u.openPR("alice@example.com", "master", "fix "+title,
+			testCommit{title: title, file: title + ".txt", content: title + "\n"})
+		u.merge(pr)
+		shas[title] = s[0]
+	}
+	backport := func(title, branch string) {
+		pr, _ := u.openPR("alice@example.com", branch, branch+": fix "+title,
+			testCommit{cherryPick: shas[title]})
+		u.merge(pr)
+	}
+	// a skips release-1.1, and b every branch newer than release-1.0. c is
+	// only on the newest branch, which leaves no gap.
+	backport("a", "release-1.0")
+	backport("a", "release-2.0")
+	backport("b", "release-1.0")
+	backport("c", "release-2.0")
+	e.sync()
+
+	gaps := func() []string {
+		t.Helper()
+		repoLock.RLock()
+		defer repoLock.RUnlock()
+		gs, err := findBranchGaps(e.ctx, e.store, e.repo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, g := range gs {
+			out = append(out, fmt.Sprintf("%s: %v missing %v", g.title, g.Backported, g.Missing))
+		}
+		return out
+	}
+	if actual, expected := gaps(), []string{
+		"b: [release-1.0] missing [release-2.0 release-1.1]",
+		"a: [release-2.0 release-1.0] missing [release-1.1]",
+	}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected gaps %q, got %q", expected, actual)
+	}
+	for _, ac := range e.board(boardOptions{branch: "release-1.1"}).Commits {
+		var expected []string
+		if ac.Title() != "c" {
+			expected = []string{"release-1.1"}
+			if ac.Title() == "b" {
+				expected = []string{"release-2.0", "release-1.1"}
+			}
+		}
+		if !reflect.DeepEqual(ac.Gaps, expected) {
+			t.Errorf("%s: expected gaps %v on the board, got %v", ac.Title(), expected, ac.Gaps)
+		}
+	}
+
+	// A branch with a backport underway or an exclusion is not a gap.
+	re := e.repo()
+	var b commit
+	for _, c := range re.masterCommits.commits {
+		if c.title == "b" {
+			b = c
+		}
+	}
+	if err := e.store.putExclusion(e.ctx, re.id, "release-1.1", b.MessageID(), exclusion{CreatedBy: "carol"},
+		event{RepoID: re.id, Kind: eventExcluded}); err != nil {
+		t.Fatal(err)
+	}
+	// Gaps are found when the repo is synced, but exclusions apply at once.
+	if actual, expected := gaps(), []string{
+		"b: [release-1.0] missing [release-2.0]",
+		"a: [release-2.0 release-1.0] missing [release-1.1]",
+	}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected gaps %q, got %q", expected, actual)
+	}
+	u.openPR("alice@example.com", "release-2.0", "release-2.0: fix b", testCommit{cherryPick: shas["b"]})
+	e.sync()
+	if actual, expected := gaps(), []string{"a: [release-2.0 release-1.0] missing [release-1.1]"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected gaps %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// credentials describes how git authenticates against a repository's remote.
+// Implementations must never place secrets in the returned arguments, which
+// are visible to other processes and may end up in error messages; secrets
+// are only passed to git via the environment.
+type credentials interface {
+	// url returns the remote URL to clone the GitHub repository owner/name
+	// from.
+	url(owner, name string) string
+	// args returns additional global arguments for git, e.g. "-c" options.
+	args() []string
+	// env returns additional environment variables for git.
+	env() ([]string, error)
+	// String returns the spec that parseCredentials would parse into this
+	// strategy.
+	String() string
+}
+
+const defaultTokenEnvVar = "BACKBOARD_GITHUB_TOKEN"
+
+// parseCredentials parses a credential strategy spec:
+//
+//	none              anonymous https (the default)
+//	token[:ENV_VAR]   https with the token stored in ENV_VAR, which defaults
+//	                  to BACKBOARD_GITHUB_TOKEN
+//	ssh:KEY_PATH      ssh with the private key at KEY_PATH
+func parseCredentials(spec string) (credentials, error) {
+	kind, arg := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "", "none":
+		if arg != "" {
+			return nil, fmt.Errorf("credentials %q take no argument", kind)
+		}
+		return noCredentials{}, nil
+	case "token":
+		if arg == "" {
+			arg = defaultTokenEnvVar
+		}
+		return tokenCredentials{envVar: arg}, nil
+	case "ssh":
+		if arg == "" {
+			return nil, fmt.Errorf("ssh credentials require a key path")
+		}
+		return sshCredentials{keyPath: arg}, nil
+	default:
+		return nil, fmt.Errorf("unknown credential strategy %q", kind)
+	}
+}
+
+func httpsURL(owner, name string) string {
+	return "https://github.com/" + path.Join(owner, name) + ".git"
+}
+
+// noCredentials clones public repositories anonymously over https.
+type noCredentials struct{}
+
+func (noCredentials) url(owner, name string) string { return httpsURL(owner, name) }
+func (noCredentials) args() []string                { return nil }
+func (noCredentials) env() ([]string, error)        { return nil, nil }
+func (noCredentials) String() string                { return "none" }
+
+// tokenCredentials authenticates over https with a GitHub token. The token is
+// handed to git through an inline credential helper that reads it from the
+// environment, so it never appears in git's arguments or in the remote URL
+// stored in the mirror's config.
+type tokenCredentials struct {
+	envVar string
+}
+
+// gitTokenEnvVar is the variable through which the token is passed to the
+// credential helper.
+const gitTokenEnvVar = "BACKBOARD_GIT_TOKEN"
+
+func (tokenCredentials) url(owner, name string) string { return httpsURL(owner, name) }
+
+func (tokenCredentials) args() []string {
+	return []string{
+		// An empty helper resets any helpers inherited from the user's or
+		// system's git config.
+		"-c", "credential.helper=",
+		"-c", `credential.helper=!f() { test "$1" = get && echo username=x-access-token && echo "password=$` + gitTokenEnvVar + `"; }; f`,
+	}
+}
+
+func (c tokenCredentials) env() ([]string, error) {
+	token := os.Getenv(c.envVar)
+	if token == "" {
+		return nil, fmt.Errorf("missing %s env var", c.envVar)
+	}
+	return []string{gitTokenEnvVar + "=" + token}, nil
+}
+
+func (c tokenCredentials) String() string {
+	return "token:" + c.envVar
+}
+
+// sshCredentials authenticates over ssh with a private key on disk.
+type sshCredentials struct {
+	keyPath string
+}
+
+func (sshCredentials) url(owner, name string) string {
+	return "git@github.com:" + path.Join(owner, name) + ".git"
+}
+
+func (sshCredentials) args() []string { return nil }
+
+func (c sshCredentials) env() ([]string, error) {
+	if _, err := os.Stat(c.keyPath); err != nil {
+		return nil, err
+	}
+	return []string{
+		"GIT_SSH_COMMAND=ssh -i " + shellQuote(c.keyPath) + " -o IdentitiesOnly=yes -o BatchMode=yes",
+	}, nil
+}
+
+func (c sshCredentials) String() string {
+	return "ssh:" + c.keyPath
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCredentials(t *testing.T) {
+	for spec, expected := range map[string]string{
+		"":                 "none",
+		"none":             "none",
+		"token":            "token:" + defaultTokenEnvVar,
+		"token:GH_TOKEN":   "token:GH_TOKEN",
+		"ssh:/keys/id_rsa": "ssh:/keys/id_rsa",
+	} {
+		creds, err := parseCredentials(spec)
+		if err != nil {
+			t.Errorf("%q: %s", spec, err)
+			continue
+		}
+		if actual := creds.String(); actual != expected {
+			t.Errorf("%q: expected %q, got %q", spec, expected, actual)
+		}
+		reparsed, err := parseCredentials(creds.String())
+		if err != nil || reparsed != creds {
+			t.Errorf("%q: %q does not round-trip: got %v, %v", spec, creds, reparsed, err)
+		}
+	}
+	for _, spec := range []string{"none:x", "ssh", "ssh:", "password:hunter2"} {
+		if _, err := parseCredentials(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestCredentialsEnv(t *testing.T) {
+	if env, err := (noCredentials{}).env(); err != nil || env != nil {
+		t.Errorf("none: expected no env, got %q, %v", env, err)
+	}
+
+	t.Setenv("TEST_GITHUB_TOKEN", "")
+	token := tokenCredentials{envVar: "TEST_GITHUB_TOKEN"}
+	if _, err := token.env(); err == nil || !strings.Contains(err.Error(), "TEST_GITHUB_TOKEN") {
+		t.Errorf("token: expected an error about the missing env var, got %v", err)
+	}
+	t.Setenv("TEST_GITHUB_TOKEN", "hunter2")
+	env, err := token.env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{gitTokenEnvVar + "=hunter2"}; !reflect.DeepEqual(env, expected) {
+		t.Errorf("token: expected env %q, got %q", expected, env)
+	}
+	for _, arg := range token.args() {
+		if strings.Contains(arg, "hunter2") {
+			t.Errorf("token: secret leaked into args %q", token.args())
+		}
+	}
+	if url := token.url("acme", "widget"); url != "https://github.com/acme/widget.git" {
+		t.Errorf("token: unexpected url %q", url)
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "bob's key")
+	ssh := sshCredentials{keyPath: keyPath}
+	if _, err := ssh.env(); err == nil {
+		t.Error("ssh: expected an error for a missing key")
+	}
+	if err := ioutil.WriteFile(keyPath, []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env, err = ssh.env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"GIT_SSH_COMMAND=ssh -i '" + strings.Replace(keyPath, "'", `'\''`, -1) +
+		"' -o IdentitiesOnly=yes -o BatchMode=yes"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("ssh: expected env %q, got %q", expected, env)
+	}
+	if url := ssh.url("acme", "widget"); url != "git@github.com:acme/widget.git" {
+		t.Errorf("ssh: unexpected url %q", url)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formatSHAList and parseSHAList convert lists of SHAs to and from the
+// space-separated form in which the store keeps them.
+func formatSHAList(shas []sha) string {
+	var ss []string
+	for _, s := range shas {
+		ss = append(ss, s.String())
+	}
+	return strings.Join(ss, " ")
+}
+
+func parseSHAList(s string) ([]sha, error) {
+	var shas []sha
+	for _, f := range strings.Fields(s) {
+		sha, err := parseSHA(f)
+		if err != nil {
+			return nil, err
+		}
+		shas = append(shas, sha)
+	}
+	return shas, nil
+}
+
+// analyzeDependencies computes, for every release branch of re, the
+// dependencies of each master commit made since the branch was cut. Commit
+// B depends on commit A if B changes or extends lines that A introduced.
+// Dependencies are cached by merge base, so only new commits are examined.
+// A commit whose analysis fails is logged and left for the next sync to
+// retry, without holding back the analysis of the others; the number of
+// failures is returned as an error.
+func analyzeDependencies(ctx context.Context, st store, re repo) error {
+	var failures int
+	for _, branch := range re.releaseBranches {
+		mergeBase := re.branchMergeBases[branch]
+		cached, err := st.dependencies(ctx, re.id, mergeBase)
+		if err != nil {
+			return err
+		}
+		for _, c := range re.masterCommits.truncate(mergeBase) {
+			if _, ok := cached[string(c.sha)]; ok {
+				continue
+			}
+			deps, err := commitDependencies(re, c.sha, mergeBase)
+			if err != nil {
+				log.Printf("analyzing the dependencies of %s in %s: %s", c.sha.Short(), re, err)
+				failures++
+				continue
+			}
+			if err := st.putDependencies(ctx, re.id, mergeBase, c.sha, deps); err != nil {
+				return err
+			}
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("failed to analyze the dependencies of %d commits", failures)
+	}
+	return nil
+}
+
+// hunkHeaderRegexp matches the header of a hunk in a unified diff without
+// context, capturing the start and length of the hunk's old lines.
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,\d+)? @@`)
+
+// commitDependencies returns the commits made since mergeBase that
+// introduced lines c modifies, or next to which c adds lines, by blaming the
+// lines c's hunks replace in its parent.
+func commitDependencies(re repo, c, mergeBase sha) ([]sha, error) {
+	diff, err := capture("git", "-C", re.path(), "diff", "--no-color", "--no-ext-diff", "-U0",
+		c.String()+"^", c.String())
+	if err != nil {
+		return nil, err
+	}
+	// The -L arguments to blame for each file, by the file's path in c's
+	// parent.
+	ranges := map[string][]string{}
+	var file string
+	// inHeader is whether the line is in a file's header, between its diff
+	// --git line and its first hunk. Only there is a "--- " line the file's
+	// old path; in a hunk, it is a removed line that begins with "-- ".
+	var inHeader bool
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			file, inHeader = "", true
+			continue
+		}
+		if inHeader && strings.HasPrefix(line, "--- ") {
+			if path := strings.TrimPrefix(line, "--- "); strings.HasPrefix(path, "a/") {
+				file = strings.TrimPrefix(path, "a/")
+			}
+			continue
+		}
+		m := hunkHeaderRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		inHeader = false
+		if file == "" {
+			continue
+		}
+		start, _ := strconv.Atoi(m[1])
+		length := 1
+		if m[2] != "" {
+			length, _ = strconv.Atoi(m[2])
+		}
+		if length == 0 {
+			// A pure insertion after line start depends on the line it
+			// follows.
+			if start == 0 {
+				continue
+			}
+			length = 1
+		}
+		ranges[file] = append(ranges[file], "-L", fmt.Sprintf("%d,+%d", start, length))
+	}
+
+	var files []string
+	for f := range ranges {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	depSet := map[string]bool{}
+	var deps []sha
+	for _, f := range files {
+		args := append([]string{"git", "-C", re.path(), "blame", "--porcelain"}, ranges[f]...)
+		args = append(args, mergeBase.String()+".."+c.String()+"^", "--", f)
+		out, err := capture(args...)
+		if err != nil {
+			return nil, err
+		}
+		blamed, err := parseBlameCommits(out)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range blamed {
+			if !depSet[string(b)] {
+				depSet[string(b)] = true
+				deps = append(deps, b)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// parseBlameCommits returns the commits to which porcelain blame output
+// attributes lines, excluding boundary commits, which predate the blamed
+// range.
+func parseBlameCommits(out string) ([]sha, error) {
+	var shas []sha
+	seen := map[string]bool{}
+	boundary := map[string]bool{}
+	var current string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			continue
+		}
+		if line == "boundary" {
+			boundary[current] = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && len(fields[0]) == 40 {
+			if _, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				current = fields[0]
+				seen[current] = true
+			}
+		}
+	}
+	for s := range seen {
+		if boundary[s] {
+			continue
+		}
+		sha, err := parseSHA(s)
+		if err != nil {
+			return nil, err
+		}
+		shas = append(shas, sha)
+	}
+	sort.Slice(shas, func(i, j int) bool { return shas[i].String() < shas[j].String() })
+	return shas, nil
+}
+
+// landedOn reports whether the master commit c has landed on branch, either
+// directly or through a merged backport PR.
+func (r repo) landedOn(c commit, branch string) bool {
+	if _, ok := r.branchCommits[branch].messageIDs[c.MessageID()]; ok {
+		return true
+	}
+	backportPR := r.branchPRs[c.MessageID()][branch]
+	return backportPR != nil && backportPR.mergedAt.Valid
+}
+
+// prerequisites returns the commits among deps, the dependencies of a
+// candidate commit for branch, that have yet to land on branch, newest
+// first.
+func (r repo) prerequisites(branch string, deps []sha) []commit {
+	var out []commit
+	for _, d := range deps {
+		if c, ok := r.masterCommits.find(d); ok && !r.landedOn(c, branch) {
+			out = append(out, c)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CommitDate.After(out[j].CommitDate) })
+	return out
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		u := e.upstream
+		u.branch("release-1.0")
+		pr1, shas1 := u.openPR("alice@example.com", "master", "add x",
+			testCommit{title: "x", file: "x.txt", content: "x\n"})
+		u.merge(pr1)
+		pr2, shas2 := u.openPR("bob@example.com", "master", "change x",
+			testCommit{title: "x2", file: "x.txt", content: "x2\n"})
+		u.merge(pr2)
+		pr3, _ := u.openPR("alice@example.com", "master", "add y",
+			testCommit{title: "y", file: "y.txt", content: "y\n"})
+		u.merge(pr3)
+		// A removed line that begins with "-- " looks like a file header in
+		// the diff, but mustn't hide the hunks that follow it.
+		pr5, _ := u.openPR("alice@example.com", "master", "add q",
+			testCommit{title: "q", file: "q.sql", content: "-- note\nkeep\n"})
+		u.merge(pr5)
+		pr6, _ := u.openPR("bob@example.com", "master", "extend q",
+			testCommit{title: "r", file: "q.sql", content: "-- note\nkeep\nz\n"})
+		u.merge(pr6)
+		pr7, _ := u.openPR("bob@example.com", "master", "change q",
+			testCommit{title: "s", file: "q.sql", content: "keep\nz2\n"})
+		u.merge(pr7)
+		e.sync()
+
+		prerequisites := func() map[string][]string {
+			out := map[string][]string{}
+			for _, c := range e.board(boardOptions{branch: "release-1.0"}).Commits {
+				for _, p := range c.Prerequisites {
+					out[c.Title()] = append(out[c.Title()], p.Title())
+				}
+			}
+			return out
+		}
+		if actual, expected := prerequisites(), map[string][]string{
+			"x2": {"x"}, "r": {"q"}, "s": {"r", "q"},
+		}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected prerequisites %v, got %v", expected, actual)
+		}
+
+		s := &server{store: st}
+		command := func(prerequisites bool) backportCommand {
+			t.Helper()
+			q := url.Values{"repo": {fmt.Sprint(e.repo().id)}, "branch": {"release-1.0"}, "sha": {shas2[0]}}
+			if prerequisites {
+				q.Set("prerequisites", "1")
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/backport/command?"+q.Encode(), nil))
+			var res backportCommand
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("%s: %s", err, w.Body)
+			}
+			return res
+		}
+		res := command(false)
+		expectedProblem := fmt.Sprintf("%s \"x2\" depends on %s \"x\", which is not selected and has not landed on release-1.0",
+			shas2[0][:9], shas1[0][:9])
+		if len(res.Problems) != 1 || res.Problems[0] != expectedProblem {
+			t.Errorf("expected problem %q, got %q", expectedProblem, res.Problems)
+		}
+		res = command(true)
+		if res.Command != "backport 1 2" || len(res.Problems) != 0 {
+			t.Errorf("expected prerequisite to be included, got %+v", res)
+		}
+
+		// Once x lands on the branch, x2 no longer needs it.
+		pr4, _ := u.openPR("alice@example.com", "release-1.0", "release-1.0: add x",
+			testCommit{cherryPick: shas1[0]})
+		u.merge(pr4)
+		e.sync()
+		if actual, expected := prerequisites(), map[string][]string{
+			"r": {"q"}, "s": {"r", "q"},
+		}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected prerequisites %v, got %v", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var commitTemplate = template.Must(template.New("commit.html").Parse(`<!doctype html>
+<html>
+<head>
+    <style>
+        body {
+            font-family: helvetica, sans-serif;
+            font-size: 14px;
+        }
+
+        h1 {
+            margin: 0 0 10px;
+        }
+
+        h1 a {
+            color: inherit;
+            text-decoration: none;
+        }
+
+        .header {
+            margin: 0 auto;
+            text-align: center;
+        }
+
+        .content {
+            margin: 0 auto;
+            max-width: 60em;
+        }
+
+        pre {
+            background: #f6f6f6;
+            padding: 0.6em;
+            white-space: pre-wrap;
+        }
+
+        #branch-table {
+            border-collapse: collapse;
+            margin: 1em 0;
+        }
+
+        #branch-table td {
+            border-top: 1px solid #bbb;
+            padding: 0.3em 0.6em;
+        }
+
+        .sha {
+            font-family: monospace;
+        }
+
+        .center {
+            text-align: center;
+        }
+    </style>
+    <title>{{.Commit.SHA.Short}} {{.Commit.Title}} · backboard</title>
+</head>
+<body>
+<div class="header">
+    <h1><a href="/">backboard</a></h1>
+    <h2>{{.Commit.Title}}</h2>
+</div>
+<div class="content">
+    <p>
+        <span class="sha">{{.Commit.SHA}}</span> in {{.Repo}}
+        by {{.Commit.Author}} on {{.Commit.CommitDate.Format "2006-01-02 15:04:05"}}
+        · <a href="{{.HistoryURL}}">history</a>
+    </p>
+    <pre>{{.Message}}</pre>
+    <pre>{{.DiffStat}}</pre>
+    <p>
+        Master PR: {{with .MasterPR}}<a href="{{.URL}}">{{.}}</a>{{else}}unknown{{end}}
+        {{with .RelatedPRs}}
+            · Related PRs: {{range .}}<a href="{{.PR.URL}}">{{.PR}}</a> ({{.Branch}}) {{end}}
+        {{end}}
+    </p>
+    <table id="branch-table">
+        <thead>
+        <tr>
+            <th>Branch</th>
+            <th>Ok?</th>
+            <th>BPR</th>
+            <th>Landed as</th>
+            <th>Landed on</th>
+            <th>First release</th>
+            <th>Tags</th>
+        </tr>
+        </thead>
+        <tbody>
+        {{range .Branches}}
+            <tr>
+                <td>{{if .BoardURL}}<a href="{{.BoardURL}}">{{.Branch}}</a>{{else}}{{.Branch}}{{end}}</td>
+                <td class="center" {{with .Exclusion}}title="excluded by {{.CreatedBy}}{{with .Reason}}: {{.}}{{end}}"{{end}}>
+                    {{if .AtCut}}<span title="predates the branch">✓</span>{{else}}{{.Status}}{{end}}
+                </td>
+                <td>{{with .BackportPR}}<a href="{{.URL}}">{{.}}</a>{{end}}</td>
+                <td class="sha">{{with .Landed}}<span title="{{.SHA}}">{{.SHA.Short}}</span>{{end}}</td>
+                <td>{{with .Landed}}{{.CommitDate.Format "2006-01-02 15:04:05"}}{{end}}</td>
+                <td>{{.FirstTag}}</td>
+                <td>{{range .Tags}}{{.}} {{end}}</td>
+            </tr>
+        {{else}}
+            <tr><td colspan="7">No release branches.</td></tr>
+        {{end}}
+        </tbody>
+    </table>
+</div>
+</body>
+</html>`))
+
+// branchJourney is the progress of a master commit towards a release branch.
+type branchJourney struct {
+	Branch string
+	// BoardURL is the URL of the commit's row on the branch's board, if the
+	// commit is a candidate for the branch.
+	BoardURL string
+	// AtCut reports whether the commit predates the branch, which has thus
+	// contained it from the start.
+	AtCut bool
+	// Status is the commit's backport status, as shown on the board.
+	Status     string
+	BackportPR *pr
+	Exclusion  *exclusion
+	// Landed is the commit on the branch that carries the change, if any.
+	Landed *commit
+	// FirstTag is the first tag on the branch to contain Landed, and Tags
+	// all of them, oldest first.
+	FirstTag string
+	Tags     []string
+}
+
+type relatedPR struct {
+	Branch string
+	PR     *pr
+}
+
+// findMessageID returns the commit in cs with the given message ID.
+func (cs commits) findMessageID(messageID string) (commit, bool) {
+	if _, ok := cs.messageIDs[messageID]; ok {
+		for _, c := range cs.commits {
+			if c.MessageID() == messageID {
+				return c, true
+			}
+		}
+	}
+	return commit{}, false
+}
+
+// journey computes the progress of the master commit c towards branch.
+// exclusions are the branch's exclusions.
+func (r repo) journey(c commit, branch string, exclusions map[string]exclusion) (branchJourney, error) {
+	j := branchJourney{Branch: branch}
+	var candidate bool
+	for _, c0 := range r.masterCommits.truncate(r.branchMergeBases[branch]) {
+		if string(c0.sha) == string(c.sha) {
+			candidate = true
+			break
+		}
+	}
+	if !candidate {
+		j.AtCut, j.Status, j.Landed = true, "✓", &c
+	} else {
+		j.BoardURL = boardRowURL(r, branch, c.sha)
+		j.BackportPR = r.branchPRs[c.MessageID()][branch]
+		if j.BackportPR != nil {
+			if j.BackportPR.mergedAt.Valid {
+				j.Status = "✓"
+			} else {
+				j.Status = "◷"
+			}
+		}
+		if landed, ok := r.branchCommits[branch].findMessageID(c.MessageID()); ok {
+			j.Status, j.Landed = "✓", &landed
+			j.FirstTag = r.firstTags[branch][c.MessageID()]
+		}
+		if e, ok := exclusions[c.MessageID()]; ok && j.BackportPR == nil && j.Landed == nil {
+			j.Status, j.Exclusion = "✗", &e
+		}
+	}
+	if j.Landed != nil {
+		out, err := capture("git", "-C", r.path(), "tag", "--contains", j.Landed.sha.String(),
+			"--merged", branch, "--sort=creatordate")
+		if err != nil {
+			return branchJourney{}, err
+		}
+		j.Tags = strings.Fields(out)
+		// Tags from before the branch was cut, like those of an older
+		// release line it was cut from, needn't contain the commit.
+		if j.AtCut && len(j.Tags) > 0 {
+			j.FirstTag = j.Tags[0]
+		}
+	}
+	return j, nil
+}
+
+// serveCommit renders the detail page of a master commit, which follows the
+// change across the release branches.
+func (s *server) serveCommit(w http.ResponseWriter, r *http.Request) error {
+	repoLock.RLock()
+	re, err := findRepo(r.URL.Query().Get("repo"))
+	repoLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	sha, err := parseSHA(r.URL.Query().Get("sha"))
+	if err != nil {
+		return errBadRequest{err}
+	}
+	c, ok := re.masterCommits.find(sha)
+	if !ok {
+		return errNotFound
+	}
+
+	diffStat, err := capture("git", "-C", re.path(), "show", "--stat", "--format=", sha.String())
+	if err != nil {
+		return err
+	}
+	var journeys []branchJourney
+	for _, branch := range re.releaseBranches {
+		exclusions, err := s.store.exclusions(r.Context(), re.id, branch)
+		if err != nil {
+			return err
+		}
+		j, err := re.journey(c, branch, exclusions)
+		if err != nil {
+			return err
+		}
+		journeys = append(journeys, j)
+	}
+	masterPR := re.masterPRs[string(c.sha)]
+	var related []relatedPR
+	for branch, p := range re.branchPRs[c.MessageID()] {
+		if masterPR == nil || p.number != masterPR.number {
+			related = append(related, relatedPR{Branch: branch, PR: p})
+		}
+	}
+	sort.Slice(related, func(i, j int) bool { return related[i].PR.number < related[j].PR.number })
+
+	message := c.title
+	if c.body != "" {
+		message += "\n\n" + c.body
+	}
+	return commitTemplate.Execute(w, struct {
+		Repo       repo
+		Commit     commit
+		Message    string
+		DiffStat   string
+		HistoryURL string
+		MasterPR   *pr
+		RelatedPRs []relatedPR
+		Branches   []branchJourney
+	}{
+		Repo:       re,
+		Commit:     c,
+		Message:    message,
+		DiffStat:   diffStat,
+		HistoryURL: historyURL(re, sha),
+		MasterPR:   masterPR,
+		RelatedPRs: related,
+		Branches:   journeys,
+	})
+}
+
+// commitURL returns the URL of the detail page of the master commit c.
+func commitURL(re repo, c sha) string {
+	return "/commit?repo=" + strconv.FormatInt(re.id, 10) + "&sha=" + c.String()
+}
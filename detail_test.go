@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommitJourney(t *testing.T) {
+	e := newTestEnv(t, newMemStore())
+	setupBackports(e)
+	e.upstream.git("", "tag", "v1.0.1", "release-1.0")
+	e.sync()
+
+	re := e.repo()
+	commits := map[string]commit{}
+	for _, c := range e.board(boardOptions{branch: "release-1.0"}).Commits {
+		commits[c.Title()] = c.commit
+	}
+	landed, ok := re.branchCommits["release-1.0"].findMessageID(commits["a"].MessageID())
+	if !ok {
+		t.Fatal("a did not land on release-1.0")
+	}
+
+	for _, tc := range []struct {
+		title    string
+		status   string
+		pr       int
+		landed   string
+		firstTag string
+		tags     []string
+	}{
+		{title: "a", status: "✓", pr: 4, landed: landed.sha.String(), firstTag: "v1.0.1", tags: []string{"v1.0.1"}},
+		{title: "b1", status: "◷", pr: 5},
+		{title: "c", status: ""},
+	} {
+		j, err := re.journey(commits[tc.title], "release-1.0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pr int
+		if j.BackportPR != nil {
+			pr = j.BackportPR.number
+		}
+		var landedSHA string
+		if j.Landed != nil {
+			landedSHA = j.Landed.sha.String()
+		}
+		if j.AtCut || j.Status != tc.status || pr != tc.pr || landedSHA != tc.landed || j.FirstTag != tc.firstTag || !reflect.DeepEqual(j.Tags, tc.tags) {
+			t.Errorf("%s: unexpected journey %+v", tc.title, j)
+		}
+	}
+
+	s := &server{store: e.store}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/commit?repo=%d&sha=%s", re.id, commits["a"].sha), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	for _, s := range []string{"a.txt", landed.sha.Short(), "v1.0.1", "#4"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("commit page does not mention %q:\n%s", s, w.Body)
+		}
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/commit?repo=%d&sha=%s", re.id, strings.Repeat("0", 40)), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown commit, got %d", w.Code)
+	}
+}
+
+func TestCommitJourneyAtCut(t *testing.T) {
+	e := newTestEnv(t, newMemStore())
+	u := e.upstream
+	initial := u.git("", "rev-parse", "HEAD")
+	u.git("", "tag", "v0.1.0")
+	cut := u.commit("alice@example.com", "z", "z.txt", "z\n")
+	u.branch("release-1.0")
+	u.git("", "tag", "v1.0.0", "release-1.0")
+	e.sync()
+
+	re := e.repo()
+	for _, tc := range []struct {
+		sha      string
+		firstTag string
+		tags     []string
+	}{
+		{sha: initial, firstTag: "v0.1.0", tags: []string{"v0.1.0", "v1.0.0"}},
+		// v0.1.0 is on the branch, but predates z.
+		{sha: cut, firstTag: "v1.0.0", tags: []string{"v1.0.0"}},
+	} {
+		s, err := parseSHA(tc.sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, ok := re.masterCommits.find(s)
+		if !ok {
+			t.Fatalf("%s is not on master", tc.sha)
+		}
+		j, err := re.journey(c, "release-1.0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !j.AtCut || j.FirstTag != tc.firstTag || !reflect.DeepEqual(j.Tags, tc.tags) {
+			t.Errorf("%s: unexpected journey %+v", c.Title(), j)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+)
+
+// database is a connection pool along with the SQL dialect it speaks.
+type database struct {
+	*sql.DB
+	dialect dialect
+	// version is the server's version string, as reported by version(). It
+	// is empty for SQLite.
+	version string
+}
+
+// dialect captures the differences between the SQL databases that backboard
+// can store its state in. Queries are written in the common subset of
+// CockroachDB, PostgreSQL and SQLite; only DDL, full-text search and
+// transaction handling vary.
+type dialect interface {
+	String() string
+	// translate rewrites DDL written for CockroachDB into this dialect.
+	translate(ddl string) string
+	// matchText returns a condition that holds for the rows of table, which
+	// is prs or pr_commits, aliased as alias, whose title or body contains
+	// every word of the search query bound to param.
+	matchText(table, alias, param string) string
+	// textQuery converts the words of a search query into the value that
+	// matchText expects to be bound to its param.
+	textQuery(words []string) string
+	// executeTx runs fn in a transaction, retrying it as the database
+	// requires.
+	executeTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error
+}
+
+const sqlitePrefix = "sqlite:"
+
+// openDatabase connects to the database described by connString. A
+// connString of the form "sqlite:<path>" opens an embedded SQLite database at
+// path, creating it if necessary; anything else is handed to the postgres
+// driver and may point at either CockroachDB or PostgreSQL.
+func openDatabase(ctx context.Context, connString string) (*database, error) {
+	if strings.HasPrefix(connString, sqlitePrefix) {
+		path := strings.TrimPrefix(connString, sqlitePrefix)
+		// Immediate transactions take the write lock upfront, so that
+		// concurrent read-then-write transactions wait on the busy timeout
+		// rather than failing when upgrading their lock.
+		db, err := sql.Open("sqlite3", "file:"+path+
+			"?_busy_timeout=10000&_journal_mode=WAL&_foreign_keys=on&_txlock=immediate")
+		if err != nil {
+			return nil, err
+		}
+		if err := db.PingContext(ctx); err != nil {
+			return nil, err
+		}
+		return &database{DB: db, dialect: sqliteDialect{}}, nil
+	}
+
+	db, err := sql.Open("postgres", connString)
+	if err != nil {
+		return nil, err
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, `SELECT version()`).Scan(&version); err != nil {
+		return nil, err
+	}
+	if strings.Contains(version, "CockroachDB") {
+		return &database{DB: db, dialect: cockroachDialect{}, version: version}, nil
+	}
+	return &database{DB: db, dialect: postgresDialect{}, version: version}, nil
+}
+
+// translateTypes returns a function that rewrites the CockroachDB types in
+// DDL according to types, which maps type names to their replacements.
+func translateTypes(types map[string]string) func(string) string {
+	var names []string
+	for name := range types {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	re := regexp.MustCompile(`\b(` + strings.Join(names, "|") + `)\b`)
+	return func(ddl string) string {
+		return re.ReplaceAllStringFunc(ddl, func(name string) string {
+			return types[name]
+		})
+	}
+}
+
+type cockroachDialect struct{}
+
+func (cockroachDialect) String() string { return "cockroachdb" }
+
+func (cockroachDialect) translate(ddl string) string { return ddl }
+
+func (cockroachDialect) matchText(table, alias, param string) string {
+	return matchTSVector(alias, param)
+}
+
+func (cockroachDialect) textQuery(words []string) string { return strings.Join(words, " ") }
+
+func (cockroachDialect) executeTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	return crdb.ExecuteTx(ctx, db, nil /* txopts */, fn)
+}
+
+type postgresDialect struct{}
+
+var translatePostgres = translateTypes(map[string]string{
+	"string": "text",
+	"bytes":  "bytea",
+	// CockroachDB's integers are 64 bits wide, and GitHub's IDs need them.
+	"int":    "bigint",
+	"serial": "bigserial",
+})
+
+func (postgresDialect) String() string { return "postgresql" }
+
+func (postgresDialect) translate(ddl string) string { return translatePostgres(ddl) }
+
+func (postgresDialect) matchText(table, alias, param string) string {
+	return matchTSVector(alias, param)
+}
+
+func (postgresDialect) textQuery(words []string) string { return strings.Join(words, " ") }
+
+// matchTSVector implements matchText for databases that support text search
+// vectors. The vector expression must match the one in the search indexes for
+// them to be used.
+func matchTSVector(alias, param string) string {
+	return fmt.Sprintf(`to_tsvector('english', coalesce(%[1]s.title, '') || ' ' || coalesce(%[1]s.body, ''))
+		@@ plainto_tsquery('english', %[2]s)`, alias, param)
+}
+
+func (postgresDialect) executeTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	return executeTx(ctx, db, fn)
+}
+
+type sqliteDialect struct{}
+
+var translateSQLite = translateTypes(map[string]string{
+	"string": "TEXT",
+	"bytes":  "BLOB",
+	"int":    "INTEGER",
+	// Only an INTEGER PRIMARY KEY column aliases the rowid and thus
+	// autoincrements.
+	"serial PRIMARY KEY": "INTEGER PRIMARY KEY AUTOINCREMENT",
+	// The sqlite3 driver only converts columns declared as TIMESTAMP to and
+	// from time.Time.
+	"timestamptz": "TIMESTAMP",
+	"bool":        "BOOLEAN",
+})
+
+func (sqliteDialect) String() string { return "sqlite" }
+
+func (sqliteDialect) translate(ddl string) string { return translateSQLite(ddl) }
+
+func (sqliteDialect) matchText(table, alias, param string) string {
+	return fmt.Sprintf(`%[2]s.id IN (SELECT docid FROM %[1]s_fts WHERE %[1]s_fts MATCH %[3]s)`, table, alias, param)
+}
+
+// textQuery quotes each word, so that words like OR and NEAR aren't taken for
+// operators. FTS4 requires a row to contain every quoted word.
+func (sqliteDialect) textQuery(words []string) string {
+	var quoted []string
+	for _, w := range words {
+		quoted = append(quoted, `"`+w+`"`)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (sqliteDialect) executeTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	return executeTx(ctx, db, fn)
+}
+
+// executeTx runs fn in a transaction without retries, for databases that
+// don't ask clients to retry serialization failures.
+func executeTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%s (rollback also failed: %s)", err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
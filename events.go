@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// event is an entry in the append-only log of changes to the board's state.
+type event struct {
+	ID        int64
+	CreatedAt time.Time
+	RepoID    int64
+	Branch    string
+	// Actor is the login of the user who caused the event, or empty if the
+	// event was observed by the sync process.
+	Actor     string
+	Kind      string
+	MessageID string
+	SHA       sha
+	PRNumber  int
+	Detail    string
+}
+
+const (
+	eventPROpened     = "pr-opened"
+	eventPRMerged     = "pr-merged"
+	eventPRClosed     = "pr-closed"
+	eventPRReopened   = "pr-reopened"
+	eventPRRetargeted = "pr-retargeted"
+	eventExcluded     = "excluded"
+	eventUnexcluded   = "unexcluded"
+	eventCommented    = "commented"
+	eventRoleGranted  = "role-granted"
+	// eventBackportCreated records that a user opened a backport PR from the
+	// board. The PR's own pr-opened event follows once it is synced.
+	eventBackportCreated = "backport-created"
+)
+
+// prState is the subset of a PR's synced state whose changes are recorded as
+// events.
+type prState struct {
+	open       bool
+	merged     bool
+	baseBranch string
+}
+
+// prTransitions returns the events implied by pr moving from prev, its state
+// as of the last sync, to its current state. prev is nil if the PR has never
+// been synced.
+func prTransitions(repoID int64, prev *prState, pr *github.PullRequest) []event {
+	cur := prState{
+		open:       pr.GetState() == "open",
+		merged:     pr.MergedAt != nil,
+		baseBranch: pr.GetBase().GetRef(),
+	}
+	newEvent := func(kind string, at *time.Time, actor string) event {
+		ev := event{
+			CreatedAt: pr.GetUpdatedAt(),
+			RepoID:    repoID,
+			Branch:    cur.baseBranch,
+			Actor:     actor,
+			Kind:      kind,
+			PRNumber:  pr.GetNumber(),
+			Detail:    pr.GetTitle(),
+		}
+		if at != nil {
+			ev.CreatedAt = *at
+		}
+		return ev
+	}
+
+	var evs []event
+	if prev == nil {
+		evs = append(evs, newEvent(eventPROpened, pr.CreatedAt, pr.GetUser().GetLogin()))
+		prev = &prState{open: true, baseBranch: cur.baseBranch}
+	}
+	if prev.baseBranch != cur.baseBranch {
+		ev := newEvent(eventPRRetargeted, nil, "")
+		ev.Detail = fmt.Sprintf("%s → %s", prev.baseBranch, cur.baseBranch)
+		evs = append(evs, ev)
+	}
+	switch {
+	case !prev.merged && cur.merged:
+		evs = append(evs, newEvent(eventPRMerged, pr.MergedAt, pr.GetMergedBy().GetLogin()))
+	case prev.open && !cur.open && !cur.merged:
+		evs = append(evs, newEvent(eventPRClosed, pr.ClosedAt, ""))
+	case !prev.open && cur.open:
+		evs = append(evs, newEvent(eventPRReopened, nil, ""))
+	}
+	return evs
+}
+
+// eventFilter restricts the events returned by store.events. The zero value
+// matches every event.
+type eventFilter struct {
+	// repoID, if nonzero, restricts events to a repo.
+	repoID int64
+	// messageID, if nonempty, restricts events to those that concern the
+	// commit with the message ID, directly or through one of its PRs.
+	// Requires repoID.
+	messageID string
+	// before, if nonzero, restricts events to those with smaller IDs, for
+	// paging.
+	before int64
+}
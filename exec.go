@@ -36,16 +36,45 @@ func capture(args ...string) (string, error) {
 // status..." error, as the process has likely written an error message to
 // stderr.
 func spawn(args ...string) error {
+	return spawnEnv(nil, args...)
+}
+
+// spawnEnv is like spawn, but adds env to the subprocess's environment. It is
+// the means by which secrets are handed to subprocesses, as, unlike
+// arguments, the environment is not visible to other users.
+func spawnEnv(env []string, args ...string) error {
 	var cmd *exec.Cmd
 	if len(args) == 0 {
-		panic("spawn called with no arguments")
+		panic("spawnEnv called with no arguments")
 	} else if len(args) == 1 {
 		cmd = exec.Command(args[0])
 	} else {
 		cmd = exec.Command(args[0], args[1:]...)
 	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// captureExitCode is like capture, but also returns the process's exit code,
+// for commands whose exit code carries information. If the process ran but
+// exited with a nonzero code, the returned error includes its stderr; if it
+// could not be run at all, the exit code is -1.
+func captureExitCode(args ...string) (string, int, error) {
+	if len(args) == 0 {
+		panic("captureExitCode called with no arguments")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return string(bytes.TrimSpace(out)), exitErr.ExitCode(), errors.Errorf("%s: %s", err, exitErr.Stderr)
+		}
+		return "", -1, err
+	}
+	return string(bytes.TrimSpace(out)), 0, nil
+}
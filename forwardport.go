@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+var forwardPortsTemplate = template.Must(template.New("forwardports.html").Parse(`<!doctype html>
+<html>
+<head>
+    <style>
+        body {
+            font-family: helvetica, sans-serif;
+            font-size: 14px;
+        }
+
+        h1 {
+            margin: 0 0 10px;
+        }
+
+        h1 a {
+            color: inherit;
+            text-decoration: none;
+        }
+
+        .header {
+            margin: 0 auto;
+            text-align: center;
+        }
+
+        #commit-table {
+            border-collapse: collapse;
+            margin: 1em auto 0;
+        }
+
+        #commit-table td {
+            border-top: 1px solid #bbb;
+            padding: 0.3em 0.3em;
+        }
+
+        #commit-table form {
+            visibility: hidden;
+        }
+
+        #commit-table tr:hover form {
+            visibility: visible;
+        }
+
+        .history {
+            font-size: 12px;
+        }
+
+        .comment {
+            color: #666;
+            font-size: 12px;
+        }
+
+        .sha {
+            font-family: monospace;
+        }
+
+        .center {
+            text-align: center;
+        }
+    </style>
+    <title>forward-ports · {{.Branch}} · backboard</title>
+</head>
+<body>
+<div class="header">
+    <h1><a href="/">backboard</a></h1>
+    <h2>Commits on {{.Branch}} that have not landed on master</h2>
+    <form>
+        <select name="branch">
+            {{range .Branches}}
+                <option {{if eq . $.Branch}}selected{{end}}>{{.}}</option>
+            {{end}}
+        </select>
+        <label><input type="checkbox" name="excluded" value="1" {{if .ShowExcluded}}checked{{end}}> show excluded</label>
+        <input type="hidden" name="repo" value="{{.Repo.ID}}">
+        <input type="submit" value="go">
+    </form>
+    <p><a href="/?repo={{.Repo.ID}}&branch={{.Branch}}">board</a></p>
+</div>
+<table id="commit-table">
+    <thead>
+    <tr>
+        <th>SHA</th>
+        <th>Landed At</th>
+        <th>Author</th>
+        <th>Title</th>
+        <th>BPR</th>
+        <th>MPR</th>
+        <th>Ok?</th>
+        <th></th>
+    </tr>
+    </thead>
+    <tbody>
+    {{range .Commits}}
+        <tr id="{{.SHA}}">
+            <td class="sha" title="{{.SHA}}">{{.SHA.Short}}</td>
+            <td>{{.CommitDate.Format "2006-01-02 15:04:05"}}</td>
+            <td title="{{.Author.Email}}">{{.Author.Short}}</td>
+            <td>{{.Title}}</td>
+            <td><a href="{{.BranchPR.URL}}">{{.BranchPR}}</a></td>
+            <td><a href="{{.MasterPR.URL}}">{{.MasterPR}}</a></td>
+            <td class="center" {{with .Exclusion}}title="excluded by {{.CreatedBy}}{{with .Reason}}: {{.}}{{end}}"{{end}}>{{.Status}}</td>
+            <td>
+                <a class="history" href="/history?repo={{$.Repo.ID}}&sha={{.SHA}}">history</a>
+                {{range .Comments}}
+                    <div class="comment" title="{{.CreatedAt.Format "2006-01-02 15:04:05"}}"><b>{{.UserEmail}}</b>: {{.Body}}</div>
+                {{end}}
+                {{if $.CanComment}}
+                    <form method="post" action="/comment">
+                        <input type="hidden" name="repo" value="{{$.Repo.ID}}">
+                        <input type="hidden" name="branch" value="master">
+                        <input type="hidden" name="sha" value="{{.SHA}}">
+                        <input type="hidden" name="next" value="{{$.Next}}">
+                        <input type="text" name="body" placeholder="comment">
+                    </form>
+                {{end}}
+                {{if and $.CanExclude (not .MasterPR)}}
+                    <form method="post" action="/exclude">
+                        <input type="hidden" name="repo" value="{{$.Repo.ID}}">
+                        <input type="hidden" name="branch" value="master">
+                        <input type="hidden" name="sha" value="{{.SHA}}">
+                        <input type="hidden" name="next" value="{{$.Next}}">
+                        {{if .Exclusion}}
+                            <input type="hidden" name="undo" value="1">
+                            <input type="submit" value="include">
+                        {{else}}
+                            <input type="text" name="reason" placeholder="reason">
+                            <input type="submit" value="won't forward-port">
+                        {{end}}
+                    </form>
+                {{end}}
+            </td>
+        </tr>
+    {{else}}
+        <tr><td colspan="8">Every commit on {{.Branch}} has landed on master.</td></tr>
+    {{end}}
+    </tbody>
+</table>
+</body>
+</html>`))
+
+// loadForwardPorts returns the commits of cs, the commits on branch that
+// aren't on master, that have no counterpart on master: no master commit has
+// the same message ID or, per git cherry, the same patch ID.
+func loadForwardPorts(ctx context.Context, st store, re repo, branch string, cs commits) ([]commit, error) {
+	out, err := capture("git", "-C", re.path(), "rev-parse", "master")
+	if err != nil {
+		return nil, err
+	}
+	masterTip, err := parseSHA(out)
+	if err != nil {
+		return nil, err
+	}
+	r, err := updateCherryResult(ctx, st, re, branch, re.branchTips[branch], masterTip)
+	if err != nil {
+		return nil, err
+	}
+	unmatched := map[string]bool{}
+	for _, s := range r.unmatched {
+		unmatched[string(s)] = true
+	}
+	var ports []commit
+	for _, c := range cs.commits {
+		_, onMaster := re.masterCommits.messageIDs[c.MessageID()]
+		if !c.merge && !onMaster && unmatched[string(c.sha)] {
+			ports = append(ports, c)
+		}
+	}
+	return ports, nil
+}
+
+// updateCherryResult matches the commits on branch at branchTip with those on
+// master at masterTip, starting from the cached result of the last match. As
+// long as both tips have only moved forward since, it need only recheck the
+// commits left unmatched against the new master commits, and match the new
+// branch commits; otherwise it matches the whole branch again.
+func updateCherryResult(ctx context.Context, st store, re repo, branch string, branchTip, masterTip sha) (cherryResult, error) {
+	prev, ok, err := st.cherryResult(ctx, re.id, branch)
+	if err != nil {
+		return cherryResult{}, err
+	}
+	if ok && string(prev.branchTip) == string(branchTip) && string(prev.masterTip) == string(masterTip) {
+		return prev, nil
+	}
+	if ok {
+		if ok, err = isAncestor(re, prev.masterTip, masterTip); err != nil {
+			return cherryResult{}, err
+		}
+	}
+	if ok {
+		if ok, err = isAncestor(re, prev.branchTip, branchTip); err != nil {
+			return cherryResult{}, err
+		}
+	}
+	r := cherryResult{branchTip: branchTip, masterTip: masterTip}
+	if !ok {
+		r.unmatched, err = gitCherry(re, masterTip, branchTip, nil)
+		if err != nil {
+			return cherryResult{}, err
+		}
+		return r, st.putCherryResult(ctx, re.id, branch, r)
+	}
+
+	r.unmatched = prev.unmatched
+	if string(prev.masterTip) != string(masterTip) && len(r.unmatched) > 0 {
+		// Only the master commits made since the last match can match the
+		// commits it left unmatched. They are the left side of this
+		// symmetric difference, whose right side is the branch's commits.
+		out, err := capture("git", "-C", re.path(), "log", "--cherry-mark", "--right-only", "--no-merges",
+			"--format=%m%H", masterTip.String()+"..."+prev.branchTip.String(), "^"+prev.masterTip.String())
+		if err != nil {
+			return cherryResult{}, err
+		}
+		matched := map[string]bool{}
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(line, "=") {
+				matched[line[1:]] = true
+			}
+		}
+		var unmatched []sha
+		for _, s := range r.unmatched {
+			if !matched[s.String()] {
+				unmatched = append(unmatched, s)
+			}
+		}
+		r.unmatched = unmatched
+	}
+	if string(prev.branchTip) != string(branchTip) {
+		added, err := gitCherry(re, masterTip, branchTip, prev.branchTip)
+		if err != nil {
+			return cherryResult{}, err
+		}
+		r.unmatched = append(added, r.unmatched...)
+	}
+	return r, st.putCherryResult(ctx, re.id, branch, r)
+}
+
+// gitCherry returns the commits on head, after limit if it is set, that git
+// cherry finds no counterpart for on upstream.
+func gitCherry(re repo, upstream, head, limit sha) ([]sha, error) {
+	args := []string{"git", "-C", re.path(), "cherry", upstream.String(), head.String()}
+	if limit != nil {
+		args = append(args, limit.String())
+	}
+	out, err := capture(args...)
+	if err != nil {
+		return nil, err
+	}
+	var shas []sha
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "+ ") {
+			s, err := parseSHA(line[2:])
+			if err != nil {
+				return nil, err
+			}
+			shas = append(shas, s)
+		}
+	}
+	return shas, nil
+}
+
+// isAncestor reports whether commit a is an ancestor of commit b.
+func isAncestor(re repo, a, b sha) (bool, error) {
+	_, code, err := captureExitCode("git", "-C", re.path(), "merge-base", "--is-ancestor", a.String(), b.String())
+	if code == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// findForwardPort returns the commit with the given SHA that has yet to be
+// forward-ported from one of r's release branches.
+func (r repo) findForwardPort(sha sha) (commit, bool) {
+	for _, branch := range r.releaseBranches {
+		for _, c := range r.forwardPorts[branch] {
+			if string(c.sha) == string(sha) {
+				return c, true
+			}
+		}
+	}
+	return commit{}, false
+}
+
+// forwardPort is a commit on a release branch that has yet to land on
+// master, as shown on the forward-port page.
+type forwardPort struct {
+	commit
+	// BranchPR is the PR that landed the commit on the branch, and MasterPR
+	// the open PR that ports it to master, if any.
+	BranchPR  *pr
+	MasterPR  *pr
+	Status    string
+	Exclusion *exclusion
+	Comments  []comment
+}
+
+// serveForwardPorts lists the commits on a release branch that have no
+// counterpart on master. Forward-ports are excluded and commented on against
+// master, the branch they are missing from.
+func (s *server) serveForwardPorts(w http.ResponseWriter, r *http.Request) error {
+	repoLock.RLock()
+	defer repoLock.RUnlock()
+
+	re, err := findRepo(r.URL.Query().Get("repo"))
+	if err != nil {
+		return err
+	}
+	branch, err := findBranch(re, r.URL.Query().Get("branch"))
+	if err != nil {
+		return err
+	}
+	showExcluded := r.URL.Query().Get("excluded") != ""
+
+	ctx := r.Context()
+	exclusions, err := s.store.exclusions(ctx, re.id, "master")
+	if err != nil {
+		return err
+	}
+	comments, err := s.store.comments(ctx, re.id)
+	if err != nil {
+		return err
+	}
+	var ports []forwardPort
+	for _, c := range re.forwardPorts[branch] {
+		fp := forwardPort{
+			commit:   c,
+			BranchPR: re.branchPRs[c.MessageID()][branch],
+			MasterPR: re.branchPRs[c.MessageID()]["master"],
+			Comments: comments[c.MessageID()],
+		}
+		if fp.MasterPR != nil {
+			fp.Status = "◷"
+		} else if e, ok := exclusions[c.MessageID()]; ok {
+			if !showExcluded {
+				continue
+			}
+			fp.Status, fp.Exclusion = "✗", &e
+		}
+		ports = append(ports, fp)
+	}
+
+	userRole, err := loadRole(ctx, s.store, re.id, "master", identityFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	return forwardPortsTemplate.Execute(w, struct {
+		Repo         repo
+		Branches     []string
+		Branch       string
+		Commits      []forwardPort
+		ShowExcluded bool
+		CanComment   bool
+		CanExclude   bool
+		Next         string
+	}{
+		Repo:         re,
+		Branches:     re.releaseBranches,
+		Branch:       branch,
+		Commits:      ports,
+		ShowExcluded: showExcluded,
+		CanComment:   userRole >= actionComment.minRole(),
+		CanExclude:   userRole >= actionExclude.minRole(),
+		Next:         r.URL.RequestURI(),
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForwardPorts(t *testing.T) {
+	e := newTestEnv(t, newMemStore())
+	setupBackports(e)
+	u := e.upstream
+	// d is fixed only on the release branch, while c is fixed there by a
+	// commit that has a different title than c but the same patch.
+	prD, shasD := u.openPR("alice@example.com", "release-1.0", "release-1.0: fix d",
+		testCommit{title: "d", file: "d.txt", content: "d\n"})
+	u.merge(prD)
+	prC, _ := u.openPR("bob@example.com", "release-1.0", "release-1.0: fix c differently",
+		testCommit{title: "c, but on release-1.0", file: "c.txt", content: "c\n"})
+	u.merge(prC)
+	e.sync()
+
+	re := e.repo()
+	var titles []string
+	for _, c := range re.forwardPorts["release-1.0"] {
+		titles = append(titles, c.Title())
+	}
+	if expected := []string{"d"}; !reflect.DeepEqual(titles, expected) {
+		t.Fatalf("expected forward-ports %q, got %q", expected, titles)
+	}
+
+	// git cherry's result is cached along with the tips it was computed at.
+	cherries := func() cherryResult {
+		t.Helper()
+		r, ok, err := e.store.cherryResult(e.ctx, e.repo().id, "release-1.0")
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("expected git cherry's result to be cached")
+		}
+		if masterTip := u.git("", "rev-parse", "master"); r.masterTip.String() != masterTip ||
+			string(r.branchTip) != string(e.repo().branchTips["release-1.0"]) {
+			t.Errorf("expected the result at master %s and the branch tip, got %+v", masterTip, r)
+		}
+		return r
+	}
+	if r := cherries(); len(r.unmatched) != 1 || string(r.unmatched[0]) != string(re.forwardPorts["release-1.0"][0].sha) {
+		t.Errorf("expected d to be cached as unmatched, got %v", r.unmatched)
+	}
+
+	if err := e.store.grantRole(e.ctx, re.id, "", "carol", roleReleaseManager, event{RepoID: re.id, Kind: eventRoleGranted}); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{store: e.store, auth: authConfig{trustedUserHeader: "X-User"}}
+	page := func(query string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/forward-ports?repo=%d&branch=release-1.0%s", re.id, query), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+	form := func(vs ...string) url.Values {
+		f := url.Values{"repo": {fmt.Sprint(re.id)}, "branch": {"master"}, "sha": {shasD[0]}, "next": {"/"}}
+		for i := 0; i < len(vs); i += 2 {
+			f.Set(vs[i], vs[i+1])
+		}
+		return f
+	}
+	if w := postForm(s, "/comment", "carol", form("body", "needed on master too")); w.Code != http.StatusSeeOther {
+		t.Fatalf("comment: expected status 303, got %d: %s", w.Code, w.Body)
+	}
+	if body := page(""); !strings.Contains(body, "needed on master too") {
+		t.Errorf("forward-port page does not show the comment:\n%s", body)
+	}
+	if w := postForm(s, "/exclude", "carol", form("reason", "release only")); w.Code != http.StatusSeeOther {
+		t.Fatalf("exclude: expected status 303, got %d: %s", w.Code, w.Body)
+	}
+	if body := page(""); strings.Contains(body, shasD[0]) {
+		t.Errorf("forward-port page shows an excluded commit:\n%s", body)
+	}
+	if body := page("&excluded=1"); !strings.Contains(body, "release only") {
+		t.Errorf("forward-port page does not show the exclusion:\n%s", body)
+	}
+	if exclusions, err := e.store.exclusions(e.ctx, re.id, "release-1.0"); err != nil {
+		t.Fatal(err)
+	} else if len(exclusions) != 0 {
+		t.Errorf("excluding a forward-port excluded it from the release branch: %v", exclusions)
+	}
+	if w := postForm(s, "/exclude", "carol", form("sha", strings.Repeat("0", 40))); w.Code == http.StatusSeeOther {
+		t.Errorf("expected an unknown forward-port to be rejected")
+	}
+
+	pr, _ := u.openPR("alice@example.com", "master", "fix d",
+		testCommit{cherryPick: shasD[0]})
+	e.sync()
+	if body := page(""); !strings.Contains(body, fmt.Sprintf("#%d", pr.GetNumber())) {
+		t.Errorf("forward-port page does not show the open master PR:\n%s", body)
+	}
+	u.merge(pr)
+	e.sync()
+	if ports := e.repo().forwardPorts["release-1.0"]; len(ports) != 0 {
+		t.Errorf("expected no forward-ports once d landed on master, got %v", ports)
+	}
+
+	// As the branch and master move, the cached result is brought up to
+	// date: new branch commits are matched, and the commits left unmatched
+	// are rechecked against the new master commits, here one with the same
+	// patch as f but a different title.
+	prF, _ := u.openPR("alice@example.com", "release-1.0", "release-1.0: fix f",
+		testCommit{title: "f", file: "f.txt", content: "f\n"})
+	u.merge(prF)
+	e.sync()
+	if r := cherries(); len(r.unmatched) != 1 {
+		t.Errorf("expected only f to be unmatched, got %v", r.unmatched)
+	}
+	prF2, _ := u.openPR("alice@example.com", "master", "fix f on master",
+		testCommit{title: "f, but on master", file: "f.txt", content: "f\n"})
+	u.merge(prF2)
+	e.sync()
+	if r := cherries(); len(r.unmatched) != 0 {
+		t.Errorf("expected no commits to be unmatched, got %v", r.unmatched)
+	}
+	if ports := e.repo().forwardPorts["release-1.0"]; len(ports) != 0 {
+		t.Errorf("expected no forward-ports once f's patch landed on master, got %v", ports)
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	testOwner = "acme"
+	testRepo  = "widget"
+)
+
+// testUpstream is a local git repository standing in for a GitHub
+// repository. PRs are modeled as refs/pull/N/head refs, as on GitHub, and are
+// merged with merge commits.
+type testUpstream struct {
+	t   *testing.T
+	dir string
+	gh  *fakeGitHub
+	// clock is the commit and PR timestamp, advanced by every change so that
+	// timestamps are distinct and deterministic.
+	clock time.Time
+}
+
+func newTestUpstream(t *testing.T, gh *fakeGitHub) *testUpstream {
+	u := &testUpstream{
+		t:     t,
+		dir:   filepath.Join(tempDir(t), "upstream"),
+		gh:    gh,
+		clock: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	gh.upstream = u
+	u.git("", "init", "-q", "-b", "master", u.dir)
+	u.commit("alice@example.com", "initial commit", "README", "widget\n")
+	return u
+}
+
+// tempDir returns a temporary directory that is removed when the test ends.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "backboard-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func (u *testUpstream) tick() time.Time {
+	u.clock = u.clock.Add(time.Minute)
+	return u.clock
+}
+
+// git runs git in the upstream repository as the given author (if nonempty)
+// and returns its trimmed stdout.
+func (u *testUpstream) git(author string, args ...string) string {
+	u.t.Helper()
+	out, err := u.tryGit(author, args...)
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	return out
+}
+
+// tryGit is like git, but returns errors instead of failing the test, for
+// use outside the test's goroutine.
+func (u *testUpstream) tryGit(author string, args ...string) (string, error) {
+	if author == "" {
+		author = "bors@example.com"
+	}
+	cmd := exec.Command("git", args...)
+	if _, err := os.Stat(u.dir); err == nil {
+		cmd.Dir = u.dir
+	}
+	date := u.tick().Format(time.RFC3339)
+	name := strings.Split(author, "@")[0]
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME="+name, "GIT_AUTHOR_EMAIL="+author, "GIT_AUTHOR_DATE="+date,
+		"GIT_COMMITTER_NAME="+name, "GIT_COMMITTER_EMAIL="+author, "GIT_COMMITTER_DATE="+date,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// commit commits content to file on the current branch and returns the new
+// commit's SHA.
+func (u *testUpstream) commit(author, title, file, content string) string {
+	u.t.Helper()
+	path := filepath.Join(u.dir, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		u.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		u.t.Fatal(err)
+	}
+	u.git(author, "add", file)
+	u.git(author, "commit", "-q", "-m", title)
+	return u.git("", "rev-parse", "HEAD")
+}
+
+// branch creates a branch at the tip of master.
+func (u *testUpstream) branch(name string) {
+	u.t.Helper()
+	u.git("", "branch", name, "master")
+}
+
+// testCommit describes a commit to be made by openPR.
+type testCommit struct {
+	title, file, content string
+	// cherryPick, if set, is the SHA of the commit to cherry-pick instead.
+	cherryPick string
+}
+
+// openPR opens a PR by author against base whose commits are made by
+// applying cs to the tip of base. It returns the PR and the SHAs of its
+// commits.
+func (u *testUpstream) openPR(author, base, title string, cs ...testCommit) (*github.PullRequest, []string) {
+	u.t.Helper()
+	number := u.gh.nextNumber()
+	head := fmt.Sprintf("pr-%d", number)
+	baseSHA := u.git("", "rev-parse", base)
+	u.git("", "checkout", "-q", "-b", head, base)
+	var shas []string
+	for _, c := range cs {
+		if c.cherryPick != "" {
+			u.git(author, "cherry-pick", "-x", c.cherryPick)
+			shas = append(shas, u.git("", "rev-parse", "HEAD"))
+		} else {
+			shas = append(shas, u.commit(author, c.title, c.file, c.content))
+		}
+	}
+	u.git("", "update-ref", fmt.Sprintf("refs/pull/%d/head", number), "HEAD")
+	u.git("", "checkout", "-q", "master")
+
+	pr := u.newPR(number, strings.Split(author, "@")[0], title, head, base, shas[len(shas)-1], baseSHA)
+	u.gh.putPR(pr)
+	return pr, shas
+}
+
+func (u *testUpstream) newPR(number int, login, title, head, base, headSHA, baseSHA string) *github.PullRequest {
+	now := u.clock
+	return &github.PullRequest{
+		ID:        github.Int64(int64(1000 + number)),
+		Number:    github.Int(number),
+		State:     github.String("open"),
+		Title:     github.String(title),
+		Body:      github.String(""),
+		User:      &github.User{Login: github.String(login)},
+		CreatedAt: &now,
+		UpdatedAt: &now,
+		Head:      &github.PullRequestBranch{Ref: github.String(head), SHA: github.String(headSHA)},
+		Base:      &github.PullRequestBranch{Ref: github.String(base), SHA: github.String(baseSHA)},
+	}
+}
+
+// merge merges pr into its base branch with a merge commit.
+func (u *testUpstream) merge(pr *github.PullRequest) {
+	u.t.Helper()
+	base := pr.GetBase().GetRef()
+	u.git("", "checkout", "-q", base)
+	u.git("", "merge", "-q", "--no-ff", "-m", fmt.Sprintf("Merge #%d", pr.GetNumber()), pr.GetHead().GetRef())
+	u.git("", "checkout", "-q", "master")
+	now := u.clock
+	u.gh.updatePR(pr.GetNumber(), func(pr *github.PullRequest) {
+		pr.State = github.String("closed")
+		pr.MergedAt = &now
+		pr.ClosedAt = &now
+		pr.UpdatedAt = &now
+	})
+}
+
+// fakeGitHub serves the subset of the GitHub API that backboard uses.
+type fakeGitHub struct {
+	*httptest.Server
+	upstream *testUpstream
+	mu       sync.Mutex
+	prs      map[int]*github.PullRequest
+	statuses map[string][]*github.RepoStatus // by SHA, oldest first
+}
+
+// fakeGitHubLogin is the login of the user that backboard authenticates to
+// fakeGitHub as.
+const fakeGitHubLogin = "backboard-bot"
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	gh := &fakeGitHub{prs: map[int]*github.PullRequest{}, statuses: map[string][]*github.RepoStatus{}}
+	mux := http.NewServeMux()
+	prefix := fmt.Sprintf("/repos/%s/%s/", testOwner, testRepo)
+	mux.HandleFunc(prefix+"pulls", gh.servePulls)
+	mux.HandleFunc(prefix+"pulls/", gh.servePull)
+	mux.HandleFunc(prefix+"issues/", gh.serveIssue)
+	mux.HandleFunc(prefix+"statuses/", gh.serveStatuses)
+	gh.Server = httptest.NewServer(mux)
+	t.Cleanup(gh.Close)
+	return gh
+}
+
+// client returns a GitHub client that talks to gh.
+func (gh *fakeGitHub) client() *github.Client {
+	c := github.NewClient(nil)
+	c.BaseURL, _ = url.Parse(gh.URL + "/")
+	return c
+}
+
+func (gh *fakeGitHub) nextNumber() int {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	return len(gh.prs) + 1
+}
+
+func (gh *fakeGitHub) putPR(pr *github.PullRequest) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	gh.prs[pr.GetNumber()] = pr
+}
+
+func (gh *fakeGitHub) pr(number int) *github.PullRequest {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	return gh.prs[number]
+}
+
+func (gh *fakeGitHub) updatePR(number int, fn func(*github.PullRequest)) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	fn(gh.prs[number])
+}
+
+func (gh *fakeGitHub) servePulls(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		gh.createPull(w, r)
+		return
+	}
+	gh.mu.Lock()
+	var prs []*github.PullRequest
+	for _, pr := range gh.prs {
+		prs = append(prs, pr)
+	}
+	gh.mu.Unlock()
+	// Like GitHub, when asked to sort by update time, descending.
+	sort.Slice(prs, func(i, j int) bool {
+		return prs[i].GetUpdatedAt().After(prs[j].GetUpdatedAt())
+	})
+	writeJSON(w, prs)
+}
+
+// createPull opens a PR from a branch of the upstream repository.
+func (gh *fakeGitHub) createPull(w http.ResponseWriter, r *http.Request) {
+	var req github.NewPullRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Heads from forks are named "owner:branch"; the fake has no forks.
+	head := req.GetHead()
+	if i := strings.Index(head, ":"); i >= 0 {
+		head = head[i+1:]
+	}
+	u := gh.upstream
+	number := gh.nextNumber()
+	headSHA, err := u.tryGit("", "rev-parse", head)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	baseSHA, err := u.tryGit("", "rev-parse", req.GetBase())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if _, err := u.tryGit("", "update-ref", fmt.Sprintf("refs/pull/%d/head", number), headSHA); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pr := u.newPR(number, fakeGitHubLogin, req.GetTitle(), head, req.GetBase(), headSHA, baseSHA)
+	pr.Body = req.Body
+	gh.putPR(pr)
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, pr)
+}
+
+// parseNumber parses the PR or issue number that follows prefix in r's path.
+func parseNumber(r *http.Request, prefix string) (number int, rest string, ok bool) {
+	path := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/repos/%s/%s/%s/", testOwner, testRepo, prefix))
+	parts := strings.SplitN(path, "/", 2)
+	n, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", false
+	}
+	if len(parts) == 2 {
+		rest = parts[1]
+	}
+	return n, rest, true
+}
+
+func (gh *fakeGitHub) servePull(w http.ResponseWriter, r *http.Request) {
+	number, rest, ok := parseNumber(r, "pulls")
+	pr := gh.pr(number)
+	if !ok || rest != "" || pr == nil || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, pr)
+}
+
+// serveIssue handles the issue endpoints that modify PRs.
+func (gh *fakeGitHub) serveIssue(w http.ResponseWriter, r *http.Request) {
+	number, rest, ok := parseNumber(r, "issues")
+	if !ok || gh.pr(number) == nil || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	switch rest {
+	case "labels":
+		var names []string
+		if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var labels []*github.Label
+		gh.updatePR(number, func(pr *github.PullRequest) {
+			for _, name := range names {
+				pr.Labels = append(pr.Labels, &github.Label{Name: github.String(name)})
+			}
+			labels = pr.Labels
+		})
+		writeJSON(w, labels)
+	case "assignees":
+		var req struct{ Assignees []string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var pr github.PullRequest
+		gh.updatePR(number, func(p *github.PullRequest) {
+			for _, login := range req.Assignees {
+				p.Assignees = append(p.Assignees, &github.User{Login: github.String(login)})
+			}
+			pr = *p
+		})
+		writeJSON(w, &github.Issue{Number: pr.Number, Assignees: pr.Assignees})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveStatuses creates commit statuses.
+func (gh *fakeGitHub) serveStatuses(w http.ResponseWriter, r *http.Request) {
+	sha := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/repos/%s/%s/statuses/", testOwner, testRepo))
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var status github.RepoStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	gh.mu.Lock()
+	gh.statuses[sha] = append(gh.statuses[sha], &status)
+	gh.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, &status)
+}
+
+// takeStatuses returns the commit statuses created since the last call, as
+// "<sha> <context> <state>: <description>" strings, sorted.
+func (gh *fakeGitHub) takeStatuses() []string {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	var out []string
+	for sha, statuses := range gh.statuses {
+		for _, s := range statuses {
+			out = append(out, fmt.Sprintf("%s %s %s: %s", sha, s.GetContext(), s.GetState(), s.GetDescription()))
+		}
+	}
+	sort.Strings(out)
+	gh.statuses = map[string][]*github.RepoStatus{}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		panic(err)
+	}
+}
+
+// localCredentials clones from a local path instead of GitHub.
+type localCredentials struct {
+	dir string
+}
+
+func (c localCredentials) url(owner, name string) string { return c.dir }
+func (localCredentials) args() []string                  { return nil }
+func (localCredentials) env() ([]string, error)          { return nil, nil }
+func (localCredentials) String() string                  { return "local" }
+
+// testEnv wires a testUpstream and fakeGitHub to backboard's sync process.
+type testEnv struct {
+	t        *testing.T
+	ctx      context.Context
+	upstream *testUpstream
+	gh       *fakeGitHub
+	store    store
+	booted   bool
+}
+
+// newTestEnv creates a test environment. Because backboard keeps its repos
+// in package-level state, tests that use testEnv must not run in parallel.
+func newTestEnv(t *testing.T, st store) *testEnv {
+	gh := newFakeGitHub(t)
+	e := &testEnv{
+		t:        t,
+		ctx:      context.Background(),
+		upstream: newTestUpstream(t, gh),
+		gh:       gh,
+		store:    st,
+	}
+
+	oldRepos, oldCloneDir := repos, cloneDir
+	t.Cleanup(func() { repos, cloneDir = oldRepos, oldCloneDir })
+	cloneDir = tempDir(t)
+	repos = []repo{{
+		githubOwner: testOwner,
+		githubRepo:  testRepo,
+		credentials: localCredentials{dir: e.upstream.dir},
+	}}
+	return e
+}
+
+// sync bootstraps backboard, if it hasn't been already, and syncs the
+// upstream repository.
+func (e *testEnv) sync() {
+	e.t.Helper()
+	if !e.booted {
+		if err := bootstrap(e.ctx, e.store); err != nil {
+			e.t.Fatal(err)
+		}
+		e.booted = true
+	}
+	if err := syncAll(e.ctx, e.gh.client(), e.store); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func (e *testEnv) repo() repo {
+	repoLock.RLock()
+	defer repoLock.RUnlock()
+	return repos[0]
+}
+
+// board computes the board that serveBoard would render.
+func (e *testEnv) board(opts boardOptions) *board {
+	e.t.Helper()
+	repoLock.RLock()
+	defer repoLock.RUnlock()
+	b, err := buildBoard(e.ctx, e.store, repos[0], opts)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return b
+}
+
+// forEachStore runs fn as a subtest against each store implementation.
+func forEachStore(t *testing.T, fn func(t *testing.T, st store)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, newMemStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		st, err := openStore(context.Background(), sqlitePrefix+filepath.Join(tempDir(t), "backboard.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.(*sqlStore).db.Close() })
+		fn(t, st)
+	})
+}
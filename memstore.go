@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memStore is a store that keeps its state in memory, for tests and for
+// kicking the tires without a database.
+type memStore struct {
+	mu struct {
+		sync.Mutex
+		repos         map[[2]string]*repoRecord
+		tracked       map[int64]bool
+		prs           map[int64]prRecord
+		prCommits     map[int64][]commit // by PR ID
+		exclusions    map[memExclusionKey]exclusion
+		comments      map[memCommentKey][]comment
+		roles         map[memRoleKey]role
+		events        []event
+		predictions   map[memPredictionKey]prediction
+		dependencies  map[memDependencyKey][]sha
+		cherries      map[memCherryKey]cherryResult
+		components    map[int64]map[string]component // by repo ID and name
+		notifications map[int64]map[notificationKey]time.Time
+		webhooks      map[string]webhook // by URL
+		deliveries    []webhookDelivery  // by ascending ID
+		statuses      map[int64]map[string]commitStatus
+	}
+}
+
+type memExclusionKey struct {
+	repoID    int64
+	branch    string
+	messageID string
+}
+
+type memCommentKey struct {
+	repoID    int64
+	messageID string
+}
+
+type memRoleKey struct {
+	repoID   int64
+	branch   string
+	username string
+}
+
+type memPredictionKey struct {
+	repoID    int64
+	branchTip string
+	sha       string
+}
+
+type memDependencyKey struct {
+	repoID    int64
+	mergeBase string
+	sha       string
+}
+
+type memCherryKey struct {
+	repoID int64
+	branch string
+}
+
+var _ store = (*memStore)(nil)
+
+func newMemStore() *memStore {
+	s := &memStore{}
+	s.mu.repos = map[[2]string]*repoRecord{}
+	s.mu.tracked = map[int64]bool{}
+	s.mu.prs = map[int64]prRecord{}
+	s.mu.prCommits = map[int64][]commit{}
+	s.mu.exclusions = map[memExclusionKey]exclusion{}
+	s.mu.comments = map[memCommentKey][]comment{}
+	s.mu.roles = map[memRoleKey]role{}
+	s.mu.predictions = map[memPredictionKey]prediction{}
+	s.mu.dependencies = map[memDependencyKey][]sha{}
+	s.mu.cherries = map[memCherryKey]cherryResult{}
+	s.mu.components = map[int64]map[string]component{}
+	s.mu.notifications = map[int64]map[notificationKey]time.Time{}
+	s.mu.webhooks = map[string]webhook{}
+	s.mu.statuses = map[int64]map[string]commitStatus{}
+	return s
+}
+
+func (s *memStore) ensureRepo(ctx context.Context, owner, name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ensureRepoLocked(owner, name).id, nil
+}
+
+func (s *memStore) ensureRepoLocked(owner, name string) *repoRecord {
+	key := [2]string{owner, name}
+	if r, ok := s.mu.repos[key]; ok {
+		return r
+	}
+	r := &repoRecord{
+		id:          int64(len(s.mu.repos) + 1),
+		owner:       owner,
+		name:        name,
+		credentials: noCredentials{}.String(),
+	}
+	s.mu.repos[key] = r
+	s.mu.tracked[r.id] = true
+	return r
+}
+
+func (s *memStore) putRepo(ctx context.Context, r repoRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.ensureRepoLocked(r.owner, r.name)
+	stored.credentials = r.credentials
+	s.mu.tracked[stored.id] = true
+	return nil
+}
+
+func (s *memStore) untrackRepo(ctx context.Context, owner, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.mu.repos[[2]string{owner, name}]
+	if !ok || !s.mu.tracked[r.id] {
+		return fmt.Errorf("%s/%s is not tracked", owner, name)
+	}
+	s.mu.tracked[r.id] = false
+	return nil
+}
+
+func (s *memStore) trackedRepos(ctx context.Context) ([]repoRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []repoRecord
+	for _, r := range s.mu.repos {
+		if s.mu.tracked[r.id] {
+			out = append(out, *r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].owner != out[j].owner {
+			return out[i].owner < out[j].owner
+		}
+		return out[i].name < out[j].name
+	})
+	return out, nil
+}
+
+func (s *memStore) prUpdatedAt(ctx context.Context, id int64) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.mu.prs[id]
+	return p.updatedAt, ok, nil
+}
+
+func (s *memStore) putPR(
+	ctx context.Context, p prRecord, commits []commit, transitions func(prev *prState) []event,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var prev *prState
+	if old, ok := s.mu.prs[p.id]; ok {
+		if old.updatedAt.Equal(p.updatedAt) {
+			return nil
+		}
+		prev = &prState{open: old.open, merged: old.mergedAt != nil, baseBranch: old.baseBranch}
+	}
+	for _, ev := range transitions(prev) {
+		s.recordEventLocked(ev)
+	}
+	s.mu.prs[p.id] = p
+	s.mu.prCommits[p.id] = append([]commit(nil), commits...)
+	return nil
+}
+
+func (s *memStore) livePRCommits(ctx context.Context, repoID int64) ([]prCommit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []prCommit
+	for id, p := range s.mu.prs {
+		if p.repoID != repoID || (p.mergedAt == nil && !p.open) {
+			continue
+		}
+		for _, c := range s.mu.prCommits[id] {
+			pc := prCommit{
+				number:     p.number,
+				open:       p.open,
+				baseBranch: p.baseBranch,
+				sha:        c.sha,
+				messageID:  c.MessageID(),
+			}
+			if p.mergedAt != nil {
+				pc.mergedAt.Time, pc.mergedAt.Valid = *p.mergedAt, true
+			}
+			out = append(out, pc)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) prBodies(ctx context.Context, repoID int64, numbers []int) (map[int]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	want := map[int]bool{}
+	for _, n := range numbers {
+		want[n] = true
+	}
+	bodies := map[int]string{}
+	for _, p := range s.mu.prs {
+		if p.repoID == repoID && want[p.number] {
+			bodies[p.number] = p.body
+		}
+	}
+	return bodies, nil
+}
+
+func (s *memStore) mergedPRs(ctx context.Context, repoID int64, baseBranch string, since time.Time) ([]prRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []prRecord
+	for _, p := range s.mu.prs {
+		if p.repoID == repoID && p.baseBranch == baseBranch && p.mergedAt != nil && !p.mergedAt.Before(since) {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].mergedAt.Before(*out[j].mergedAt) })
+	return out, nil
+}
+
+func (s *memStore) exclusions(ctx context.Context, repoID int64, branch string) (map[string]exclusion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]exclusion{}
+	for k, e := range s.mu.exclusions {
+		if k.repoID == repoID && k.branch == branch {
+			out[k.messageID] = e
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) putExclusion(
+	ctx context.Context, repoID int64, branch, messageID string, e exclusion, ev event,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.exclusions[memExclusionKey{repoID, branch, messageID}] = e
+	s.recordEventLocked(ev)
+	return nil
+}
+
+func (s *memStore) deleteExclusion(
+	ctx context.Context, repoID int64, branch, messageID string, ev event,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mu.exclusions, memExclusionKey{repoID, branch, messageID})
+	s.recordEventLocked(ev)
+	return nil
+}
+
+func (s *memStore) comments(ctx context.Context, repoID int64) (map[string][]comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string][]comment{}
+	for k, cs := range s.mu.comments {
+		if k.repoID == repoID {
+			out[k.messageID] = append([]comment(nil), cs...)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) addComment(ctx context.Context, repoID int64, messageID string, c comment, ev event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := memCommentKey{repoID, messageID}
+	cs := append(s.mu.comments[k], c)
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].CreatedAt.Before(cs[j].CreatedAt) })
+	s.mu.comments[k] = cs
+	s.recordEventLocked(ev)
+	return nil
+}
+
+func (s *memStore) roles(ctx context.Context, repoID int64, username string) (map[string]role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]role{}
+	for k, r := range s.mu.roles {
+		if k.repoID == repoID && k.username == username {
+			out[k.branch] = r
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) roleGrants(ctx context.Context, repoID int64) ([]roleGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []roleGrant
+	for k, r := range s.mu.roles {
+		if k.repoID == repoID {
+			out = append(out, roleGrant{Username: k.username, Branch: k.branch, Role: r})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Username != out[j].Username {
+			return out[i].Username < out[j].Username
+		}
+		return out[i].Branch < out[j].Branch
+	})
+	return out, nil
+}
+
+func (s *memStore) grantRole(
+	ctx context.Context, repoID int64, branch, username string, r role, ev event,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.roles[memRoleKey{repoID, branch, username}] = r
+	s.recordEventLocked(ev)
+	return nil
+}
+
+func (s *memStore) recordEvent(ctx context.Context, ev event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordEventLocked(ev)
+	return nil
+}
+
+func (s *memStore) recordEventLocked(ev event) {
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	ev.ID = int64(len(s.mu.events) + 1)
+	s.mu.events = append(s.mu.events, ev)
+	if !webhookEvent(ev) {
+		return
+	}
+	now := time.Now()
+	for _, w := range s.webhooksLocked() {
+		var id int64 = 1
+		if n := len(s.mu.deliveries); n > 0 {
+			id = s.mu.deliveries[n-1].ID + 1
+		}
+		s.mu.deliveries = append(s.mu.deliveries, webhookDelivery{
+			ID:            id,
+			Webhook:       w,
+			Event:         ev,
+			CreatedAt:     now,
+			NextAttemptAt: &now,
+		})
+	}
+}
+
+func (s *memStore) events(ctx context.Context, f eventFilter, limit int) ([]event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prNumbers := map[int]bool{}
+	if f.messageID != "" {
+		for id, p := range s.mu.prs {
+			if p.repoID != f.repoID {
+				continue
+			}
+			for _, c := range s.mu.prCommits[id] {
+				if c.MessageID() == f.messageID {
+					prNumbers[p.number] = true
+				}
+			}
+		}
+	}
+	var out []event
+	for i := len(s.mu.events) - 1; i >= 0 && len(out) < limit; i-- {
+		if ev := s.mu.events[i]; matchEventFilter(ev, f, prNumbers) {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) predictions(ctx context.Context, repoID int64, branchTip sha) (map[string]prediction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]prediction{}
+	for k, p := range s.mu.predictions {
+		if k.repoID == repoID && k.branchTip == string(branchTip) {
+			out[k.sha] = p
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) putPrediction(ctx context.Context, repoID int64, branchTip, c sha, p prediction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.predictions[memPredictionKey{repoID, string(branchTip), string(c)}] = p
+	return nil
+}
+
+func (s *memStore) prunePredictions(ctx context.Context, repoID int64, tips []sha) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := map[string]bool{}
+	for _, tip := range tips {
+		keep[string(tip)] = true
+	}
+	for k := range s.mu.predictions {
+		if k.repoID == repoID && !keep[k.branchTip] {
+			delete(s.mu.predictions, k)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) dependencies(ctx context.Context, repoID int64, mergeBase sha) (map[string][]sha, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string][]sha{}
+	for k, deps := range s.mu.dependencies {
+		if k.repoID == repoID && k.mergeBase == string(mergeBase) {
+			out[k.sha] = append([]sha(nil), deps...)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) putDependencies(ctx context.Context, repoID int64, mergeBase, c sha, deps []sha) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.dependencies[memDependencyKey{repoID, string(mergeBase), string(c)}] = append([]sha(nil), deps...)
+	return nil
+}
+
+func (s *memStore) cherryResult(ctx context.Context, repoID int64, branch string) (cherryResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.mu.cherries[memCherryKey{repoID, branch}]
+	r.unmatched = append([]sha(nil), r.unmatched...)
+	return r, ok, nil
+}
+
+func (s *memStore) putCherryResult(ctx context.Context, repoID int64, branch string, r cherryResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.unmatched = append([]sha(nil), r.unmatched...)
+	s.mu.cherries[memCherryKey{repoID, branch}] = r
+	return nil
+}
+
+func (s *memStore) components(ctx context.Context, repoID int64) ([]component, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []component
+	for _, c := range s.mu.components[repoID] {
+		c.Globs = append([]string(nil), c.Globs...)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (s *memStore) putComponent(ctx context.Context, repoID int64, c component) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.components[repoID] == nil {
+		s.mu.components[repoID] = map[string]component{}
+	}
+	c.Globs = append([]string(nil), c.Globs...)
+	s.mu.components[repoID][c.Name] = c
+	return nil
+}
+
+func (s *memStore) deleteComponent(ctx context.Context, repoID int64, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mu.components[repoID][name]; !ok {
+		return fmt.Errorf("no component named %q", name)
+	}
+	delete(s.mu.components[repoID], name)
+	return nil
+}
+
+func (s *memStore) search(ctx context.Context, q searchQuery, limit int) ([]searchHit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type orderedHit struct {
+		searchHit
+		ordering int
+	}
+	var hits []orderedHit
+	for id, p := range s.mu.prs {
+		if !s.mu.tracked[p.repoID] || (p.mergedAt == nil && !p.open) {
+			continue
+		}
+		prMatches := len(q.words) > 0 && containsWords(p.title+"\n"+p.body, q.words)
+		for i, c := range s.mu.prCommits[id] {
+			matches := prMatches ||
+				(len(q.words) > 0 && containsWords(c.title+"\n"+c.body, q.words)) ||
+				(q.shaLow != nil && bytes.Compare(c.sha, q.shaLow) >= 0 && bytes.Compare(c.sha, q.shaHigh) <= 0) ||
+				(q.prNumber != 0 && p.number == q.prNumber)
+			if !matches {
+				continue
+			}
+			h := orderedHit{searchHit: searchHit{
+				prCommit: prCommit{
+					number:     p.number,
+					open:       p.open,
+					baseBranch: p.baseBranch,
+					sha:        c.sha,
+					messageID:  c.MessageID(),
+				},
+				repoID:  p.repoID,
+				prTitle: p.title,
+				title:   c.title,
+			}, ordering: i}
+			if p.mergedAt != nil {
+				h.mergedAt.Time, h.mergedAt.Valid = *p.mergedAt, true
+			}
+			hits = append(hits, h)
+		}
+	}
+	// Mirror the ORDER BY of sqlStore.search.
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.number != b.number {
+			return a.number > b.number
+		}
+		if a.repoID != b.repoID {
+			return a.repoID < b.repoID
+		}
+		return a.ordering < b.ordering
+	})
+	var out []searchHit
+	for _, h := range hits {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, h.searchHit)
+	}
+	return out, nil
+}
+
+func (s *memStore) notifications(ctx context.Context, repoID int64) (map[notificationKey]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := map[notificationKey]time.Time{}
+	for k, t := range s.mu.notifications[repoID] {
+		sent[k] = t
+	}
+	return sent, nil
+}
+
+func (s *memStore) putNotifications(ctx context.Context, repoID int64, keys []notificationKey, sentAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.notifications[repoID] == nil {
+		s.mu.notifications[repoID] = map[notificationKey]time.Time{}
+	}
+	for _, k := range keys {
+		s.mu.notifications[repoID][k] = sentAt
+	}
+	return nil
+}
+
+func (s *memStore) webhooks(ctx context.Context) ([]webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.webhooksLocked(), nil
+}
+
+func (s *memStore) webhooksLocked() []webhook {
+	var out []webhook
+	for _, w := range s.mu.webhooks {
+		out = append(out, w)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out
+}
+
+func (s *memStore) putWebhook(ctx context.Context, w webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.mu.webhooks[w.URL]; ok {
+		w.CreatedAt = old.CreatedAt
+	}
+	s.mu.webhooks[w.URL] = w
+	for i := range s.mu.deliveries {
+		if s.mu.deliveries[i].Webhook.URL == w.URL {
+			s.mu.deliveries[i].Webhook = w
+		}
+	}
+	return nil
+}
+
+func (s *memStore) deleteWebhook(ctx context.Context, url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mu.webhooks[url]; !ok {
+		return fmt.Errorf("no webhook with URL %q", url)
+	}
+	delete(s.mu.webhooks, url)
+	var kept []webhookDelivery
+	for _, d := range s.mu.deliveries {
+		if d.Webhook.URL != url {
+			kept = append(kept, d)
+		}
+	}
+	s.mu.deliveries = kept
+	return nil
+}
+
+func (s *memStore) dueDeliveries(ctx context.Context, now time.Time, limit int) ([]webhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []webhookDelivery
+	for _, d := range s.mu.deliveries {
+		if d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) {
+			out = append(out, d)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].NextAttemptAt.Before(*out[j].NextAttemptAt) })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *memStore) deliveries(ctx context.Context, limit int) ([]webhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []webhookDelivery
+	for i := len(s.mu.deliveries) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, s.mu.deliveries[i])
+	}
+	return out, nil
+}
+
+func (s *memStore) putDeliveryAttempt(ctx context.Context, d webhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.mu.deliveries {
+		if o := &s.mu.deliveries[i]; o.ID == d.ID {
+			o.Attempts, o.LastAttemptAt, o.LastError = d.Attempts, d.LastAttemptAt, d.LastError
+			o.DeliveredAt, o.NextAttemptAt = d.DeliveredAt, d.NextAttemptAt
+		}
+	}
+	return nil
+}
+
+func (s *memStore) commitStatuses(ctx context.Context, repoID int64) (map[string]commitStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := map[string]commitStatus{}
+	for sha, cs := range s.mu.statuses[repoID] {
+		statuses[sha] = cs
+	}
+	return statuses, nil
+}
+
+func (s *memStore) putCommitStatus(ctx context.Context, repoID int64, sha string, cs commitStatus, postedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.statuses[repoID] == nil {
+		s.mu.statuses[repoID] = map[string]commitStatus{}
+	}
+	s.mu.statuses[repoID][sha] = cs
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are exported in the Prometheus format at /metrics.
+var (
+	syncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "backboard_sync_duration_seconds",
+		Help:    "Time taken to sync a repo, including fetching and refreshing it.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"repo"})
+	syncLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backboard_sync_last_success_timestamp_seconds",
+		Help: "Time at which a repo last synced successfully.",
+	}, []string{"repo"})
+	prsSynced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backboard_prs_synced_total",
+		Help: "PRs fetched from GitHub and synced into the store.",
+	}, []string{"repo"})
+	gitFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "backboard_git_fetch_duration_seconds",
+		Help:    "Time taken to fetch a repo's mirror.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 12),
+	}, []string{"repo"})
+	refreshDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "backboard_refresh_duration_seconds",
+		Help:    "Time taken to reload a repo's commits and PRs from its mirror and the store.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 12),
+	}, []string{"repo"})
+	outstandingBackports = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backboard_outstanding_backports",
+		Help: "Commits that remain to be backported to a release branch, as of the last sync.",
+	}, []string{"repo", "branch"})
+	githubAPICalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backboard_github_api_calls_total",
+		Help: "Requests made to the GitHub API, by response status code.",
+	}, []string{"code"})
+	githubRateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "backboard_github_rate_limit_remaining",
+		Help: "Requests remaining in the current GitHub API rate limit window, as of the last response.",
+	})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "backboard_http_request_duration_seconds",
+		Help:    "Time taken to serve requests to the board, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+)
+
+// githubTransport counts the requests made to the GitHub API and tracks the
+// remaining rate limit.
+type githubTransport struct {
+	base http.RoundTripper
+}
+
+func (t githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		githubAPICalls.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	githubAPICalls.WithLabelValues(strconv.Itoa(res.StatusCode)).Inc()
+	if remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining")); err == nil {
+		githubRateLimitRemaining.Set(float64(remaining))
+	}
+	return res, nil
+}
+
+// updateOutstandingBackports sets the outstanding backport gauges for each
+// release branch of re to the number of commits its board lists as missing.
+// Callers must hold repoLock or own re.
+func updateOutstandingBackports(ctx context.Context, st store, re repo) error {
+	for _, branch := range re.releaseBranches {
+		b, err := buildBoard(ctx, st, re, boardOptions{branch: branch})
+		if err != nil {
+			return err
+		}
+		var missing int
+		for _, c := range b.Commits {
+			if c.Missing() {
+				missing++
+			}
+		}
+		outstandingBackports.WithLabelValues(re.String(), branch).Set(float64(missing))
+	}
+	return nil
+}
+
+var metricsHandler = promhttp.Handler()
+
+func (s *server) serveMetrics(w http.ResponseWriter, r *http.Request) error {
+	metricsHandler.ServeHTTP(w, r)
+	return nil
+}
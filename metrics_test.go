@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	e := newTestEnv(t, newMemStore())
+	setupBackports(e)
+	synced := testutil.ToFloat64(prsSynced.WithLabelValues("acme/widget"))
+	e.sync()
+
+	if n := testutil.ToFloat64(prsSynced.WithLabelValues("acme/widget")) - synced; n != 5 {
+		t.Errorf("expected 5 PRs synced, got %v", n)
+	}
+	// b1's backport is open, and b2 and c have none.
+	if n := testutil.ToFloat64(outstandingBackports.WithLabelValues("acme/widget", "release-1.0")); n != 3 {
+		t.Errorf("expected 3 outstanding backports, got %v", n)
+	}
+
+	s := &server{store: e.store}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `backboard_outstanding_backports{branch="release-1.0",repo="acme/widget"} 3`) {
+		t.Errorf("unexpected metrics (%d):\n%s", w.Code, w.Body)
+	}
+}
+
+func TestGitHubTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "4321")
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	calls := testutil.ToFloat64(githubAPICalls.WithLabelValues("404"))
+	client := &http.Client{Transport: githubTransport{base: http.DefaultTransport}}
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if n := testutil.ToFloat64(githubAPICalls.WithLabelValues("404")) - calls; n != 1 {
+		t.Errorf("expected 1 call counted, got %v", n)
+	}
+	if n := testutil.ToFloat64(githubRateLimitRemaining); n != 4321 {
+		t.Errorf("expected a remaining rate limit of 4321, got %v", n)
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// migration is a versioned change to the database schema. Migrations are
+// applied in order, each in its own transaction, and are never edited once
+// released; change the schema by appending a new migration instead.
+type migration struct {
+	version int
+	name    string
+	up      string
+	// dialectUp replaces up for the dialects it names, for changes that
+	// translation can't express, like indexes that only some databases
+	// support.
+	dialectUp map[string]string
+	// requires, if set, returns an error explaining why db can't apply the
+	// migration, before it is attempted.
+	requires func(db *database) error
+}
+
+// upFor returns the DDL that applies m in dialect d.
+func (m migration) upFor(d dialect) string {
+	if up, ok := m.dialectUp[d.String()]; ok {
+		return up
+	}
+	return d.translate(m.up)
+}
+
+// migrations must be sorted by version, with no gaps. They are written for
+// CockroachDB and translated for other databases by their dialect.
+//
+// The first two migrations predate versioning and use IF NOT EXISTS so that
+// they adopt databases created by older binaries.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		up: `
+CREATE TABLE IF NOT EXISTS repos (
+	id serial PRIMARY KEY,
+	github_owner string NOT NULL,
+	github_repo string NOT NULL,
+	UNIQUE (github_owner, github_repo)
+);
+
+CREATE TABLE IF NOT EXISTS prs (
+	id int PRIMARY KEY,
+	repo_id int REFERENCES repos,
+	number int,
+	title string,
+	body string,
+	open bool,
+	merged_at timestamptz,
+	base_sha bytes,
+	base_branch string,
+	author_username string,
+	updated_at timestamptz,
+	UNIQUE (repo_id, number)
+);
+
+CREATE TABLE IF NOT EXISTS pr_commits (
+	pr_id int REFERENCES prs,
+	sha bytes,
+	title string,
+	body string,
+	message_id bytes,
+	author_email string,
+	ordering int,
+	PRIMARY KEY (pr_id, sha)
+);
+
+CREATE TABLE IF NOT EXISTS exclusions (
+	message_id bytes PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS commit_comments (
+	message_id bytes,
+	created_at timestamptz,
+	sha bytes,
+	user_email string,
+	body string,
+	PRIMARY KEY (message_id, created_at)
+);`,
+	},
+	{
+		version: 2,
+		name:    "exclusions, roles and events",
+		up: `
+CREATE TABLE IF NOT EXISTS branch_exclusions (
+	repo_id int REFERENCES repos,
+	branch string,
+	message_id bytes,
+	created_by string,
+	created_at timestamptz,
+	reason string,
+	PRIMARY KEY (repo_id, branch, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+	repo_id int REFERENCES repos,
+	branch string,
+	username string,
+	role string,
+	PRIMARY KEY (repo_id, branch, username)
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	id serial PRIMARY KEY,
+	created_at timestamptz NOT NULL,
+	repo_id int REFERENCES repos,
+	branch string,
+	actor string,
+	kind string NOT NULL,
+	message_id bytes,
+	sha bytes,
+	pr_number int,
+	detail string
+);
+
+CREATE INDEX IF NOT EXISTS events_repo_id_message_id_idx ON events (repo_id, message_id);
+CREATE INDEX IF NOT EXISTS events_repo_id_pr_number_idx ON events (repo_id, pr_number);`,
+	},
+	{
+		version: 3,
+		name:    "repo configuration",
+		up: `
+ALTER TABLE repos ADD COLUMN credentials string NOT NULL DEFAULT 'none';
+ALTER TABLE repos ADD COLUMN tracked bool NOT NULL DEFAULT true;`,
+	},
+	{
+		version: 4,
+		name:    "conflict predictions",
+		up: `
+CREATE TABLE conflict_predictions (
+	repo_id int NOT NULL REFERENCES repos,
+	branch_tip bytes NOT NULL,
+	sha bytes NOT NULL,
+	conflicts string NOT NULL,
+	PRIMARY KEY (repo_id, branch_tip, sha)
+);`,
+	},
+	{
+		version: 5,
+		name:    "commit dependencies",
+		up: `
+CREATE TABLE commit_dependencies (
+	repo_id int NOT NULL REFERENCES repos,
+	merge_base bytes NOT NULL,
+	sha bytes NOT NULL,
+	depends_on string NOT NULL,
+	PRIMARY KEY (repo_id, merge_base, sha)
+);`,
+	},
+	{
+		version: 6,
+		name:    "components",
+		up: `
+CREATE TABLE components (
+	repo_id int NOT NULL REFERENCES repos,
+	name string NOT NULL,
+	globs string NOT NULL,
+	PRIMARY KEY (repo_id, name)
+);`,
+	},
+	{
+		version:  7,
+		name:     "search indexes",
+		requires: requireCockroachVersion(23, 1),
+		up: `
+CREATE INDEX prs_search_idx ON prs
+	USING GIN (to_tsvector('english', coalesce(title, '') || ' ' || coalesce(body, '')));
+CREATE INDEX pr_commits_search_idx ON pr_commits
+	USING GIN (to_tsvector('english', coalesce(title, '') || ' ' || coalesce(body, '')));`,
+		// SQLite indexes text in FTS4 tables that triggers keep in sync with
+		// the tables whose content they index, by rowid. pr_commits is keyed
+		// on a stable rowid by migration 13.
+		dialectUp: map[string]string{"sqlite": `
+CREATE VIRTUAL TABLE prs_fts USING fts4 (content="prs", title, body);
+CREATE TRIGGER prs_fts_bu BEFORE UPDATE ON prs BEGIN
+	DELETE FROM prs_fts WHERE docid = old.rowid;
+END;
+CREATE TRIGGER prs_fts_bd BEFORE DELETE ON prs BEGIN
+	DELETE FROM prs_fts WHERE docid = old.rowid;
+END;
+CREATE TRIGGER prs_fts_au AFTER UPDATE ON prs BEGIN
+	INSERT INTO prs_fts (docid, title, body) VALUES (new.rowid, new.title, new.body);
+END;
+CREATE TRIGGER prs_fts_ai AFTER INSERT ON prs BEGIN
+	INSERT INTO prs_fts (docid, title, body) VALUES (new.rowid, new.title, new.body);
+END;
+INSERT INTO prs_fts (prs_fts) VALUES ('rebuild');
+
+CREATE VIRTUAL TABLE pr_commits_fts USING fts4 (content="pr_commits", title, body);
+CREATE TRIGGER pr_commits_fts_bu BEFORE UPDATE ON pr_commits BEGIN
+	DELETE FROM pr_commits_fts WHERE docid = old.rowid;
+END;
+CREATE TRIGGER pr_commits_fts_bd BEFORE DELETE ON pr_commits BEGIN
+	DELETE FROM pr_commits_fts WHERE docid = old.rowid;
+END;
+CREATE TRIGGER pr_commits_fts_au AFTER UPDATE ON pr_commits BEGIN
+	INSERT INTO pr_commits_fts (docid, title, body) VALUES (new.rowid, new.title, new.body);
+END;
+CREATE TRIGGER pr_commits_fts_ai AFTER INSERT ON pr_commits BEGIN
+	INSERT INTO pr_commits_fts (docid, title, body) VALUES (new.rowid, new.title, new.body);
+END;
+INSERT INTO pr_commits_fts (pr_commits_fts) VALUES ('rebuild');`},
+	},
+	{
+		version: 8,
+		name:    "pr labels and notifications",
+		up: `
+ALTER TABLE prs ADD COLUMN labels string NOT NULL DEFAULT '';
+
+CREATE TABLE notifications (
+	repo_id int NOT NULL REFERENCES repos,
+	channel string NOT NULL,
+	recipient string NOT NULL,
+	message_id bytes NOT NULL,
+	branch string NOT NULL,
+	sent_at timestamptz NOT NULL,
+	PRIMARY KEY (repo_id, channel, recipient, message_id, branch)
+);`,
+	},
+	{
+		version: 9,
+		name:    "outgoing webhooks",
+		up: `
+CREATE TABLE webhooks (
+	id serial PRIMARY KEY,
+	url string NOT NULL UNIQUE,
+	secret_env string NOT NULL,
+	created_at timestamptz NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+	id serial PRIMARY KEY,
+	webhook_id int NOT NULL REFERENCES webhooks,
+	event_id int NOT NULL REFERENCES events,
+	created_at timestamptz NOT NULL,
+	attempts int NOT NULL DEFAULT 0,
+	last_attempt_at timestamptz,
+	last_error string NOT NULL DEFAULT '',
+	delivered_at timestamptz,
+	next_attempt_at timestamptz
+);
+
+CREATE INDEX webhook_deliveries_next_attempt_at_idx ON webhook_deliveries (next_attempt_at);`,
+	},
+	{
+		version: 10,
+		name:    "pr heads and commit statuses",
+		up: `
+ALTER TABLE prs ADD COLUMN head_sha bytes;
+
+CREATE TABLE commit_statuses (
+	repo_id int NOT NULL REFERENCES repos,
+	sha bytes NOT NULL,
+	state string NOT NULL,
+	description string NOT NULL,
+	posted_at timestamptz NOT NULL,
+	PRIMARY KEY (repo_id, sha)
+);`,
+	},
+	{
+		version: 11,
+		name:    "comment repos",
+		up: `
+ALTER TABLE commit_comments ADD COLUMN repo_id int REFERENCES repos;`,
+	},
+	{
+		// CockroachDB can't write to a column in the transaction that adds
+		// it, so comments are assigned to repos in a migration of their own.
+		// Each comment belongs to the repo of the event that recorded it;
+		// the few that predate events are assigned to the first repo.
+		version: 12,
+		name:    "assign comments to repos",
+		up: `
+UPDATE commit_comments SET repo_id = (
+	SELECT e.repo_id FROM events e
+	WHERE e.kind = 'commented' AND e.detail = commit_comments.body
+		AND CAST(e.message_id AS string) = CAST(commit_comments.message_id AS string)
+	ORDER BY e.id LIMIT 1
+);
+UPDATE commit_comments SET repo_id = (SELECT min(id) FROM repos) WHERE repo_id IS NULL;
+CREATE INDEX commit_comments_repo_id_idx ON commit_comments (repo_id, message_id);`,
+	},
+	{
+		version: 13,
+		name:    "search lookup indexes",
+		up: `
+CREATE INDEX pr_commits_sha_idx ON pr_commits (sha);
+CREATE INDEX prs_number_idx ON prs (number);`,
+		// A VACUUM may renumber rowids that aren't aliased by an INTEGER
+		// PRIMARY KEY, which would leave pr_commits_fts pointing at the wrong
+		// commits, so pr_commits is rebuilt with one and reindexed on it.
+		dialectUp: map[string]string{"sqlite": `
+DROP TABLE pr_commits_fts;
+CREATE TABLE pr_commits_rekeyed (
+	id INTEGER PRIMARY KEY,
+	pr_id INTEGER REFERENCES prs,
+	sha BLOB,
+	title TEXT,
+	body TEXT,
+	message_id BLOB,
+	author_email TEXT,
+	ordering INTEGER,
+	UNIQUE (pr_id, sha)
+);
+INSERT INTO pr_commits_rekeyed (pr_id, sha, title, body, message_id, author_email, ordering)
+	SELECT pr_id, sha, title, body, message_id, author_email, ordering FROM pr_commits;
+DROP TABLE pr_commits;
+ALTER TABLE pr_commits_rekeyed RENAME TO pr_commits;
+CREATE INDEX pr_commits_sha_idx ON pr_commits (sha);
+CREATE INDEX prs_number_idx ON prs (number);
+
+CREATE VIRTUAL TABLE pr_commits_fts USING fts4 (content="pr_commits", title, body);
+CREATE TRIGGER pr_commits_fts_bu BEFORE UPDATE ON pr_commits BEGIN
+	DELETE FROM pr_commits_fts WHERE docid = old.id;
+END;
+CREATE TRIGGER pr_commits_fts_bd BEFORE DELETE ON pr_commits BEGIN
+	DELETE FROM pr_commits_fts WHERE docid = old.id;
+END;
+CREATE TRIGGER pr_commits_fts_au AFTER UPDATE ON pr_commits BEGIN
+	INSERT INTO pr_commits_fts (docid, title, body) VALUES (new.id, new.title, new.body);
+END;
+CREATE TRIGGER pr_commits_fts_ai AFTER INSERT ON pr_commits BEGIN
+	INSERT INTO pr_commits_fts (docid, title, body) VALUES (new.id, new.title, new.body);
+END;
+INSERT INTO pr_commits_fts (pr_commits_fts) VALUES ('rebuild');`},
+	},
+	{
+		version: 14,
+		name:    "forward-port cache",
+		up: `
+CREATE TABLE cherry_results (
+	repo_id int NOT NULL REFERENCES repos,
+	branch string NOT NULL,
+	branch_tip bytes NOT NULL,
+	master_tip bytes NOT NULL,
+	unmatched string NOT NULL,
+	PRIMARY KEY (repo_id, branch)
+);`,
+	},
+}
+
+// requireCockroachVersion returns a migration requirement that fails on
+// CockroachDB clusters older than major.minor. Other databases pass.
+func requireCockroachVersion(major, minor int) func(db *database) error {
+	return func(db *database) error {
+		if _, ok := db.dialect.(cockroachDialect); !ok {
+			return nil
+		}
+		m := regexp.MustCompile(`v(\d+)\.(\d+)`).FindStringSubmatch(db.version)
+		if m == nil {
+			return fmt.Errorf("requires CockroachDB %d.%d or later, but the cluster's version %q can't be parsed",
+				major, minor, db.version)
+		}
+		actualMajor, _ := strconv.Atoi(m[1])
+		actualMinor, _ := strconv.Atoi(m[2])
+		if actualMajor < major || (actualMajor == major && actualMinor < minor) {
+			return fmt.Errorf("requires CockroachDB %d.%d or later, but the cluster runs v%s.%s; "+
+				"upgrade the cluster and rerun", major, minor, m[1], m[2])
+		}
+		return nil
+	}
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// schemaVersion returns the version of the latest migration applied to db,
+// or zero if none have been applied.
+func schemaVersion(ctx context.Context, db *database) (int, error) {
+	if _, err := db.ExecContext(ctx, db.dialect.translate(
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version int PRIMARY KEY,
+			name string,
+			applied_at timestamptz
+		)`,
+	)); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx,
+		`SELECT max(version) FROM schema_migrations`,
+	).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// migrate applies every migration that has not yet been applied to db. It
+// refuses to touch a database whose schema is newer than this binary knows
+// about, as the binary may not correctly interpret the data within.
+func migrate(ctx context.Context, db *database) error {
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if latest := latestSchemaVersion(); version > latest {
+		return fmt.Errorf("database schema version %d is newer than the latest version "+
+			"known to this binary (%d); upgrade backboard", version, latest)
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if m.requires != nil {
+			if err := m.requires(db); err != nil {
+				return fmt.Errorf("migration %d (%s) %s", m.version, m.name, err)
+			}
+		}
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("applying migration %d (%s): %s", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *database, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, m.upFor(db.dialect)); err != nil {
+		return err
+	}
+	// If another process applied the migration concurrently, the primary key
+	// on version causes this insert, and thus the migration, to fail.
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+		m.version, m.name, time.Now(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// printMigrationStatus writes a table of every migration known to the binary
+// or recorded in db, and whether and when it was applied.
+func printMigrationStatus(ctx context.Context, db *database, w io.Writer) error {
+	if _, err := schemaVersion(ctx, db); err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(ctx,
+		`SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	names := map[int]string{}
+	for rows.Next() {
+		var version int
+		var name string
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &name, &appliedAt); err != nil {
+			return err
+		}
+		applied[version] = appliedAt
+		names[version] = name
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		names[m.version] = m.name
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+	for version := 1; version <= len(names); version++ {
+		status := "pending"
+		if t, ok := applied[version]; ok {
+			status = "applied " + t.Format(time.RFC3339)
+		}
+		if version > latestSchemaVersion() {
+			status += " (unknown to this binary)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", version, names[version], status)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestRequireCockroachVersion(t *testing.T) {
+	requires := requireCockroachVersion(23, 1)
+	for _, tc := range []struct {
+		db *database
+		ok bool
+	}{
+		{&database{dialect: sqliteDialect{}}, true},
+		{&database{dialect: postgresDialect{}, version: "PostgreSQL 9.6.1"}, true},
+		{&database{dialect: cockroachDialect{}, version: "CockroachDB CCL v23.1.4 (x86_64-pc-linux-gnu)"}, true},
+		{&database{dialect: cockroachDialect{}, version: "CockroachDB CCL v24.2.0 (x86_64-pc-linux-gnu)"}, true},
+		{&database{dialect: cockroachDialect{}, version: "CockroachDB CCL v22.2.9 (x86_64-pc-linux-gnu)"}, false},
+		{&database{dialect: cockroachDialect{}, version: "CockroachDB"}, false},
+	} {
+		if err := requires(tc.db); (err == nil) != tc.ok {
+			t.Errorf("%s %q: expected ok=%t, got %v", tc.db.dialect, tc.db.version, tc.ok, err)
+		}
+	}
+}
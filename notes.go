@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var notesTemplate = template.Must(template.New("notes.html").Parse(`<!doctype html>
+<html>
+<head>
+    <style>
+        body {
+            font-family: helvetica, sans-serif;
+            font-size: 14px;
+        }
+
+        h1 {
+            margin: 0 0 10px;
+        }
+
+        h1 a {
+            color: inherit;
+            text-decoration: none;
+        }
+
+        .header {
+            margin: 0 auto;
+            text-align: center;
+        }
+
+        .content {
+            margin: 0 auto;
+            max-width: 60em;
+        }
+    </style>
+    <title>release notes · {{.Notes.Branch}} · backboard</title>
+</head>
+<body>
+<div class="header">
+    <h1><a href="/">backboard</a></h1>
+    <h2>Release notes for {{.Notes.Repo}} {{.Notes.Branch}}</h2>
+    <form>
+        <input type="hidden" name="repo" value="{{.Notes.Repo.ID}}">
+        <input type="hidden" name="branch" value="{{.Notes.Branch}}">
+        <label>from <input type="text" name="from" value="{{.Notes.From}}"></label>
+        <label>to <input type="text" name="to" value="{{.Notes.To}}"></label>
+        <input type="submit" value="go">
+    </form>
+    <p><a href="{{.MarkdownURL}}">markdown</a> · <a href="{{.JSONURL}}">json</a></p>
+</div>
+<div class="content">
+    {{range .Notes.Categories}}
+        <h3>{{.Title}}</h3>
+        <ul>
+        {{range .Notes}}
+            <li>{{.Text}} {{range .PRs}}<a href="{{.URL}}">{{.}}</a> {{end}}</li>
+        {{end}}
+        </ul>
+    {{else}}
+        <p>No release notes.</p>
+    {{end}}
+</div>
+</body>
+</html>`))
+
+// releaseNoteRegexp matches a paragraph returned by releaseNotes, capturing
+// the note's category, if any, and its text.
+var releaseNoteRegexp = regexp.MustCompile(`(?is)^release note\s*(?:\(([^)]*)\))?\s*:\s*(.*)$`)
+
+// parseReleaseNote splits a release note paragraph into its category and
+// text, with whitespace collapsed. ok is false if the paragraph is not a
+// release note or says that there is none to make.
+func parseReleaseNote(para string) (category, text string, ok bool) {
+	m := releaseNoteRegexp.FindStringSubmatch(para)
+	if m == nil {
+		return "", "", false
+	}
+	category = strings.ToLower(strings.Join(strings.Fields(m[1]), " "))
+	text = strings.Join(strings.Fields(m[2]), " ")
+	if category == "none" || text == "" || strings.EqualFold(strings.TrimRight(text, "."), "none") {
+		return "", "", false
+	}
+	return category, text, true
+}
+
+// releaseNote is a note drawn from the changes that landed on a branch.
+type releaseNote struct {
+	Category string
+	Text     string
+	// PRs are the PRs that made the change: the master PR, for a backport,
+	// if it is known.
+	PRs []*pr
+	// Commits are the commits on the branch that carry the note.
+	Commits []commit
+}
+
+type releaseNoteCategory struct {
+	// Name is the category as written in the notes, lowercased. Notes
+	// without a category have an empty name.
+	Name  string
+	Notes []releaseNote
+}
+
+func (c releaseNoteCategory) Title() string {
+	if c.Name == "" {
+		return "Other"
+	}
+	return strings.ToUpper(c.Name[:1]) + c.Name[1:]
+}
+
+// releaseNotesDraft is a draft of the release notes for the changes that
+// landed on a branch between two refs.
+type releaseNotesDraft struct {
+	Repo       repo
+	Branch     string
+	From, To   string
+	Categories []releaseNoteCategory
+}
+
+// resolveRef returns the commit that ref names in re's mirror.
+func resolveRef(re repo, ref string) (sha, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, errBadRequest{fmt.Errorf("invalid ref %q", ref)}
+	}
+	out, err := capture("git", "-C", re.path(), "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return nil, errBadRequest{fmt.Errorf("unknown ref %q", ref)}
+	}
+	return parseSHA(out)
+}
+
+// draftReleaseNotes collects the release notes of the commits that are
+// reachable from to but not from from, which default to branch and its latest
+// tag, or the point at which it was cut if it has no tags. Notes are drawn
+// from the bodies of the commits and their PRs. A backport is deduplicated
+// against its master original by message ID, and is credited to the
+// original's PR.
+func draftReleaseNotes(ctx context.Context, st store, re repo, branch, from, to string) (*releaseNotesDraft, error) {
+	if from == "" {
+		from = re.latestTag(branch)
+		if from == "" {
+			from = re.branchMergeBases[branch].String()
+		}
+	}
+	if to == "" {
+		to = branch
+	}
+	fromSHA, err := resolveRef(re, from)
+	if err != nil {
+		return nil, err
+	}
+	toSHA, err := resolveRef(re, to)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := loadCommits(re, toSHA.String(), "^"+fromSHA.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// The bodies of each change, oldest first, along with the PRs whose
+	// bodies also describe it.
+	type change struct {
+		commit   commit
+		bodies   []string
+		credited *pr
+		prs      []int
+	}
+	var changes []change
+	seen := map[string]bool{}
+	var numbers []int
+	for i := len(cs.commits) - 1; i >= 0; i-- {
+		c := cs.commits[i]
+		if c.merge || seen[c.MessageID()] {
+			continue
+		}
+		seen[c.MessageID()] = true
+		ch := change{commit: c, bodies: []string{c.body}}
+		if original, ok := re.masterCommits.findMessageID(c.MessageID()); ok {
+			if string(original.sha) != string(c.sha) {
+				ch.bodies = append(ch.bodies, original.body)
+			}
+			ch.credited = re.masterPRs[string(original.sha)]
+		}
+		if ch.credited != nil {
+			ch.prs = append(ch.prs, ch.credited.number)
+		}
+		if p := re.branchPRs[c.MessageID()][branch]; p != nil && (ch.credited == nil || p.number != ch.credited.number) {
+			ch.prs = append(ch.prs, p.number)
+			if ch.credited == nil {
+				ch.credited = p
+			}
+		}
+		numbers = append(numbers, ch.prs...)
+		changes = append(changes, ch)
+	}
+	prBodies, err := st.prBodies(ctx, re.id, numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &releaseNotesDraft{Repo: re, Branch: branch, From: from, To: to}
+	categories := map[string]int{}
+	notes := map[[2]string]*releaseNote{}
+	var order [][2]string
+	for _, ch := range changes {
+		bodies := ch.bodies
+		for _, n := range ch.prs {
+			bodies = append(bodies, prBodies[n])
+		}
+		for _, body := range bodies {
+			for _, para := range releaseNotes(body) {
+				category, text, ok := parseReleaseNote(para)
+				if !ok {
+					continue
+				}
+				key := [2]string{category, text}
+				n, ok := notes[key]
+				if !ok {
+					n = &releaseNote{Category: category, Text: text}
+					notes[key] = n
+					order = append(order, key)
+				}
+				if ch.credited != nil && !containsPR(n.PRs, ch.credited) {
+					n.PRs = append(n.PRs, ch.credited)
+				}
+				if !containsCommit(n.Commits, ch.commit) {
+					n.Commits = append(n.Commits, ch.commit)
+				}
+			}
+		}
+	}
+	for _, key := range order {
+		i, ok := categories[key[0]]
+		if !ok {
+			i = len(d.Categories)
+			categories[key[0]] = i
+			d.Categories = append(d.Categories, releaseNoteCategory{Name: key[0]})
+		}
+		d.Categories[i].Notes = append(d.Categories[i].Notes, *notes[key])
+	}
+	sort.SliceStable(d.Categories, func(i, j int) bool {
+		a, b := d.Categories[i].Name, d.Categories[j].Name
+		if a == "" || b == "" {
+			return b == "" && a != ""
+		}
+		return a < b
+	})
+	return d, nil
+}
+
+func containsPR(prs []*pr, p *pr) bool {
+	for _, p0 := range prs {
+		if p0.number == p.number {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseNoteRow is the JSON representation of a release note.
+type releaseNoteRow struct {
+	Category string   `json:"category"`
+	Text     string   `json:"text"`
+	PRs      []int    `json:"prs"`
+	Commits  []string `json:"commits"`
+}
+
+// writeReleaseNotes writes d to w in the given format.
+func writeReleaseNotes(w io.Writer, d *releaseNotesDraft, format string) error {
+	switch format {
+	case "json":
+		out := struct {
+			Repo   string           `json:"repo"`
+			Branch string           `json:"branch"`
+			From   string           `json:"from"`
+			To     string           `json:"to"`
+			Notes  []releaseNoteRow `json:"notes"`
+		}{
+			Repo:   d.Repo.String(),
+			Branch: d.Branch,
+			From:   d.From,
+			To:     d.To,
+			Notes:  []releaseNoteRow{},
+		}
+		for _, c := range d.Categories {
+			for _, n := range c.Notes {
+				row := releaseNoteRow{Category: n.Category, Text: n.Text, PRs: []int{}}
+				for _, p := range n.PRs {
+					row.PRs = append(row.PRs, p.number)
+				}
+				for _, c := range n.Commits {
+					row.Commits = append(row.Commits, c.sha.String())
+				}
+				out.Notes = append(out.Notes, row)
+			}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case "markdown":
+		fmt.Fprintf(w, "# Release notes for %s %s\n\nChanges from %s to %s.\n", d.Repo, d.Branch, d.From, d.To)
+		if len(d.Categories) == 0 {
+			fmt.Fprintf(w, "\nNo release notes.\n")
+		}
+		for _, c := range d.Categories {
+			fmt.Fprintf(w, "\n## %s\n\n", c.Title())
+			for _, n := range c.Notes {
+				fmt.Fprintf(w, "- %s", n.Text)
+				for _, p := range n.PRs {
+					fmt.Fprintf(w, " %s", p)
+				}
+				fmt.Fprintf(w, "\n")
+			}
+		}
+		return nil
+	default:
+		return errors.New("unknown format " + format)
+	}
+}
+
+func setupReleaseNotes(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	cloneDirFlag(fs)
+	repoName := fs.String("repo", "", "`owner/name` of the repo (optional if only one repo is tracked)")
+	branchName := fs.String("branch", "", "release `branch` (default the newest)")
+	from := fs.String("from", "", "`ref` after which changes are included (default the branch's latest tag)")
+	to := fs.String("to", "", "`ref` up to which changes are included (default the branch)")
+	format := fs.String("format", "markdown", "output `format`: markdown or json")
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("release-notes", args, 0, 0); err != nil {
+			return err
+		}
+		if *format != "markdown" && *format != "json" {
+			return usageError{cmd: "release-notes", msg: fmt.Sprintf("unknown format %q", *format)}
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		if err := loadRepos(ctx, st, *repoName); err != nil {
+			return err
+		}
+		if len(repos) > 1 {
+			return usageError{cmd: "release-notes", msg: "several repos are tracked; choose one with --repo"}
+		}
+		if err := bootstrap(ctx, st); err != nil {
+			return fmt.Errorf("while bootstrapping: %s", err)
+		}
+		re := repos[0]
+		branch, err := findBranch(re, *branchName)
+		if err != nil {
+			return err
+		}
+		d, err := draftReleaseNotes(ctx, st, re, branch, *from, *to)
+		if err != nil {
+			return err
+		}
+		return writeReleaseNotes(os.Stdout, d, *format)
+	}
+}
+
+// serveReleaseNotes renders the draft release notes for a branch, as a page
+// or, if the "format" parameter asks for it, as Markdown or JSON.
+func (s *server) serveReleaseNotes(w http.ResponseWriter, r *http.Request) error {
+	repoLock.RLock()
+	defer repoLock.RUnlock()
+
+	q := r.URL.Query()
+	re, err := findRepo(q.Get("repo"))
+	if err != nil {
+		return err
+	}
+	branch, err := findBranch(re, q.Get("branch"))
+	if err != nil {
+		return err
+	}
+	d, err := draftReleaseNotes(r.Context(), s.store, re, branch, q.Get("from"), q.Get("to"))
+	if err != nil {
+		return err
+	}
+	switch q.Get("format") {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		return writeReleaseNotes(w, d, "json")
+	case "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		return writeReleaseNotes(w, d, "markdown")
+	}
+	formatURL := func(format string) string {
+		return releaseNotesURL(re, branch, url.Values{"from": {d.From}, "to": {d.To}, "format": {format}})
+	}
+	return notesTemplate.Execute(w, struct {
+		Notes       *releaseNotesDraft
+		MarkdownURL string
+		JSONURL     string
+	}{
+		Notes:       d,
+		MarkdownURL: formatURL("markdown"),
+		JSONURL:     formatURL("json"),
+	})
+}
+
+// releaseNotesURL returns the URL of the release notes page for branch, with
+// the additional parameters in q.
+func releaseNotesURL(re repo, branch string, q url.Values) string {
+	if q == nil {
+		q = url.Values{}
+	}
+	q.Set("repo", strconv.FormatInt(re.id, 10))
+	q.Set("branch", branch)
+	return "/release-notes?" + q.Encode()
+}
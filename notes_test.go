@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestDraftReleaseNotes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		setupBackports(e)
+		for number, body := range map[int]string{
+			1: "Fixes a.\n\nRelease note (performance improvement): a is\nfaster.",
+			2: "Release note: None",
+			3: "Release note (bug fix): c no longer breaks.",
+			4: "Backport 1/1 commits from #1.\n\nRelease note (performance improvement): a is faster.",
+		} {
+			body := body
+			e.gh.updatePR(number, func(pr *github.PullRequest) { pr.Body = github.String(body) })
+		}
+		e.sync()
+
+		u := e.upstream
+		u.git("", "tag", "v1.0.1", "release-1.0")
+		var c sha
+		for _, ac := range e.board(boardOptions{branch: "release-1.0"}).Commits {
+			if ac.Title() == "c" {
+				c = ac.SHA()
+			}
+		}
+		pr, _ := u.openPR("alice@example.com", "release-1.0", "release-1.0: fix c",
+			testCommit{cherryPick: c.String()})
+		e.gh.updatePR(pr.GetNumber(), func(pr *github.PullRequest) {
+			pr.Body = github.String("Release note (Bug Fix): c no longer\nbreaks.\n\nRelease note: c is backported.")
+		})
+		u.merge(pr)
+		e.sync()
+		re := e.repo()
+
+		draft := func(from string) []string {
+			t.Helper()
+			d, err := draftReleaseNotes(e.ctx, st, re, "release-1.0", from, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out []string
+			for _, c := range d.Categories {
+				for _, n := range c.Notes {
+					out = append(out, fmt.Sprintf("%s: %s %s", c.Title(), n.Text, n.PRs))
+				}
+			}
+			return out
+		}
+		// The backport of c is credited to its master PR, and its note is
+		// deduplicated against the one on the master PR.
+		if actual, expected := draft(""), []string{
+			"Bug fix: c no longer breaks. [#3]",
+			"Other: c is backported. [#3]",
+		}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("since the last tag: expected %q, got %q", expected, actual)
+		}
+		if actual, expected := draft(re.branchMergeBases["release-1.0"].String()), []string{
+			"Bug fix: c no longer breaks. [#3]",
+			"Performance improvement: a is faster. [#1]",
+			"Other: c is backported. [#3]",
+		}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("since the cut: expected %q, got %q", expected, actual)
+		}
+
+		s := &server{store: st}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/release-notes?repo=%d&branch=release-1.0&format=markdown", re.id), nil))
+		if expected := "## Bug fix\n\n- c no longer breaks. #3\n"; !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("expected markdown to contain %q, got:\n%s", expected, w.Body)
+		}
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/release-notes?repo=%d&branch=release-1.0&from=--help", re.id), nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for an invalid ref, got %d", w.Code)
+		}
+	})
+}
+
+func TestParseReleaseNote(t *testing.T) {
+	for _, tc := range []struct {
+		para, category, text string
+		ok                   bool
+	}{
+		{"Release note (bug fix): the thing\nis fixed.", "bug fix", "the thing is fixed.", true},
+		{"Release note: something changed.", "", "something changed.", true},
+		{"Release note: None", "", "", false},
+		{"Release note (none): n/a", "", "", false},
+		{"Release notes are hard", "", "", false},
+	} {
+		category, text, ok := parseReleaseNote(tc.para)
+		if category != tc.category || text != tc.text || ok != tc.ok {
+			t.Errorf("%q: got (%q, %q, %t)", tc.para, category, text, ok)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requestedBranches returns the release branches of re that p asks for
+// backports to, newest first. A PR asks for a backport to release-X with the
+// label "backport-X", or with a line of its body that begins with "backport"
+// and names the branch, like "Backport to release-22.2 and release-23.1."
+func requestedBranches(re repo, p prRecord) []string {
+	requested := map[string]bool{}
+	for _, l := range p.labels {
+		if strings.HasPrefix(l, "backport-") {
+			requested["release-"+strings.TrimPrefix(l, "backport-")] = true
+		}
+	}
+	for _, line := range strings.Split(p.body, "\n") {
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), "backport") {
+			continue
+		}
+		for _, word := range strings.Fields(line) {
+			requested[strings.TrimRight(word, ",.;:")] = true
+		}
+	}
+	var branches []string
+	for _, branch := range re.releaseBranches {
+		if requested[branch] {
+			branches = append(branches, branch)
+		}
+	}
+	return branches
+}
+
+// staleBackport is a requested backport of a master commit that nobody has
+// acted upon.
+type staleBackport struct {
+	Commit   commit
+	PR       *pr
+	Branch   string
+	MergedAt time.Time
+}
+
+// findStaleBackports returns the backports requested by master PRs in re
+// that merged before cutoff, and which have neither landed nor been opened
+// as a PR, nor been excluded from their branch. Callers must hold repoLock or
+// own re.
+func findStaleBackports(ctx context.Context, st store, re repo, cutoff time.Time) ([]staleBackport, error) {
+	prs, prCommits, err := backportableMasterPRs(ctx, st, re)
+	if err != nil {
+		return nil, err
+	}
+	candidates := map[string]map[string]bool{}
+	exclusions := map[string]map[string]exclusion{}
+	var stale []staleBackport
+	for _, p := range prs {
+		if p.mergedAt.After(cutoff) {
+			continue
+		}
+		for _, branch := range requestedBranches(re, p) {
+			if candidates[branch] == nil {
+				candidates[branch] = re.candidateSHAs(branch)
+				exclusions[branch], err = st.exclusions(ctx, re.id, branch)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, c := range prCommits[p.number] {
+				_, landed := re.branchCommits[branch].messageIDs[c.MessageID()]
+				_, excluded := exclusions[branch][c.MessageID()]
+				if !candidates[branch][string(c.sha)] || landed || excluded || re.branchPRs[c.MessageID()][branch] != nil {
+					continue
+				}
+				stale = append(stale, staleBackport{
+					Commit:   c,
+					PR:       re.masterPRs[string(c.sha)],
+					Branch:   branch,
+					MergedAt: *p.mergedAt,
+				})
+			}
+		}
+	}
+	return stale, nil
+}
+
+// backportableMasterPRs returns the master PRs in re that merged after its
+// oldest release branch was cut, and so may need backporting, oldest first,
+// along with their commits by PR number. Callers must hold repoLock or own
+// re.
+func backportableMasterPRs(ctx context.Context, st store, re repo) ([]prRecord, map[int][]commit, error) {
+	var since time.Time
+	for _, branch := range re.releaseBranches {
+		if c, ok := re.masterCommits.find(re.branchMergeBases[branch]); ok && (since.IsZero() || c.CommitDate.Before(since)) {
+			since = c.CommitDate
+		}
+	}
+	if since.IsZero() {
+		return nil, nil, nil
+	}
+	prs, err := st.mergedPRs(ctx, re.id, "master", since)
+	if err != nil {
+		return nil, nil, err
+	}
+	prCommits := map[int][]commit{}
+	for _, c := range re.masterCommits.commits {
+		if p := re.masterPRs[string(c.sha)]; p != nil && !c.merge {
+			prCommits[p.number] = append(prCommits[p.number], c)
+		}
+	}
+	return prs, prCommits, nil
+}
+
+// digest is a notification to the author of commits whose backports are
+// stale.
+type digest struct {
+	Repo      repo
+	Recipient string
+	Backports []staleBackport
+}
+
+func (d digest) subject() string {
+	return fmt.Sprintf("%d pending backports in %s", len(d.Backports), d.Repo)
+}
+
+func (d digest) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Backports of your commits in %s were requested, but no backport PR has been opened:\n\n", d.Repo)
+	for _, s := range d.Backports {
+		fmt.Fprintf(&b, "- %s: %s %s, from %s (merged %s) %s\n", s.Branch, s.Commit.sha.Short(), s.Commit.title,
+			s.PR, s.MergedAt.Format("2006-01-02"), s.PR.URL())
+	}
+	fmt.Fprintf(&b, "\nOpen a backport PR, or exclude the commit from the branch on the board if it won't be backported.\n")
+	return b.String()
+}
+
+// A notifier delivers digests through a channel.
+type notifier interface {
+	// channel names the channel, to record which digests it has delivered.
+	channel() string
+	notify(ctx context.Context, d digest) error
+}
+
+// smtpNotifier emails digests to their recipients.
+type smtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (n smtpNotifier) channel() string {
+	return "smtp"
+}
+
+func (n smtpNotifier) notify(ctx context.Context, d digest) error {
+	to, err := mail.ParseAddress(d.Recipient)
+	if err != nil {
+		return fmt.Errorf("recipient %q: %s", d.Recipient, err)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to.Address)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", d.subject())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(d.text(), "\n", "\r\n", -1))
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{to.Address}, msg.Bytes())
+}
+
+// slackNotifier posts digests to a Slack-compatible incoming webhook.
+type slackNotifier struct {
+	url string
+}
+
+func (n slackNotifier) channel() string {
+	return "slack"
+}
+
+func (n slackNotifier) notify(ctx context.Context, d digest) error {
+	return postJSON(ctx, n.url, struct {
+		Text string `json:"text"`
+	}{
+		Text: d.Recipient + ": " + d.text(),
+	})
+}
+
+// webhookNotifier posts digests as JSON to a URL.
+type webhookNotifier struct {
+	url string
+}
+
+func (n webhookNotifier) channel() string {
+	return "webhook"
+}
+
+// staleBackportRow is the JSON representation of a staleBackport.
+type staleBackportRow struct {
+	SHA      string    `json:"sha"`
+	Title    string    `json:"title"`
+	PR       int       `json:"pr"`
+	Branch   string    `json:"branch"`
+	MergedAt time.Time `json:"merged_at"`
+}
+
+func (n webhookNotifier) notify(ctx context.Context, d digest) error {
+	payload := struct {
+		Repo      string             `json:"repo"`
+		Recipient string             `json:"recipient"`
+		Backports []staleBackportRow `json:"backports"`
+	}{
+		Repo:      d.Repo.String(),
+		Recipient: d.Recipient,
+	}
+	for _, s := range d.Backports {
+		payload.Backports = append(payload.Backports, staleBackportRow{
+			SHA:      s.Commit.sha.String(),
+			Title:    s.Commit.title,
+			PR:       s.PR.number,
+			Branch:   s.Branch,
+			MergedAt: s.MergedAt,
+		})
+	}
+	return postJSON(ctx, n.url, payload)
+}
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// postJSON posts v, encoded as JSON, to url, and fails unless the response
+// status is 2xx.
+func postJSON(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(ctx, url, body, nil)
+}
+
+// post is like postJSON, but takes the encoded body, along with headers to
+// add to the request.
+func post(ctx context.Context, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := webhookClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", url, res.Status)
+	}
+	return nil
+}
+
+// notifiersFromEnv builds the notifiers configured by the
+// BACKBOARD_SMTP_ADDR, BACKBOARD_SMTP_FROM, BACKBOARD_SMTP_USERNAME,
+// BACKBOARD_SMTP_PASSWORD, BACKBOARD_SLACK_WEBHOOK_URL and
+// BACKBOARD_NOTIFY_WEBHOOK_URL env vars.
+func notifiersFromEnv() ([]notifier, error) {
+	var notifiers []notifier
+	if addr := os.Getenv("BACKBOARD_SMTP_ADDR"); addr != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BACKBOARD_SMTP_ADDR: %s", err)
+		}
+		n := smtpNotifier{addr: addr, from: os.Getenv("BACKBOARD_SMTP_FROM")}
+		if n.from == "" {
+			return nil, errors.New("BACKBOARD_SMTP_ADDR requires BACKBOARD_SMTP_FROM")
+		}
+		if username := os.Getenv("BACKBOARD_SMTP_USERNAME"); username != "" {
+			n.auth = smtp.PlainAuth("", username, os.Getenv("BACKBOARD_SMTP_PASSWORD"), host)
+		}
+		notifiers = append(notifiers, n)
+	}
+	if url := os.Getenv("BACKBOARD_SLACK_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, slackNotifier{url: url})
+	}
+	if url := os.Getenv("BACKBOARD_NOTIFY_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, webhookNotifier{url: url})
+	}
+	return notifiers, nil
+}
+
+// sendNotifications sends each author of commits in re whose backports have
+// been stale for staleAfter a digest through each of notifiers. A backport is
+// included in a digest only the first time it goes out through a channel.
+// Failing deliveries are retried by the next call, and the last of their
+// errors returned once every digest has been attempted.
+func sendNotifications(ctx context.Context, st store, re repo, notifiers []notifier, staleAfter time.Duration, now time.Time) error {
+	stale, err := findStaleBackports(ctx, st, re, now.Add(-staleAfter))
+	if err != nil {
+		return err
+	}
+	sent, err := st.notifications(ctx, re.id)
+	if err != nil {
+		return err
+	}
+	byRecipient := map[string][]staleBackport{}
+	var recipients []string
+	for _, s := range stale {
+		r := s.Commit.Author.Email
+		if byRecipient[r] == nil {
+			recipients = append(recipients, r)
+		}
+		byRecipient[r] = append(byRecipient[r], s)
+	}
+	sort.Strings(recipients)
+
+	var lastErr error
+	for _, n := range notifiers {
+		for _, r := range recipients {
+			d := digest{Repo: re, Recipient: r}
+			var keys []notificationKey
+			for _, s := range byRecipient[r] {
+				k := notificationKey{channel: n.channel(), recipient: r, messageID: s.Commit.MessageID(), branch: s.Branch}
+				if _, ok := sent[k]; !ok {
+					d.Backports = append(d.Backports, s)
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				continue
+			}
+			if err := n.notify(ctx, d); err != nil {
+				lastErr = fmt.Errorf("notifying %s through %s: %s", r, n.channel(), err)
+				log.Print(lastErr)
+				continue
+			}
+			if err := st.putNotifications(ctx, re.id, keys, now); err != nil {
+				return err
+			}
+		}
+	}
+	return lastErr
+}
+
+// notifyAll sends notifications about every repo.
+func notifyAll(ctx context.Context, st store, notifiers []notifier, staleAfter time.Duration) error {
+	repoLock.RLock()
+	snapshot := append([]repo(nil), repos...)
+	repoLock.RUnlock()
+	var lastErr error
+	for _, re := range snapshot {
+		if err := sendNotifications(ctx, st, re, notifiers, staleAfter, time.Now()); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func notifyLoop(ctx context.Context, st store, notifiers []notifier, staleAfter, interval time.Duration) {
+	for {
+		if err := notifyAll(ctx, st, notifiers, staleAfter); err != nil {
+			log.Printf("notify error: %s", err)
+		}
+		<-time.After(interval)
+	}
+}
+
+func (c *config) staleAfterFlag(fs *flag.FlagSet) {
+	fs.DurationVar(&c.staleAfter, "stale-after", 7*24*time.Hour,
+		envUsage("stale-after", "time after a PR merges at which to remind its authors of the backports it requested"))
+}
+
+func setupNotify(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	cloneDirFlag(fs)
+	cfg.staleAfterFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("notify", args, 0, 0); err != nil {
+			return err
+		}
+		notifiers, err := notifiersFromEnv()
+		if err != nil {
+			return err
+		} else if len(notifiers) == 0 {
+			return errors.New("no notification channels configured; set BACKBOARD_SMTP_ADDR, " +
+				"BACKBOARD_SLACK_WEBHOOK_URL or BACKBOARD_NOTIFY_WEBHOOK_URL")
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		if err := loadRepos(ctx, st, ""); err != nil {
+			return err
+		}
+		if err := bootstrap(ctx, st); err != nil {
+			return fmt.Errorf("while bootstrapping: %s", err)
+		}
+		return notifyAll(ctx, st, notifiers, cfg.staleAfter)
+	}
+}
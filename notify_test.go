@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// smtpSink is an SMTP server that accepts every message.
+type smtpSink struct {
+	net.Listener
+	mu       sync.Mutex
+	messages map[string]string // by recipient
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &smtpSink{Listener: ln, messages: map[string]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(textproto.NewConn(conn))
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) serve(c *textproto.Conn) {
+	defer c.Close()
+	c.PrintfLine("220 localhost ESMTP")
+	var rcpts []string
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "RCPT":
+			rcpts = append(rcpts, strings.Trim(strings.SplitN(line, ":", 2)[1], "<> "))
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			for _, r := range rcpts {
+				s.messages[r] = string(data)
+			}
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpSink) take() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := s.messages
+	s.messages = map[string]string{}
+	return messages
+}
+
+// httpSink is an HTTP server that records the bodies of the requests it
+// receives, failing those made while fail is set.
+type httpSink struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+	fail   bool
+}
+
+func newHTTPSink(t *testing.T) *httpSink {
+	s := &httpSink{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.bodies = append(s.bodies, string(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *httpSink) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bodies := s.bodies
+	s.bodies = nil
+	sort.Strings(bodies)
+	return bodies
+}
+
+func TestNotifications(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		setupBackports(e)
+		// #2 asks for backports by label, of which b1's is open, and #3 by
+		// its body. #1's backport has merged.
+		e.gh.updatePR(1, func(pr *github.PullRequest) {
+			pr.Labels = []*github.Label{{Name: github.String("backport-1.0")}}
+		})
+		e.gh.updatePR(2, func(pr *github.PullRequest) {
+			pr.Labels = []*github.Label{{Name: github.String("backport-1.0")}}
+		})
+		e.gh.updatePR(3, func(pr *github.PullRequest) {
+			pr.Body = github.String("Fixes c.\n\nBackport to release-1.0.")
+		})
+		e.sync()
+
+		smtpSink := newSMTPSink(t)
+		slack, webhook := newHTTPSink(t), newHTTPSink(t)
+		webhook.fail = true
+		notifiers := []notifier{
+			smtpNotifier{addr: smtpSink.Addr().String(), from: "backboard@example.com"},
+			slackNotifier{url: slack.URL},
+			webhookNotifier{url: webhook.URL},
+		}
+		re := e.repo()
+		notify := func(now time.Time) error {
+			t.Helper()
+			return sendNotifications(e.ctx, st, re, notifiers, 7*24*time.Hour, now)
+		}
+
+		// Nothing is stale until a week after the PRs merged.
+		if err := notify(e.upstream.clock); err != nil {
+			t.Fatal(err)
+		}
+		if messages := smtpSink.take(); len(messages) != 0 {
+			t.Errorf("expected no emails before the backports were stale, got %v", messages)
+		}
+
+		now := e.upstream.clock.Add(8 * 24 * time.Hour)
+		if err := notify(now); err == nil {
+			t.Error("expected the failing webhook to be reported")
+		}
+		messages := smtpSink.take()
+		var recipients []string
+		for r := range messages {
+			recipients = append(recipients, r)
+		}
+		sort.Strings(recipients)
+		if expected := []string{"alice@example.com", "bob@example.com"}; !reflect.DeepEqual(recipients, expected) {
+			t.Fatalf("expected emails to %v, got %v", expected, recipients)
+		}
+		for r, title := range map[string]string{"alice@example.com": " c, from #3", "bob@example.com": " b2, from #2"} {
+			if !strings.Contains(messages[r], title) || strings.Contains(messages[r], " b1,") {
+				t.Errorf("unexpected email to %s:\n%s", r, messages[r])
+			}
+		}
+		if bodies := slack.take(); len(bodies) != 2 || !strings.Contains(bodies[0], "alice@example.com: ") {
+			t.Errorf("unexpected Slack posts %q", bodies)
+		}
+
+		// Delivered digests are not sent again, but the failed webhook is
+		// retried.
+		webhook.fail = false
+		if err := notify(now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if messages := smtpSink.take(); len(messages) != 0 {
+			t.Errorf("expected no repeated emails, got %v", messages)
+		}
+		if bodies := slack.take(); len(bodies) != 0 {
+			t.Errorf("expected no repeated Slack posts, got %q", bodies)
+		}
+		bodies := webhook.take()
+		if len(bodies) != 2 {
+			t.Fatalf("expected 2 webhook deliveries, got %q", bodies)
+		}
+		var payload struct {
+			Recipient string
+			Backports []staleBackportRow
+		}
+		if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Recipient != "alice@example.com" || len(payload.Backports) != 1 ||
+			payload.Backports[0].Title != "c" || payload.Backports[0].Branch != "release-1.0" || payload.Backports[0].PR != 3 {
+			t.Errorf("unexpected webhook payload %+v", payload)
+		}
+	})
+}
+
+func TestRequestedBranches(t *testing.T) {
+	re := repo{releaseBranches: []string{"release-23.1", "release-22.2", "release-22.1"}}
+	p := prRecord{
+		labels: []string{"backport-22.1", "bug"},
+		body:   "Fixes #123.\n\nBackport to release-22.2, release-21.2 and release-23.1.\nNot release-22.1.",
+	}
+	if actual, expected := requestedBranches(re, p), []string{"release-23.1", "release-22.2", "release-22.1"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if actual := requestedBranches(re, prRecord{body: "Mentions release-22.2 in passing."}); len(actual) != 0 {
+		t.Errorf("expected no requested branches, got %v", actual)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// prediction is the predicted outcome of cherry-picking a master commit onto
+// the tip of a release branch.
+type prediction struct {
+	// Conflicts are the files that would conflict, if any.
+	Conflicts []string
+}
+
+func parsePrediction(encoded string) prediction {
+	if encoded == "" {
+		return prediction{}
+	}
+	return prediction{Conflicts: strings.Split(encoded, "\n")}
+}
+
+// encode returns the representation of p stored by the store.
+func (p prediction) encode() string {
+	return strings.Join(p.Conflicts, "\n")
+}
+
+func (p prediction) Clean() bool {
+	return len(p.Conflicts) == 0
+}
+
+func (p prediction) String() string {
+	if p.Clean() {
+		return "cherry-picks cleanly"
+	}
+	return "conflicts in " + strings.Join(p.Conflicts, ", ")
+}
+
+// predictConflicts predicts, for every release branch of re, the outcome of
+// cherry-picking each candidate master commit that has yet to be backported
+// onto the branch's tip. Predictions are cached by branch tip, so only
+// commits and branches that have changed since the last sync are examined,
+// and the predictions onto superseded tips are pruned.
+// A commit whose prediction fails is logged and left for the next sync to
+// retry, without holding back the predictions of the others; the number of
+// failures is returned as an error.
+func predictConflicts(ctx context.Context, st store, re repo) error {
+	var failures int
+	var tips []sha
+	for _, branch := range re.releaseBranches {
+		tip := re.branchTips[branch]
+		tips = append(tips, tip)
+		cached, err := st.predictions(ctx, re.id, tip)
+		if err != nil {
+			return err
+		}
+		for _, c := range re.masterCommits.truncate(re.branchMergeBases[branch]) {
+			if _, ok := cached[string(c.sha)]; ok {
+				continue
+			}
+			if _, ok := re.branchCommits[branch].messageIDs[c.MessageID()]; ok {
+				continue
+			}
+			if re.branchPRs[c.MessageID()][branch] != nil {
+				continue
+			}
+			p, err := predictCherryPick(re, c, tip)
+			if err != nil {
+				log.Printf("predicting %s onto %s in %s: %s", c.sha.Short(), branch, re, err)
+				failures++
+				continue
+			}
+			if err := st.putPrediction(ctx, re.id, tip, c.sha, p); err != nil {
+				return err
+			}
+		}
+	}
+	if err := st.prunePredictions(ctx, re.id, tips); err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("failed to predict %d cherry-picks", failures)
+	}
+	return nil
+}
+
+// predictCherryPick predicts the outcome of cherry-picking c onto tip. It
+// uses git merge-tree where git is new enough to merge with an arbitrary
+// merge base, and otherwise a trial cherry-pick in a scratch worktree. Merge
+// commits are picked relative to their first parent, like cherry-pick -m 1.
+func predictCherryPick(re repo, c commit, tip sha) (prediction, error) {
+	if useMergeTree() {
+		return predictWithMergeTree(re, c.sha, tip)
+	}
+	return predictWithWorktree(re, c, tip)
+}
+
+// useMergeTree reports whether to predict with git merge-tree. Tests replace
+// it to exercise both ways of predicting.
+var useMergeTree = gitSupportsMergeTreeBase
+
+var mergeTreeBase struct {
+	once      sync.Once
+	supported bool
+}
+
+var gitVersionRegexp = regexp.MustCompile(`^git version (\d+)\.(\d+)`)
+
+// gitSupportsMergeTreeBase reports whether git merge-tree supports
+// --merge-base, which arrived in git 2.40.
+func gitSupportsMergeTreeBase() bool {
+	mergeTreeBase.once.Do(func() {
+		out, err := capture("git", "version")
+		if err != nil {
+			return
+		}
+		m := gitVersionRegexp.FindStringSubmatch(out)
+		if m == nil {
+			return
+		}
+		major, _ := strconv.Atoi(m[1])
+		minor, _ := strconv.Atoi(m[2])
+		mergeTreeBase.supported = major > 2 || (major == 2 && minor >= 40)
+	})
+	return mergeTreeBase.supported
+}
+
+// predictWithMergeTree merges the changes c made to its parent into tip,
+// which is exactly what a cherry-pick does, without touching a worktree.
+func predictWithMergeTree(re repo, c, tip sha) (prediction, error) {
+	out, code, err := captureExitCode("git", "-C", re.path(), "merge-tree", "--write-tree",
+		"--name-only", "--no-messages", "--merge-base="+c.String()+"^1", tip.String(), c.String())
+	switch code {
+	case 0:
+		return prediction{}, nil
+	case 1:
+		// The first line is the merged tree, followed by each conflicted
+		// file once per conflicting stage.
+		var p prediction
+		lines := strings.Split(out, "\n")
+		for _, file := range lines[1:] {
+			if file != "" && !containsString(p.Conflicts, file) {
+				p.Conflicts = append(p.Conflicts, file)
+			}
+		}
+		return p, nil
+	default:
+		return prediction{}, err
+	}
+}
+
+// predictWithWorktree cherry-picks c onto tip in a scratch worktree next to
+// the mirror of re.
+func predictWithWorktree(re repo, c commit, tip sha) (prediction, error) {
+	// git -C would resolve a relative path against the mirror.
+	wt, err := filepath.Abs(re.path() + ".predict")
+	if err != nil {
+		return prediction{}, err
+	}
+	if _, err := os.Stat(wt); os.IsNotExist(err) {
+		// Forget any worktree that was registered but has since been
+		// deleted, so that it can be added again.
+		if _, err := capture("git", "-C", re.path(), "worktree", "prune"); err != nil {
+			return prediction{}, err
+		}
+		if _, err := capture("git", "-C", re.path(), "worktree", "add", "-q", "--detach", wt, tip.String()); err != nil {
+			return prediction{}, err
+		}
+	} else if err != nil {
+		return prediction{}, err
+	}
+	for _, args := range [][]string{
+		{"checkout", "-q", "--force", "--detach", tip.String()},
+		{"clean", "-q", "-f", "-d", "-x"},
+	} {
+		if _, err := capture(append([]string{"git", "-C", wt}, args...)...); err != nil {
+			return prediction{}, err
+		}
+	}
+	defer func() {
+		// Leave the worktree ready for the next prediction. Errors surface
+		// when the next prediction resets the worktree.
+		capture("git", "-C", wt, "cherry-pick", "--quit")
+		capture("git", "-C", wt, "reset", "-q", "--hard")
+	}()
+
+	args := []string{"git", "-C", wt, "cherry-pick", "--no-commit"}
+	if c.merge {
+		args = append(args, "-m", "1")
+	}
+	_, code, pickErr := captureExitCode(append(args, c.sha.String())...)
+	switch code {
+	case 0:
+		return prediction{}, nil
+	case 1:
+		out, err := capture("git", "-C", wt, "diff", "--name-only", "--diff-filter=U")
+		if err != nil {
+			return prediction{}, err
+		}
+		if out == "" {
+			// The cherry-pick failed for some reason other than a
+			// conflict.
+			return prediction{}, pickErr
+		}
+		return prediction{Conflicts: strings.Split(out, "\n")}, nil
+	default:
+		return prediction{}, pickErr
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPredictConflicts(t *testing.T) {
+	for _, mergeTree := range []bool{false, true} {
+		name := "worktree"
+		if mergeTree {
+			name = "merge-tree"
+		}
+		t.Run(name, func(t *testing.T) {
+			if mergeTree && !gitSupportsMergeTreeBase() {
+				t.Skip("git merge-tree --merge-base requires git 2.40")
+			}
+			old := useMergeTree
+			t.Cleanup(func() { useMergeTree = old })
+			useMergeTree = func() bool { return mergeTree }
+			testPredictConflicts(t)
+		})
+	}
+}
+
+func testPredictConflicts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		u := e.upstream
+		u.branch("release-1.0")
+		u.git("", "checkout", "-q", "release-1.0")
+		u.commit("bob@example.com", "release-only fix", "README", "widget, stable\n")
+		u.git("", "checkout", "-q", "master")
+		pr1, _ := u.openPR("alice@example.com", "master", "rewrite readme",
+			testCommit{title: "readme", file: "README", content: "widget, improved\n"})
+		u.merge(pr1)
+		pr2, _ := u.openPR("alice@example.com", "master", "add docs",
+			testCommit{title: "docs", file: "docs.txt", content: "docs\n"})
+		u.merge(pr2)
+		e.sync()
+
+		predictions := map[string]*prediction{}
+		for _, c := range e.board(boardOptions{branch: "release-1.0"}).Commits {
+			predictions[c.Title()] = c.Prediction
+		}
+		if p := predictions["readme"]; p == nil || !reflect.DeepEqual(p.Conflicts, []string{"README"}) {
+			t.Errorf("expected readme to conflict in README, got %+v", p)
+		}
+		if p := predictions["docs"]; p == nil || !p.Clean() {
+			t.Errorf("expected docs to cherry-pick cleanly, got %+v", p)
+		}
+
+		// Merge commits are picked relative to their first parent.
+		re := e.repo()
+		var merges int
+		for _, c := range re.masterCommits.commits {
+			if !c.merge {
+				continue
+			}
+			merges++
+			p, err := predictCherryPick(re, c, re.branchTips["release-1.0"])
+			if err != nil {
+				t.Fatalf("predicting %q: %s", c.title, err)
+			}
+			if expected := c.title == "Merge #1"; !p.Clean() != expected {
+				t.Errorf("%q: unexpected prediction %+v", c.title, p)
+			}
+		}
+		if merges != 2 {
+			t.Errorf("expected to predict 2 merge commits, predicted %d", merges)
+		}
+
+		// Predictions are cached by branch tip, so a sync that changes
+		// nothing predicts nothing.
+		tip := e.repo().branchTips["release-1.0"]
+		if err := st.putPrediction(e.ctx, e.repo().id, tip, e.board(boardOptions{branch: "release-1.0"}).Commits[0].SHA(),
+			prediction{Conflicts: []string{"sentinel"}}); err != nil {
+			t.Fatal(err)
+		}
+		e.sync()
+		if p := e.board(boardOptions{branch: "release-1.0"}).Commits[0].Prediction; p == nil || p.Conflicts[0] != "sentinel" {
+			t.Errorf("expected cached prediction to be reused, got %+v", p)
+		}
+
+		// Predictions onto superseded tips are pruned.
+		stale := sha(strings.Repeat("\x01", 20))
+		if err := st.putPrediction(e.ctx, e.repo().id, stale, tip, prediction{}); err != nil {
+			t.Fatal(err)
+		}
+		e.sync()
+		if cached, err := st.predictions(e.ctx, e.repo().id, stale); err != nil {
+			t.Fatal(err)
+		} else if len(cached) != 0 {
+			t.Errorf("expected predictions onto a stale tip to be pruned, got %+v", cached)
+		}
+		if cached, err := st.predictions(e.ctx, e.repo().id, tip); err != nil {
+			t.Fatal(err)
+		} else if len(cached) == 0 {
+			t.Error("expected predictions onto the current tip to be kept")
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// role determines what a user may do on the board. Roles are ordered; each
+// role may do everything the roles below it may do.
+type role int
+
+const (
+	roleViewer role = iota
+	roleContributor
+	roleReleaseManager
+)
+
+var roleNames = []string{
+	roleViewer:         "viewer",
+	roleContributor:    "contributor",
+	roleReleaseManager: "release-manager",
+}
+
+func (r role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(s string) (role, error) {
+	for r, name := range roleNames {
+		if s == name {
+			return role(r), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q", s)
+}
+
+// action is something a user does to the board that requires a minimum role.
+type action int
+
+const (
+	actionComment action = iota
+	actionExclude
+	actionExecuteBackport
+	actionManageRoles
+)
+
+func (a action) minRole() role {
+	switch a {
+	case actionComment:
+		return roleContributor
+	default:
+		return roleReleaseManager
+	}
+}
+
+// defaultSignedInRole is the role of a signed-in user who has not been
+// assigned a role for a repo or branch.
+const defaultSignedInRole = roleContributor
+
+// admins are the logins, from the comma-separated BACKBOARD_ADMINS env var,
+// who are release managers of every repo and branch. They are needed to
+// grant the first roles.
+var admins = func() map[string]bool {
+	m := map[string]bool{}
+	for _, login := range strings.Split(os.Getenv("BACKBOARD_ADMINS"), ",") {
+		if login = strings.TrimSpace(login); login != "" {
+			m[login] = true
+		}
+	}
+	return m
+}()
+
+// loadRole determines id's role on the given branch of the repo with ID
+// repoID. Pass an empty branch to determine the repo-wide role.
+func loadRole(ctx context.Context, st store, repoID int64, branch string, id *identity) (role, error) {
+	if id == nil {
+		return roleViewer, nil
+	}
+	if admins[id.Login] {
+		return roleReleaseManager, nil
+	}
+	roles, err := st.roles(ctx, repoID, id.Login)
+	if err != nil {
+		return 0, err
+	}
+	if r, ok := resolveRole(roles, branch); ok {
+		return r, nil
+	}
+	return defaultSignedInRole, nil
+}
+
+// authorize returns an error unless id may perform a on the given branch of
+// the repo with ID repoID.
+func authorize(ctx context.Context, st store, repoID int64, branch string, id *identity, a action) error {
+	if a == actionManageRoles {
+		// Roles are managed by the repo's release managers, not a branch's.
+		branch = ""
+	}
+	r, err := loadRole(ctx, st, repoID, branch, id)
+	if err != nil {
+		return err
+	}
+	if r < a.minRole() {
+		return errForbidden{id: id, role: r}
+	}
+	return nil
+}
+
+// errForbidden is returned when a user lacks the role to perform an action.
+type errForbidden struct {
+	id   *identity
+	role role
+}
+
+func (e errForbidden) Error() string {
+	return fmt.Sprintf("%s (%s) is not permitted to do that", e.id, e.role)
+}
+
+// roleGrant is a role assigned to a user on a repo or, if Branch is set, on
+// one of its release branches.
+type roleGrant struct {
+	Username string
+	Branch   string
+	Role     role
+}
+
+var rolesTemplate = template.Must(template.New("roles.html").Parse(`<!doctype html>
+<html>
+<head>
+    <style>
+        body {
+            font-family: helvetica, sans-serif;
+            font-size: 14px;
+        }
+
+        h1 {
+            margin: 0 0 10px;
+        }
+
+        h1 a {
+            color: inherit;
+            text-decoration: none;
+        }
+
+        .header {
+            margin: 0 auto;
+            text-align: center;
+        }
+
+        #role-table {
+            border-collapse: collapse;
+            margin: 1em auto 0;
+        }
+
+        #role-table td {
+            border-top: 1px solid #bbb;
+            padding: 0.3em 0.6em;
+        }
+
+        form {
+            margin: 1em auto 0;
+            text-align: center;
+        }
+    </style>
+    <title>Roles · {{.Repo}} · backboard</title>
+</head>
+<body>
+<div class="header">
+    <h1><a href="/?repo={{.Repo.ID}}">backboard</a></h1>
+    <h2>Roles on {{.Repo}}</h2>
+    <p>Signed-in users without a role are {{.DefaultRole}}s. Admins are release-managers everywhere.</p>
+</div>
+<table id="role-table">
+    <thead>
+    <tr>
+        <th>User</th>
+        <th>Branch</th>
+        <th>Role</th>
+    </tr>
+    </thead>
+    <tbody>
+    {{range .Grants}}
+        <tr>
+            <td>{{.Username}}</td>
+            <td>{{if .Branch}}{{.Branch}}{{else}}all branches{{end}}</td>
+            <td>{{.Role}}</td>
+        </tr>
+    {{else}}
+        <tr><td colspan="3">No roles have been granted.</td></tr>
+    {{end}}
+    </tbody>
+</table>
+{{if .CanManage}}
+    <form method="post" action="/roles">
+        <input type="hidden" name="repo" value="{{.Repo.ID}}">
+        <input type="hidden" name="next" value="/roles?repo={{.Repo.ID}}">
+        <input type="text" name="username" placeholder="GitHub login" required>
+        <select name="branch">
+            <option value="">all branches</option>
+            {{range .Branches}}<option>{{.}}</option>{{end}}
+        </select>
+        <select name="role">
+            {{range .Roles}}<option>{{.}}</option>{{end}}
+        </select>
+        <input type="submit" value="grant">
+    </form>
+{{end}}
+</body>
+</html>`))
+
+// serveRoles lists the roles granted on a repo, offering its release managers
+// a form to grant more. Grants are POSTed to serveGrantRole.
+func (s *server) serveRoles(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		return s.serveGrantRole(w, r)
+	}
+	repoLock.RLock()
+	re, err := findRepo(r.URL.Query().Get("repo"))
+	repoLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	ctx := r.Context()
+	grants, err := s.store.roleGrants(ctx, re.id)
+	if err != nil {
+		return err
+	}
+	userRole, err := loadRole(ctx, s.store, re.id, "", identityFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	var roles []role
+	for ro := range roleNames {
+		roles = append(roles, role(ro))
+	}
+	return rolesTemplate.Execute(w, struct {
+		Repo        repo
+		Branches    []string
+		Grants      []roleGrant
+		Roles       []role
+		DefaultRole role
+		CanManage   bool
+	}{
+		Repo:        re,
+		Branches:    re.releaseBranches,
+		Grants:      grants,
+		Roles:       roles,
+		DefaultRole: defaultSignedInRole,
+		CanManage:   userRole >= actionManageRoles.minRole(),
+	})
+}
+
+func setupRolesGrant(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	cloneDirFlag(fs)
+	branch := fs.String("branch", "", "grant the role on only this release `branch`, rather than the whole repo")
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("roles grant", args, 3, 3); err != nil {
+			return err
+		}
+		owner, name, err := parseRepoName("roles grant", args[0])
+		if err != nil {
+			return err
+		}
+		username := args[1]
+		ro, err := parseRole(args[2])
+		if err != nil {
+			return usageError{cmd: "roles grant", msg: fmt.Sprintf("%s; want one of %s", err, strings.Join(roleNames, ", "))}
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		repoID, err := trackedRepoID(ctx, st, owner, name)
+		if err != nil {
+			return err
+		}
+		if *branch != "" {
+			// A grant on a branch that isn't a release branch would never
+			// apply.
+			if err := loadRepos(ctx, st, owner+"/"+name); err != nil {
+				return err
+			}
+			if err := bootstrap(ctx, st); err != nil {
+				return fmt.Errorf("while bootstrapping: %s", err)
+			}
+			if _, err := findBranch(repos[0], *branch); err != nil {
+				return usageError{cmd: "roles grant", msg: err.Error()}
+			}
+		}
+		return st.grantRole(ctx, repoID, *branch, username, ro, event{
+			RepoID: repoID,
+			Branch: *branch,
+			Kind:   eventRoleGranted,
+			Detail: fmt.Sprintf("%s is now %s", username, ro),
+		})
+	}
+}
+
+func setupRolesList(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("roles list", args, 1, 1); err != nil {
+			return err
+		}
+		owner, name, err := parseRepoName("roles list", args[0])
+		if err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		repoID, err := trackedRepoID(ctx, st, owner, name)
+		if err != nil {
+			return err
+		}
+		grants, err := st.roleGrants(ctx, repoID)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "USER\tBRANCH\tROLE\n")
+		for _, g := range grants {
+			branch := g.Branch
+			if branch == "" {
+				branch = "*"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", g.Username, branch, g.Role)
+		}
+		return tw.Flush()
+	}
+}
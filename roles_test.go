@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRolesCommands(t *testing.T) {
+	ctx := context.Background()
+	db := sqlitePrefix + filepath.Join(tempDir(t), "backboard.db")
+	// Branch grants are checked against a mirror of the repo.
+	oldRepos, oldCloneDir := repos, cloneDir
+	t.Cleanup(func() { repos, cloneDir = oldRepos, oldCloneDir })
+	clones := tempDir(t)
+	u := newTestUpstream(t, newFakeGitHub(t))
+	u.branch("release-1.0")
+	u.git("", "clone", "-q", "--mirror", u.dir, filepath.Join(clones, "acme", "widget"))
+	for _, args := range [][]string{
+		{"repos", "add", "--db", db, "acme/widget"},
+		{"roles", "grant", "--db", db, "acme/widget", "carol", "release-manager"},
+		{"roles", "grant", "--db", db, "--clone-dir", clones, "--branch", "release-1.0", "acme/widget", "bob", "viewer"},
+	} {
+		if err := runCommand(ctx, args); err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"roles", "grant", "--db", db, "acme/widget", "carol", "overlord"},
+		{"roles", "grant", "--db", db, "acme/gadget", "carol", "viewer"},
+	} {
+		if err := runCommand(ctx, args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+	if err := runCommand(ctx, []string{"roles", "grant", "--db", db, "--clone-dir", clones,
+		"--branch", "release-2.1", "acme/widget", "bob", "viewer"}); err == nil || !strings.Contains(err.Error(), "not a release branch") {
+		t.Errorf("expected a grant on an unknown branch to be rejected, got %v", err)
+	}
+
+	st, err := openStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.(*sqlStore).db.Close()
+	repoID, err := trackedRepoID(ctx, st, "acme", "widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err := st.roleGrants(ctx, repoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []roleGrant{
+		{Username: "bob", Branch: "release-1.0", Role: roleViewer},
+		{Username: "carol", Role: roleReleaseManager},
+	}
+	if !reflect.DeepEqual(grants, expected) {
+		t.Errorf("expected grants %+v, got %+v", expected, grants)
+	}
+}
+
+func TestRolesPage(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		setupBackports(e)
+		e.sync()
+		admins["carol"] = true
+		t.Cleanup(func() { delete(admins, "carol") })
+		s := &server{store: st, auth: authConfig{trustedUserHeader: "X-User"}}
+		do := func(method, login string, form url.Values) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/roles?repo="+url.QueryEscape(form.Get("repo")), strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-User", login)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			return w
+		}
+		repoParam := strconv.FormatInt(e.repo().id, 10)
+		grant := url.Values{"repo": {repoParam}, "username": {"bob"}, "branch": {"release-1.0"}, "role": {"release-manager"}}
+
+		if w := do("POST", "bob", grant); w.Code != http.StatusForbidden {
+			t.Errorf("expected bob to be forbidden from granting roles, got %d", w.Code)
+		}
+		if w := do("GET", "bob", url.Values{"repo": {repoParam}}); w.Code != http.StatusOK ||
+			strings.Contains(w.Body.String(), `action="/roles"`) {
+			t.Errorf("expected bob to see the roles without a grant form (%d):\n%s", w.Code, w.Body)
+		}
+		if w := do("POST", "carol", grant); w.Code != http.StatusSeeOther {
+			t.Fatalf("expected carol's grant to succeed, got %d: %s", w.Code, w.Body)
+		}
+		w := do("GET", "carol", url.Values{"repo": {repoParam}})
+		if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, `action="/roles"`) ||
+			!strings.Contains(body, "<td>bob</td>") || !strings.Contains(body, "release-manager") {
+			t.Errorf("expected carol to see bob's role and the grant form (%d):\n%s", w.Code, body)
+		}
+	})
+}
+
+func TestCommentsScopedByRepo(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		ctx := context.Background()
+		var ids []int64
+		for _, name := range []string{"widget", "gadget"} {
+			if err := st.putRepo(ctx, repoRecord{owner: "acme", name: name, credentials: "none"}); err != nil {
+				t.Fatal(err)
+			}
+			id, err := trackedRepoID(ctx, st, "acme", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		c := comment{CreatedAt: time.Now(), UserEmail: "carol@example.com", Body: "needs a test"}
+		if err := st.addComment(ctx, ids[0], "fix the thing", c,
+			event{RepoID: ids[0], Kind: eventCommented, MessageID: "fix the thing", Detail: c.Body}); err != nil {
+			t.Fatal(err)
+		}
+		for i, expected := range []int{1, 0} {
+			comments, err := st.comments(ctx, ids[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := len(comments["fix the thing"]); actual != expected {
+				t.Errorf("repo %d: expected %d comments, got %d", ids[i], expected, actual)
+			}
+		}
+	})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var searchTemplate = template.Must(template.New("search.html").Parse(`<!doctype html>
+<html>
+<head>
+    <style>
+        body {
+            font-family: helvetica, sans-serif;
+            font-size: 14px;
+        }
+
+        h1 {
+            margin: 0 0 10px;
+        }
+
+        h1 a {
+            color: inherit;
+            text-decoration: none;
+        }
+
+        .header {
+            margin: 0 auto;
+            text-align: center;
+        }
+
+        #result-table {
+            border-collapse: collapse;
+            margin: 1em auto 0;
+        }
+
+        #result-table td {
+            border-top: 1px solid #bbb;
+            padding: 0.3em 0.3em;
+        }
+
+        .sha {
+            font-family: monospace;
+        }
+
+        .pr-title {
+            color: #666;
+            font-size: 12px;
+        }
+    </style>
+    <title>{{with .Query}}{{.}} · {{end}}search · backboard</title>
+</head>
+<body>
+<div class="header">
+    <h1><a href="/">backboard</a></h1>
+    <form action="/search">
+        <input type="search" name="q" value="{{.Query}}" size="40" placeholder="words, SHA prefix or PR number" autofocus>
+        <input type="submit" value="search">
+    </form>
+</div>
+{{if .Query}}
+<table id="result-table">
+    <thead>
+    <tr>
+        <th>Repo</th>
+        <th>SHA</th>
+        <th>Title</th>
+        <th>PR</th>
+        <th>Base</th>
+        <th>Boards</th>
+    </tr>
+    </thead>
+    <tbody>
+    {{range .Results}}
+        <tr>
+            <td>{{.Repo}}</td>
+            <td class="sha" title="{{.SHA}}">{{if .CommitURL}}<a href="{{.CommitURL}}">{{.SHA.Short}}</a>{{else}}{{.SHA.Short}}{{end}}</td>
+            <td>{{.Title}}</td>
+            <td><a href="{{.PR.URL}}">{{.PR}}</a> <span class="pr-title">{{.PRTitle}}</span></td>
+            <td>{{.BaseBranch}}</td>
+            <td>{{range .Boards}}<a href="{{.URL}}">{{.Branch}}</a> {{end}}</td>
+        </tr>
+    {{else}}
+        <tr><td colspan="6">No matches.</td></tr>
+    {{end}}
+    </tbody>
+</table>
+{{end}}
+</body>
+</html>`))
+
+const searchLimit = 100
+
+var (
+	prNumberQueryRegexp  = regexp.MustCompile(`^#?(\d+)$`)
+	shaPrefixQueryRegexp = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+)
+
+// parseSearchQuery parses a search as entered by a user. Every query is
+// searched for as words; queries that look like a PR number or a SHA prefix
+// are also searched for as such.
+func parseSearchQuery(s string) searchQuery {
+	s = strings.ToLower(strings.TrimSpace(s))
+	q := searchQuery{words: searchWords(s)}
+	if m := prNumberQueryRegexp.FindStringSubmatch(s); m != nil {
+		q.prNumber, _ = strconv.Atoi(m[1])
+	}
+	if shaPrefixQueryRegexp.MatchString(s) {
+		q.shaLow, _ = parseSHA(s + strings.Repeat("0", 40-len(s)))
+		q.shaHigh, _ = parseSHA(s + strings.Repeat("f", 40-len(s)))
+	}
+	return q
+}
+
+// searchWords splits s into lowercase words of letters and digits.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsWords reports whether text contains every one of words, as
+// returned by searchWords.
+func containsWords(text string, words []string) bool {
+	have := map[string]bool{}
+	for _, w := range searchWords(text) {
+		have[w] = true
+	}
+	for _, w := range words {
+		if !have[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// searchResult is a search hit as shown on the search page.
+type searchResult struct {
+	Repo       repo
+	PR         *pr
+	PRTitle    string
+	BaseBranch string
+	SHA        sha
+	Title      string
+	// CommitURL is the URL of the commit's detail page, if it is a master
+	// commit.
+	CommitURL string
+	// Boards link to the commit's row on the board of each release branch
+	// on which it appears.
+	Boards []boardLink
+}
+
+type boardLink struct {
+	Branch string
+	URL    string
+}
+
+// boardRowURL returns the URL of the row for the master commit c on the
+// board for branch. The board shows every author and excluded commits, so
+// that the row is sure to be there.
+func boardRowURL(re repo, branch string, c sha) string {
+	q := url.Values{
+		"repo":     {strconv.FormatInt(re.id, 10)},
+		"branch":   {branch},
+		"author":   {""},
+		"excluded": {"1"},
+	}
+	return "/?" + q.Encode() + "#" + c.String()
+}
+
+// boardLinks returns the links to the board rows for h, a commit of a PR in
+// re. A commit of a master PR appears on the board of every release branch
+// cut before it merged. A commit of a backport PR links to the row of the
+// master commit it backports. candidates caches the candidate commits of
+// each of re's release branches.
+func boardLinks(re repo, h searchHit, candidates map[string][]commit) []boardLink {
+	candidatesFor := func(branch string) []commit {
+		cs, ok := candidates[branch]
+		if !ok {
+			cs = re.masterCommits.truncate(re.branchMergeBases[branch])
+			candidates[branch] = cs
+		}
+		return cs
+	}
+	var links []boardLink
+	if h.baseBranch == "master" {
+		for _, branch := range re.releaseBranches {
+			for _, c := range candidatesFor(branch) {
+				if string(c.sha) == string(h.sha) {
+					links = append(links, boardLink{Branch: branch, URL: boardRowURL(re, branch, c.sha)})
+					break
+				}
+			}
+		}
+		return links
+	}
+	if !containsString(re.releaseBranches, h.baseBranch) {
+		return nil
+	}
+	for _, c := range candidatesFor(h.baseBranch) {
+		if c.MessageID() == h.messageID {
+			links = append(links, boardLink{Branch: h.baseBranch, URL: boardRowURL(re, h.baseBranch, c.sha)})
+			break
+		}
+	}
+	return links
+}
+
+// serveSearch renders the commits and PRs that match a search.
+func (s *server) serveSearch(w http.ResponseWriter, r *http.Request) error {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	var results []searchResult
+	if query != "" {
+		hits, err := s.store.search(r.Context(), parseSearchQuery(query), searchLimit)
+		if err != nil {
+			return err
+		}
+
+		repoLock.RLock()
+		reposByID := map[int64]*repo{}
+		for i := range repos {
+			re := repos[i]
+			reposByID[re.id] = &re
+		}
+		candidates := map[int64]map[string][]commit{}
+		for _, h := range hits {
+			re := reposByID[h.repoID]
+			if re == nil {
+				// Tracked since the server started.
+				continue
+			}
+			if candidates[re.id] == nil {
+				candidates[re.id] = map[string][]commit{}
+			}
+			var commitLink string
+			if _, ok := re.masterCommits.find(h.sha); ok && h.baseBranch == "master" {
+				commitLink = commitURL(*re, h.sha)
+			}
+			results = append(results, searchResult{
+				Repo:       *re,
+				PR:         &pr{repo: re, number: h.number},
+				PRTitle:    h.prTitle,
+				BaseBranch: h.baseBranch,
+				SHA:        h.sha,
+				Title:      h.title,
+				CommitURL:  commitLink,
+				Boards:     boardLinks(*re, h, candidates[re.id]),
+			})
+		}
+		repoLock.RUnlock()
+	}
+	return searchTemplate.Execute(w, struct {
+		Query   string
+		Results []searchResult
+	}{
+		Query:   query,
+		Results: results,
+	})
+}
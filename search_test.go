@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		e := newTestEnv(t, st)
+		setupBackports(e)
+		e.gh.updatePR(3, func(pr *github.PullRequest) {
+			pr.Body = github.String("The range cache no longer evicts c.")
+		})
+		e.sync()
+
+		shas := map[string]sha{}
+		for _, c := range e.board(boardOptions{branch: "release-1.0"}).Commits {
+			shas[c.Title()] = c.SHA()
+		}
+		re := e.repo()
+
+		type result struct {
+			pr     int
+			title  string
+			boards []boardLink
+		}
+		search := func(query string) []result {
+			t.Helper()
+			hits, err := st.search(e.ctx, parseSearchQuery(query), searchLimit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out []result
+			for _, h := range hits {
+				out = append(out, result{h.number, h.title, boardLinks(re, h, map[string][]commit{})})
+			}
+			return out
+		}
+		row := func(title string) []boardLink {
+			return []boardLink{{Branch: "release-1.0", URL: boardRowURL(re, "release-1.0", shas[title])}}
+		}
+
+		cases := []struct {
+			query    string
+			expected []result
+		}{
+			// Words in a PR body match each of its commits.
+			{"Range Cache", []result{{3, "c", row("c")}}},
+			{"range lookup", nil},
+			// A commit of a backport PR links to the master commit's row.
+			{"b1", []result{{5, "b1", row("b1")}, {2, "b1", row("b1")}}},
+			{"#4", []result{{4, "a", row("a")}}},
+			{shas["b2"].String()[:7], []result{{2, "b2", row("b2")}}},
+		}
+		for _, tc := range cases {
+			if actual := search(tc.query); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("%q: expected %+v, got %+v", tc.query, tc.expected, actual)
+			}
+		}
+
+		// SQLite's text index survives a VACUUM, which renumbers the rowids
+		// that aren't an INTEGER PRIMARY KEY.
+		if sqlSt, ok := st.(*sqlStore); ok {
+			if _, err := sqlSt.db.ExecContext(e.ctx, `VACUUM`); err != nil {
+				t.Fatal(err)
+			}
+			for _, tc := range cases {
+				if actual := search(tc.query); !reflect.DeepEqual(actual, tc.expected) {
+					t.Errorf("after VACUUM: %q: expected %+v, got %+v", tc.query, tc.expected, actual)
+				}
+			}
+		}
+
+		w := httptest.NewRecorder()
+		(&server{store: st}).ServeHTTP(w, httptest.NewRequest("GET", "/search?q=cache", nil))
+		link := strings.Replace(boardRowURL(re, "release-1.0", shas["c"]), "&", "&amp;", -1)
+		if !strings.Contains(w.Body.String(), `href="`+link+`"`) {
+			t.Errorf("search page does not link to %s:\n%s", link, w.Body)
+		}
+	})
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	q := parseSearchQuery(" #123 ")
+	if q.prNumber != 123 || !reflect.DeepEqual(q.words, []string{"123"}) || q.shaLow != nil {
+		t.Errorf("unexpected query %+v", q)
+	}
+	q = parseSearchQuery("DEADBEEF")
+	if q.shaLow.String() != "deadbeef"+strings.Repeat("0", 32) || q.shaHigh.String() != "deadbeef"+strings.Repeat("f", 32) {
+		t.Errorf("unexpected SHA range %s-%s", q.shaLow, q.shaHigh)
+	}
+	if q := parseSearchQuery("range-cache fix"); !reflect.DeepEqual(q.words, []string{"range", "cache", "fix"}) {
+		t.Errorf("unexpected words %q", q.words)
+	}
+}
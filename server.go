@@ -1,15 +1,16 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"sort"
 	"strconv"
-	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
 )
 
 var indexTemplate = template.Must(template.New("index.html").Parse(`<!doctype html>
@@ -37,6 +38,12 @@ var indexTemplate = template.Must(template.New("index.html").Parse(`<!doctype ht
             text-transform: uppercase;
         }
 
+        .identity {
+            color: #666;
+            font-size: 13px;
+            text-align: right;
+        }
+
         .header {
             margin: 0 auto;
             text-align: center;
@@ -83,6 +90,10 @@ var indexTemplate = template.Must(template.New("index.html").Parse(`<!doctype ht
             padding: 0.3em 0.3em;
         }
 
+        #commit-table tr:target td {
+            background: #ffd;
+        }
+
         #commit-table tr.selected td:nth-child(-n+4) {
             background: #fffbcc;
 		}
@@ -95,10 +106,56 @@ var indexTemplate = template.Must(template.New("index.html").Parse(`<!doctype ht
             visibility: hidden;
         }
 
+        #commit-table tr:hover form {
+            visibility: visible;
+        }
+
+        .history {
+            font-size: 12px;
+        }
+
+        .comment {
+            color: #666;
+            font-size: 12px;
+        }
+
         .sha {
             font-family: monospace;
         }
 
+        .prediction {
+            color: #999;
+            cursor: help;
+        }
+
+        .prediction.conflict {
+            color: #c60;
+        }
+
+        .component {
+            background: #eee;
+            border-radius: 3px;
+            color: #666;
+            font-size: 11px;
+            margin-left: 4px;
+            padding: 0 4px;
+        }
+
+        .tag {
+            color: #393;
+            font-size: 11px;
+        }
+
+        .prerequisites {
+            color: #c60;
+            font-size: 12px;
+        }
+
+        .gaps {
+            color: #c00;
+            font-size: 12px;
+        }
+
         .center {
             text-align: center;
 		}
@@ -117,11 +174,26 @@ var indexTemplate = template.Must(template.New("index.html").Parse(`<!doctype ht
 		#backport-command {
 			padding: 14px;
 		}
+
+		#backport-command ul {
+			font-family: helvetica, sans-serif;
+			list-style: none;
+			margin: 0;
+			padding: 0;
+		}
+
+		#backport-command .problem {
+			color: #c00;
+		}
+
+		#backport-command .warning {
+			color: #a60;
+		}
     </style>
 	<title>backboard</title>
 
 	<script>
-		var prs = {{.MasterPRs}};
+		var repo = {{.Repo.ID}}, branch = {{.Branch}};
 
 		document.addEventListener("DOMContentLoaded", function () {
 			document.querySelector("#commit-table").addEventListener("click", function (e) {
@@ -139,45 +211,91 @@ var indexTemplate = template.Must(template.New("index.html").Parse(`<!doctype ht
 					updateBackportHint();
 				}
 			});
+			document.querySelector("#backport-style").addEventListener("change", updateBackportHint);
+			document.querySelector("#backport-prerequisites").addEventListener("change", updateBackportHint);
 		});
 
 		function updateBackportHint() {
 			var selectedTrs = Array.from(document.querySelectorAll("#commit-table tr.selected"));
-			var selectedShas = new Set(selectedTrs.map(n => n.getAttribute("data-sha")));
-			var selectedPrs = new Set(selectedTrs.map(n => n.getAttribute("data-master-pr")).reverse());
+			var selectedShas = selectedTrs.map(n => n.getAttribute("data-sha"));
 
 			var div = document.querySelector("#backport-command");
 
-			if (selectedPrs.size == 0) {
+			if (selectedShas.length == 0) {
 				div.style.display = "none";
 				document.body.style.paddingBottom = "0";
 				return;
 			}
 
-			var unselectedShas = new Set();
-			for (var pr of selectedPrs) {
-				for (var sha of prs[pr]) {
-					if (!selectedShas.has(sha))
-						unselectedShas.add(sha);
+			var params = new URLSearchParams({
+				repo: repo,
+				branch: branch,
+				style: document.querySelector("#backport-style").value,
+			});
+			if (document.querySelector("#backport-prerequisites").checked)
+				params.set("prerequisites", "1");
+			for (var sha of selectedShas)
+				params.append("sha", sha);
+			fetch("/backport/command?" + params).then(function (res) {
+				if (!res.ok)
+					return res.text().then(text => ({command: text, problems: [], warnings: []}));
+				return res.json();
+			}).then(function (res) {
+				// The response may add prerequisites to the selection.
+				var shas = res.selected || selectedShas;
+				for (var sha of shas) {
+					var tr = document.querySelector("#commit-table tr[data-sha='" + sha + "']");
+					if (tr)
+						tr.classList.add("selected");
 				}
-			}
-
-			var command = "backport " + Array.from(selectedPrs).join(" ");
-			if (selectedShas.size > unselectedShas.size)
-				command += " " + Array.from(unselectedShas).map(s => "-c '!" + s.slice(0, 7) + "'").join(" ");
-			else if (unselectedShas.size > 0)
-				command += " " + Array.from(selectedShas).map(s => "-c " + s.slice(0, 7)).join(" ");
-
-			div.querySelector("span").innerText = command;
-			div.style.display = "block";
-			console.log(div.offsetHeight);
-			document.body.style.paddingBottom = div.offsetHeight + "px";
+				div.querySelector("span").innerText = res.command;
+				var notes = div.querySelector("ul");
+				notes.innerHTML = "";
+				for (var [cls, msgs] of [["problem", res.problems], ["warning", res.warnings]]) {
+					for (var msg of msgs) {
+						var li = document.createElement("li");
+						li.className = cls;
+						li.innerText = msg;
+						notes.appendChild(li);
+					}
+				}
+				div.style.display = "block";
+				document.body.style.paddingBottom = div.offsetHeight + "px";
+
+				var form = div.querySelector("form");
+				if (form) {
+					form.querySelectorAll("input[name=sha]").forEach(n => n.remove());
+					for (var sha of shas) {
+						var input = document.createElement("input");
+						input.type = "hidden";
+						input.name = "sha";
+						input.value = sha;
+						form.appendChild(input);
+					}
+				}
+			});
 		}
 	</script>
 </head>
 <body>
+<div class="identity">
+    {{if .Identity}}
+        signed in as {{.Identity}} ({{.Role}} on {{.Branch}}){{if .LoginURL}} · <a href="/logout">sign out</a>{{end}}
+    {{else if .LoginURL}}
+        <a href="{{.LoginURL}}">sign in</a>
+    {{end}}
+</div>
 <div class="header">
     <h1><a href="/">backboard</a></h1>
+    <p>
+        <a href="/activity?repo={{.Repo.ID}}">activity</a>
+        · <a href="/release-notes?repo={{.Repo.ID}}&branch={{.Branch}}">release notes</a>
+        · <a href="/forward-ports?repo={{.Repo.ID}}&branch={{.Branch}}">forward-ports{{with .ForwardPorts}} ({{len .}}){{end}}</a>
+        · <a href="/roles?repo={{.Repo.ID}}">roles</a>
+    </p>
+    <form action="/search">
+        <input type="search" name="q" size="40" placeholder="search commits and PRs">
+    </form>
     <div class="forms">
         <form>
             <label>
@@ -213,16 +331,48 @@ var indexTemplate = template.Must(template.New("index.html").Parse(`<!doctype ht
                 </select>
                 <input type="hidden" name="repo" value="{{.Repo.ID}}">
                 <input type="hidden" name="branch" value="{{.Branch}}">
+                <input type="hidden" name="component" value="{{.Component}}">
+                <input type="hidden" name="path" value="{{.Path}}">
                 <input type="submit" value="go">
             </label>
         </form>
-        <!--<form>
+        <form>
+            <label>
+                <span>component</span>
+                <select name="component">
+                    <option value="">All components</option>
+                    {{range .Components}}
+                        <option {{if eq $.Component .Name}}selected{{end}}>{{.Name}}</option>
+                    {{end}}
+                </select>
+            </label>
+            <label>
+                <span>path</span>
+                <input type="text" name="path" value="{{.Path}}" placeholder="e.g. pkg/sql/">
+            </label>
+            <input type="hidden" name="repo" value="{{.Repo.ID}}">
+            <input type="hidden" name="branch" value="{{.Branch}}">
+            <input type="hidden" name="author" value="{{.Author.Email}}">
+            <input type="submit" value="go">
+        </form>
+        <form>
             <label>
                 <span>show excluded</span>
-                <input type="checkbox" name="excluded">
-                <input type="submit" value="go">
+                <input type="checkbox" name="excluded" value="1" {{if .ShowExcluded}}checked{{end}}>
             </label>
-        </form>-->
+            {{with .LatestTag}}
+                <label>
+                    <span>landed since {{.}}</span>
+                    <input type="checkbox" name="since-tag" value="1" {{if $.SinceLastTag}}checked{{end}}>
+                </label>
+            {{end}}
+            <input type="hidden" name="repo" value="{{.Repo.ID}}">
+            <input type="hidden" name="branch" value="{{.Branch}}">
+            <input type="hidden" name="author" value="{{.Author.Email}}">
+            <input type="hidden" name="component" value="{{.Component}}">
+            <input type="hidden" name="path" value="{{.Path}}">
+            <input type="submit" value="go">
+        </form>
     </div>
 </div>
 <table id="commit-table">
@@ -235,191 +385,322 @@ var indexTemplate = template.Must(template.New("index.html").Parse(`<!doctype ht
         <th>MPR</th>
         <th>BPR</th>
         <th>Ok?</th>
+        <th>Gaps</th>
         <th></th>
     </tr>
     </thead>
     <tbody>
     {{range .Commits}}
-        <tr class="{{if .MasterPRRowSpan}}master-border{{end}} {{if .BackportPRRowSpan}}backport-border{{end}}" data-sha="{{.SHA}}" data-master-pr="{{.MasterPR.Number}}" {{if .Backportable}}data-backportable{{end}}>
-            <td class="sha master-border" title="{{.SHA}}">{{.SHA.Short}}</td>
+        <tr id="{{.SHA}}" class="{{if .MasterPRRowSpan}}master-border{{end}} {{if .BackportPRRowSpan}}backport-border{{end}}" data-sha="{{.SHA}}" data-master-pr="{{.MasterPR.Number}}" {{if .Backportable}}data-backportable{{end}}>
+            <td class="sha master-border" title="{{.SHA}}"><a href="/commit?repo={{$.Repo.ID}}&sha={{.SHA}}">{{.SHA.Short}}</a></td>
             <td class="master-border">{{.MasterPR.MergedAt}}</td>
             <td class="master-border" title="{{.Author.Email}}">{{.Author.Short}}</td>
-            <td class="master-border">{{.Title}}</td>
+            <td class="master-border">
+                {{.Title}}
+                {{range .Components}}<span class="component">{{.}}</span>{{end}}
+                {{with .Prerequisites}}
+                    <div class="prerequisites">needs {{range $i, $c := .}}{{if $i}}, {{end}}<span class="sha" title="{{$c.Title}}">{{$c.SHA.Short}}</span>{{end}}</div>
+                {{end}}
+            </td>
             {{if .MasterPRRowSpan}}
                 <td class="master-border" rowspan="{{.MasterPRRowSpan}}"><a href="{{.MasterPR.URL}}">{{.MasterPR}}</a></td>
 			{{end}}
 			{{if .BackportPRRowSpan}}
 				<td class="backport-border" rowspan="{{.BackportPRRowSpan}}"><a href="{{.BackportPR.URL}}">{{.BackportPR}}</a></td>
 			{{end}}
-            <td class="backport-border center">{{.BackportStatus}}</td>
+            <td class="backport-border center" {{with .Exclusion}}title="excluded by {{.CreatedBy}}{{with .Reason}}: {{.}}{{end}}"{{end}}>
+                {{.BackportStatus}}
+                {{with .Tag}}<span class="tag" title="first released in {{.}}">{{.}}</span>{{end}}
+                {{with .Prediction}}<span class="prediction{{if not .Clean}} conflict{{end}}" title="{{.}}">{{if .Clean}}○{{else}}⚠{{end}}</span>{{end}}
+            </td>
+            <td class="backport-border">
+                {{with .Gaps}}<span class="gaps" title="backported to an older release branch, but missing from these">⚠ {{range $i, $b := .}}{{if $i}}, {{end}}{{$b}}{{end}}</span>{{end}}
+            </td>
+            <td class="backport-border">
+                <a class="history" href="/history?repo={{$.Repo.ID}}&sha={{.SHA}}">history</a>
+                {{range .Comments}}
+                    <div class="comment" title="{{.CreatedAt.Format "2006-01-02 15:04:05"}}"><b>{{.UserEmail}}</b>: {{.Body}}</div>
+                {{end}}
+                {{if $.CanComment}}
+                    <form method="post" action="/comment">
+                        <input type="hidden" name="repo" value="{{$.Repo.ID}}">
+                        <input type="hidden" name="branch" value="{{$.Branch}}">
+                        <input type="hidden" name="sha" value="{{.SHA}}">
+                        <input type="hidden" name="next" value="{{$.Next}}">
+                        <input type="text" name="body" placeholder="comment">
+                    </form>
+                {{end}}
+                {{if and $.CanExclude (or .Backportable .Exclusion)}}
+                    <form method="post" action="/exclude">
+                        <input type="hidden" name="repo" value="{{$.Repo.ID}}">
+                        <input type="hidden" name="branch" value="{{$.Branch}}">
+                        <input type="hidden" name="sha" value="{{.SHA}}">
+                        <input type="hidden" name="next" value="{{$.Next}}">
+                        {{if .Exclusion}}
+                            <input type="hidden" name="undo" value="1">
+                            <input type="submit" value="include">
+                        {{else}}
+                            <input type="text" name="reason" placeholder="reason">
+                            <input type="submit" value="won't backport">
+                        {{end}}
+                    </form>
+                {{end}}
+            </td>
         </tr>
     {{end}}
     </tbody>
 </table>
 <div id="backport-command" style="display: none">
+	<select id="backport-style">
+		<option value="backport">backport</option>
+		<option value="cherry-pick">git cherry-pick</option>
+	</select>
+	<label><input type="checkbox" id="backport-prerequisites"> include prerequisites</label>
 	<span></span>
+	<ul></ul>
+	{{if .CanBackport}}
+		<form method="post" action="/backport">
+			<input type="hidden" name="repo" value="{{.Repo.ID}}">
+			<input type="hidden" name="branch" value="{{.Branch}}">
+			<input type="hidden" name="next" value="{{.Next}}">
+			<input type="text" name="head" placeholder="cherry-picked branch, e.g. you:backport-pr">
+			<input type="text" name="title" placeholder="title (optional)">
+			<input type="submit" value="open backport PR">
+		</form>
+	{{end}}
 </div>
 </body>
 </html>`))
 
 type server struct {
-	db *sql.DB
+	store store
+	auth  authConfig
+	// gh is used to open backport PRs. It may be nil, in which case the
+	// board cannot open PRs.
+	gh *github.Client
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
+	if id := s.auth.authenticate(r); id != nil {
+		r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+	}
+
+	var handler func(http.ResponseWriter, *http.Request) error
+	switch r.URL.Path {
+	case "/":
+		handler = s.serveBoard
+	case "/login":
+		handler = s.serveLogin
+	case "/oauth/callback":
+		handler = s.serveOAuthCallback
+	case "/logout":
+		handler = s.serveLogout
+	case "/exclude":
+		handler = s.serveExclude
+	case "/comment":
+		handler = s.serveComment
+	case "/roles":
+		handler = s.serveRoles
+	case "/activity":
+		handler = s.serveActivity
+	case "/history":
+		handler = s.serveHistory
+	case "/backport":
+		handler = s.serveCreateBackport
+	case "/backport/command":
+		handler = s.serveBackportCommand
+	case "/search":
+		handler = s.serveSearch
+	case "/commit":
+		handler = s.serveCommit
+	case "/release-notes":
+		handler = s.serveReleaseNotes
+	case "/forward-ports":
+		handler = s.serveForwardPorts
+	case "/webhooks":
+		handler = s.serveWebhooks
+	case "/metrics":
+		handler = s.serveMetrics
+	default:
 		http.Redirect(w, r, "/", http.StatusPermanentRedirect)
 		return
 	}
 
-	if err := s.serveBoard(w, r); err != nil {
+	start := time.Now()
+	defer func() {
+		httpRequestDuration.WithLabelValues(r.URL.Path).Observe(time.Since(start).Seconds())
+	}()
+	if err := handler(w, r); err != nil {
+		var forbidden errForbidden
+		var badRequest errBadRequest
+		if errors.As(err, &forbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if errors.As(err, &badRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err == errMethodNotAllowed {
+			http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+			return
+		} else if err == errNotFound {
+			http.NotFound(w, r)
+			return
+		}
 		log.Printf("request handler error: %s", err)
 		http.Error(w, "internal error; see logs for details", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (s *server) serveBoard(w http.ResponseWriter, r *http.Request) error {
-	repoLock.RLock()
-	defer repoLock.RUnlock()
+var errNotFound = errors.New("not found")
 
-	var re repo
-	if s := r.URL.Query().Get("repo"); s != "" {
-		repoID, err := strconv.Atoi(s)
-		if err != nil {
-			return err
+// errBadRequest wraps errors caused by malformed requests.
+type errBadRequest struct {
+	error
+}
+
+// findRepo returns the repo whose ID is the decimal string s, or the first
+// repo if s is empty. Callers must hold repoLock.
+func findRepo(s string) (repo, error) {
+	if s == "" {
+		if len(repos) == 0 {
+			return repo{}, errors.New("no repos available")
 		}
-		for _, re0 := range repos {
-			if re0.id == int64(repoID) {
-				re = re0
-			}
+		return repos[0], nil
+	}
+	repoID, err := strconv.Atoi(s)
+	if err != nil {
+		return repo{}, err
+	}
+	for _, re := range repos {
+		if re.id == int64(repoID) {
+			return re, nil
 		}
-	} else if len(repos) > 0 {
-		re = repos[0]
-	} else {
-		return errors.New("no repos available")
 	}
+	return repo{}, fmt.Errorf("no repo with ID %d", repoID)
+}
 
-	var branch string
-	if s := r.URL.Query().Get("branch"); s != "" {
-		for _, b := range re.releaseBranches {
-			if b == s {
-				branch = b
-			}
+// findBranch returns the release branch of re named s, or the newest release
+// branch if s is empty.
+func findBranch(re repo, s string) (string, error) {
+	if s == "" {
+		if len(re.releaseBranches) == 0 {
+			return "", fmt.Errorf("no release branches for repo %s available", re)
 		}
-		if branch == "" {
-			return fmt.Errorf("%q is not a release branch", branch)
+		return re.releaseBranches[0], nil
+	}
+	for _, b := range re.releaseBranches {
+		if b == s {
+			return b, nil
 		}
-	} else if len(re.releaseBranches) > 0 {
-		branch = re.releaseBranches[0]
-	} else {
-		return fmt.Errorf("no release branches for repo %s available", re)
 	}
+	return "", fmt.Errorf("%q is not a release branch", s)
+}
 
-	commits := re.masterCommits.truncate(re.branchMergeBases[branch])
-
-	authors := map[user]struct{}{}
-	for _, c := range commits {
-		authors[c.Author] = struct{}{}
+// loginURLIfEnabled returns the URL at which the user can sign in, or the
+// empty string if the board does not offer sign in.
+func loginURLIfEnabled(ac authConfig, r *http.Request) string {
+	if !ac.loginEnabled() {
+		return ""
 	}
-	var author user
-	if s := r.URL.Query().Get("author"); s != "" {
-		for a := range authors {
-			if a.Email == s {
-				author = a
-			}
-		}
-		if author == (user{}) {
-			return fmt.Errorf("%q is not a recognized author", author)
-		}
-		var newCommits []commit
-		for _, c := range commits {
-			if c.Author == author {
-				newCommits = append(newCommits, c)
-			}
-		}
-		commits = newCommits
+	return loginURL(r)
+}
+
+func (s *server) serveBoard(w http.ResponseWriter, r *http.Request) error {
+	repoLock.RLock()
+	defer repoLock.RUnlock()
+
+	re, err := findRepo(r.URL.Query().Get("repo"))
+	if err != nil {
+		return err
 	}
-	var sortedAuthors []user
-	for a := range authors {
-		sortedAuthors = append(sortedAuthors, a)
+	branch, err := findBranch(re, r.URL.Query().Get("branch"))
+	if err != nil {
+		return err
 	}
-	sort.Slice(sortedAuthors, func(i, j int) bool {
-		return strings.Compare(sortedAuthors[i].Email, sortedAuthors[j].Email) < 0
-	})
-
-	masterPRs := map[int][]string{}
-	var acommits []acommit
-	var lastMasterPR *pr
-	masterPRStart := -1
-	var lastBackportPR *pr
-	backportPRStart := -1
-	for i, c := range commits {
-		// TODO(benesch): these rowspan computations hurt to look at.
-		masterPR := re.masterPRs[string(c.sha)]
-		// TODO(benesch): masterPR should never be nil!
-		if masterPR != nil && (lastMasterPR == nil || lastMasterPR.number != masterPR.number) {
-			if masterPRStart >= 0 {
-				acommits[masterPRStart].MasterPRRowSpan = i - masterPRStart
-			}
-			masterPRStart = i
-			lastMasterPR = masterPR
-		}
-		backportPR := re.branchPRs[c.MessageID()][branch]
-		if !((lastBackportPR == nil && backportPR == nil && lastMasterPR != masterPR) || (lastBackportPR != nil && backportPR != nil && lastBackportPR.number == backportPR.number)) {
-			if backportPRStart >= 0 {
-				acommits[backportPRStart].BackportPRRowSpan = i - backportPRStart
-			}
-			backportPRStart = i
-			lastBackportPR = backportPR
-		}
 
-		var backportStatus string
-		if backportPR != nil {
-			if backportPR.mergedAt.Valid {
-				backportStatus = "✓"
-			} else {
-				backportStatus = "◷"
-			}
-		}
-		// TODO(benesch): redundant. which to keep?
-		if _, backported := re.branchCommits[branch].messageIDs[c.MessageID()]; backported {
-			backportStatus = "✓"
-		}
-		acommits = append(acommits, acommit{
-			commit:         c,
-			BackportStatus: backportStatus,
-			MasterPR:       masterPR,
-			BackportPR:     backportPR,
-			Backportable:   backportPR == nil,
-		})
-		masterPRs[masterPR.number] = append(masterPRs[masterPR.number], c.sha.String())
+	ctx := r.Context()
+	id := identityFromContext(ctx)
+	opts := boardOptions{
+		branch:       branch,
+		showExcluded: r.URL.Query().Get("excluded") != "",
+		path:         r.URL.Query().Get("path"),
+		component:    r.URL.Query().Get("component"),
+		sinceLastTag: r.URL.Query().Get("since-tag") != "",
 	}
-	if masterPRStart >= 0 {
-		acommits[masterPRStart].MasterPRRowSpan = len(acommits) - masterPRStart
+	if vs, ok := r.URL.Query()["author"]; ok {
+		opts.author = vs[0]
+	} else {
+		// Without an explicit choice, signed-in users see their own commits.
+		opts.me = id
+	}
+	b, err := buildBoard(ctx, s.store, re, opts)
+	if err != nil {
+		return err
 	}
-	if backportPRStart >= 0 {
-		acommits[backportPRStart].BackportPRRowSpan = len(acommits) - backportPRStart
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		return printStatus(w, b, "json", false)
+	}
+
+	userRole, err := loadRole(ctx, s.store, re.id, branch, id)
+	if err != nil {
+		return err
 	}
 
 	if err := indexTemplate.Execute(w, struct {
-		Repos     []repo
-		Repo      repo
-		Commits   []acommit
-		Branches  []string
-		Branch    string
-		Authors   []user
-		Author    user
-		MasterPRs map[int][]string
+		Repos    []repo
+		Repo     repo
+		Commits  []acommit
+		Branches []string
+		Branch   string
+		Authors  []user
+		Author   user
+		// Components are the repo's components, and Component and Path the
+		// component and path prefix the board is restricted to, if any.
+		Components []component
+		Component  string
+		Path       string
+		Identity   *identity
+		LoginURL   string
+		Role       role
+		// CanComment, CanExclude and CanBackport report whether the user may
+		// perform the corresponding actions on the branch.
+		CanComment   bool
+		CanExclude   bool
+		CanBackport  bool
+		ShowExcluded bool
+		// LatestTag is the branch's newest tag, and SinceLastTag whether the
+		// board is restricted to the commits that landed after it.
+		LatestTag    string
+		SinceLastTag bool
+		// ForwardPorts are the commits on the branch that have yet to land on
+		// master.
+		ForwardPorts []commit
+		Next         string
 	}{
-		Repos:     repos,
-		Repo:      re,
-		Commits:   acommits,
-		Branches:  re.releaseBranches,
-		Branch:    branch,
-		Authors:   sortedAuthors,
-		Author:    author,
-		MasterPRs: masterPRs,
+		Repos:      repos,
+		Repo:       re,
+		Commits:    b.Commits,
+		Branches:   re.releaseBranches,
+		Branch:     branch,
+		Authors:    b.Authors,
+		Author:     b.Author,
+		Components: b.Components,
+		Component:  opts.component,
+		Path:       opts.path,
+		Identity:   id,
+		LoginURL:   loginURLIfEnabled(s.auth, r),
+		Role:       userRole,
+
+		CanComment:   userRole >= actionComment.minRole(),
+		CanExclude:   userRole >= actionExclude.minRole(),
+		CanBackport:  s.gh != nil && userRole >= actionExecuteBackport.minRole(),
+		ShowExcluded: opts.showExcluded,
+		LatestTag:    b.LatestTag,
+		SinceLastTag: opts.sinceLastTag,
+		ForwardPorts: re.forwardPorts[branch],
+		Next:         r.URL.RequestURI(),
 	}); err != nil {
 		return err
 	}
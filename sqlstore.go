@@ -0,0 +1,826 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlStore is a store backed by a SQL database.
+type sqlStore struct {
+	db *database
+}
+
+var _ store = (*sqlStore)(nil)
+
+func (s *sqlStore) ensureRepo(ctx context.Context, owner, name string) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO repos (github_owner, github_repo)
+		VALUES ($1, $2)
+		ON CONFLICT (github_owner, github_repo) DO UPDATE SET github_owner = excluded.github_owner
+		RETURNING id`,
+		owner, name,
+	).Scan(&id)
+	return id, err
+}
+
+func (s *sqlStore) putRepo(ctx context.Context, r repoRecord) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO repos (github_owner, github_repo, credentials, tracked)
+		VALUES ($1, $2, $3, true)
+		ON CONFLICT (github_owner, github_repo) DO UPDATE SET
+			credentials = excluded.credentials, tracked = true`,
+		r.owner, r.name, r.credentials)
+	return err
+}
+
+func (s *sqlStore) untrackRepo(ctx context.Context, owner, name string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE repos SET tracked = false WHERE github_owner = $1 AND github_repo = $2 AND tracked`,
+		owner, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s/%s is not tracked", owner, name)
+	}
+	return nil
+}
+
+func (s *sqlStore) trackedRepos(ctx context.Context) ([]repoRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, github_owner, github_repo, credentials FROM repos
+		WHERE tracked ORDER BY github_owner, github_repo`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []repoRecord
+	for rows.Next() {
+		var r repoRecord
+		if err := rows.Scan(&r.id, &r.owner, &r.name, &r.credentials); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func prUpdatedAt(ctx context.Context, q queryer, id int64) (time.Time, bool, error) {
+	var updatedAt time.Time
+	err := q.QueryRowContext(ctx, `SELECT updated_at FROM prs WHERE id = $1`, id).Scan(&updatedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	return updatedAt, true, nil
+}
+
+func (s *sqlStore) prUpdatedAt(ctx context.Context, id int64) (time.Time, bool, error) {
+	return prUpdatedAt(ctx, s.db, id)
+}
+
+func (s *sqlStore) putPR(
+	ctx context.Context, p prRecord, commits []commit, transitions func(prev *prState) []event,
+) error {
+	return s.db.dialect.executeTx(ctx, s.db.DB, func(tx *sql.Tx) error {
+		if updatedAt, ok, err := prUpdatedAt(ctx, tx, p.id); err != nil {
+			return err
+		} else if ok && updatedAt.Equal(p.updatedAt) {
+			return nil
+		}
+
+		var prev *prState
+		var s prState
+		err := tx.QueryRowContext(ctx,
+			`SELECT open, merged_at IS NOT NULL, base_branch FROM prs WHERE id = $1`, p.id,
+		).Scan(&s.open, &s.merged, &s.baseBranch)
+		if err == nil {
+			prev = &s
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+		for _, ev := range transitions(prev) {
+			if err := recordEvent(ctx, tx, ev); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO prs (id, repo_id, number, title, body, open, merged_at, base_sha, base_branch, author_username, updated_at, labels, head_sha)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			ON CONFLICT (id) DO UPDATE SET
+				repo_id = excluded.repo_id, number = excluded.number,
+				title = excluded.title, body = excluded.body,
+				open = excluded.open, merged_at = excluded.merged_at,
+				base_sha = excluded.base_sha, base_branch = excluded.base_branch,
+				author_username = excluded.author_username, updated_at = excluded.updated_at,
+				labels = excluded.labels, head_sha = excluded.head_sha`,
+			p.id, p.repoID, p.number,
+			p.title, p.body,
+			p.open, p.mergedAt,
+			p.baseSHA, p.baseBranch,
+			p.authorUsername, p.updatedAt,
+			strings.Join(p.labels, "\n"),
+			p.headSHA,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM pr_commits WHERE pr_id = $1`, p.id); err != nil {
+			return err
+		}
+		for i, c := range commits {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO pr_commits (pr_id, sha, title, body, message_id, author_email, ordering)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				p.id,
+				c.sha,
+				c.title,
+				c.body,
+				c.MessageID(),
+				c.Author.Email,
+				i,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) livePRCommits(ctx context.Context, repoID int64) ([]prCommit, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT number, open, merged_at, base_branch, sha, message_id
+		FROM pr_commits JOIN prs ON pr_commits.pr_id = prs.id
+		WHERE repo_id = $1 AND (merged_at IS NOT NULL OR open)`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []prCommit
+	for rows.Next() {
+		var c prCommit
+		if err := rows.Scan(&c.number, &c.open, &c.mergedAt, &c.baseBranch, &c.sha, &c.messageID); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) prBodies(ctx context.Context, repoID int64, numbers []int) (map[int]string, error) {
+	bodies := map[int]string{}
+	if len(numbers) == 0 {
+		return bodies, nil
+	}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	var params []string
+	repoParam := arg(repoID)
+	for _, n := range numbers {
+		params = append(params, arg(n))
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT number, body FROM prs WHERE repo_id = `+repoParam+` AND number IN (`+strings.Join(params, ", ")+`)`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var number int
+		var body string
+		if err := rows.Scan(&number, &body); err != nil {
+			return nil, err
+		}
+		bodies[number] = body
+	}
+	return bodies, rows.Err()
+}
+
+func (s *sqlStore) mergedPRs(ctx context.Context, repoID int64, baseBranch string, since time.Time) ([]prRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, number, title, coalesce(body, ''), merged_at, author_username, labels, head_sha FROM prs
+		WHERE repo_id = $1 AND base_branch = $2 AND merged_at >= $3
+		ORDER BY merged_at`, repoID, baseBranch, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []prRecord
+	for rows.Next() {
+		p := prRecord{repoID: repoID, baseBranch: baseBranch}
+		var mergedAt time.Time
+		var labels string
+		var headSHA []byte
+		if err := rows.Scan(&p.id, &p.number, &p.title, &p.body, &mergedAt, &p.authorUsername, &labels, &headSHA); err != nil {
+			return nil, err
+		}
+		p.mergedAt = &mergedAt
+		p.headSHA = string(headSHA)
+		if labels != "" {
+			p.labels = strings.Split(labels, "\n")
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) exclusions(ctx context.Context, repoID int64, branch string) (map[string]exclusion, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT message_id, created_by, created_at, reason FROM branch_exclusions
+		WHERE repo_id = $1 AND branch = $2`, repoID, branch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	exclusions := map[string]exclusion{}
+	for rows.Next() {
+		var messageID string
+		var e exclusion
+		if err := rows.Scan(&messageID, &e.CreatedBy, &e.CreatedAt, &e.Reason); err != nil {
+			return nil, err
+		}
+		exclusions[messageID] = e
+	}
+	return exclusions, rows.Err()
+}
+
+// withEvent runs fn in a transaction that also records ev.
+func (s *sqlStore) withEvent(ctx context.Context, ev event, fn func(tx *sql.Tx) error) error {
+	return s.db.dialect.executeTx(ctx, s.db.DB, func(tx *sql.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return recordEvent(ctx, tx, ev)
+	})
+}
+
+func (s *sqlStore) putExclusion(
+	ctx context.Context, repoID int64, branch, messageID string, e exclusion, ev event,
+) error {
+	return s.withEvent(ctx, ev, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO branch_exclusions (repo_id, branch, message_id, created_by, created_at, reason)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (repo_id, branch, message_id) DO UPDATE SET
+				created_by = excluded.created_by, created_at = excluded.created_at, reason = excluded.reason`,
+			repoID, branch, messageID, e.CreatedBy, e.CreatedAt, e.Reason)
+		return err
+	})
+}
+
+func (s *sqlStore) deleteExclusion(
+	ctx context.Context, repoID int64, branch, messageID string, ev event,
+) error {
+	return s.withEvent(ctx, ev, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM branch_exclusions WHERE repo_id = $1 AND branch = $2 AND message_id = $3`,
+			repoID, branch, messageID)
+		return err
+	})
+}
+
+func (s *sqlStore) comments(ctx context.Context, repoID int64) (map[string][]comment, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT message_id, created_at, sha, user_email, body FROM commit_comments
+		WHERE repo_id = $1 ORDER BY created_at`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := map[string][]comment{}
+	for rows.Next() {
+		var messageID string
+		var c comment
+		if err := rows.Scan(&messageID, &c.CreatedAt, &c.SHA, &c.UserEmail, &c.Body); err != nil {
+			return nil, err
+		}
+		comments[messageID] = append(comments[messageID], c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *sqlStore) addComment(ctx context.Context, repoID int64, messageID string, c comment, ev event) error {
+	return s.withEvent(ctx, ev, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO commit_comments (repo_id, message_id, created_at, sha, user_email, body)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			repoID, messageID, c.CreatedAt, c.SHA, c.UserEmail, c.Body)
+		return err
+	})
+}
+
+func (s *sqlStore) roles(ctx context.Context, repoID int64, username string) (map[string]role, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT branch, role FROM roles WHERE repo_id = $1 AND username = $2`, repoID, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := map[string]role{}
+	for rows.Next() {
+		var branch, name string
+		if err := rows.Scan(&branch, &name); err != nil {
+			return nil, err
+		}
+		r, err := parseRole(name)
+		if err != nil {
+			return nil, err
+		}
+		roles[branch] = r
+	}
+	return roles, rows.Err()
+}
+
+func (s *sqlStore) roleGrants(ctx context.Context, repoID int64) ([]roleGrant, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT username, branch, role FROM roles WHERE repo_id = $1 ORDER BY username, branch`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var grants []roleGrant
+	for rows.Next() {
+		var g roleGrant
+		var name string
+		if err := rows.Scan(&g.Username, &g.Branch, &name); err != nil {
+			return nil, err
+		}
+		if g.Role, err = parseRole(name); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+func (s *sqlStore) grantRole(
+	ctx context.Context, repoID int64, branch, username string, r role, ev event,
+) error {
+	return s.withEvent(ctx, ev, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO roles (repo_id, branch, username, role) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (repo_id, branch, username) DO UPDATE SET role = excluded.role`,
+			repoID, branch, username, r.String())
+		return err
+	})
+}
+
+// recordEvent records ev in tx, queueing it for delivery to every webhook if
+// it is a webhook event.
+func recordEvent(ctx context.Context, tx *sql.Tx, ev event) error {
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	var id int64
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO events (created_at, repo_id, branch, actor, kind, message_id, sha, pr_number, detail)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`,
+		ev.CreatedAt, ev.RepoID, ev.Branch, ev.Actor, ev.Kind,
+		[]byte(ev.MessageID), []byte(ev.SHA), ev.PRNumber, ev.Detail,
+	).Scan(&id); err != nil {
+		return err
+	}
+	if !webhookEvent(ev) {
+		return nil
+	}
+	// SQLite compares timestamps as text, so those that are compared must
+	// share a time zone.
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event_id, created_at, next_attempt_at)
+		SELECT id, $1, $2, $2 FROM webhooks`,
+		id, time.Now().UTC())
+	return err
+}
+
+func (s *sqlStore) recordEvent(ctx context.Context, ev event) error {
+	return s.db.dialect.executeTx(ctx, s.db.DB, func(tx *sql.Tx) error {
+		return recordEvent(ctx, tx, ev)
+	})
+}
+
+func (s *sqlStore) events(ctx context.Context, f eventFilter, limit int) ([]event, error) {
+	query := `SELECT id, created_at, repo_id, branch, actor, kind, message_id, sha, pr_number, detail
+		FROM events WHERE true`
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.repoID != 0 {
+		query += ` AND repo_id = ` + arg(f.repoID)
+	}
+	if f.messageID != "" {
+		messageID := arg([]byte(f.messageID))
+		query += ` AND (message_id = ` + messageID + ` OR pr_number IN (
+			SELECT number FROM prs JOIN pr_commits ON pr_commits.pr_id = prs.id
+			WHERE prs.repo_id = ` + arg(f.repoID) + ` AND pr_commits.message_id = ` + messageID + `))`
+	}
+	if f.before != 0 {
+		query += ` AND id < ` + arg(f.before)
+	}
+	query += ` ORDER BY id DESC LIMIT ` + arg(limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var evs []event
+	for rows.Next() {
+		var ev event
+		var messageID []byte
+		if err := rows.Scan(&ev.ID, &ev.CreatedAt, &ev.RepoID, &ev.Branch, &ev.Actor, &ev.Kind,
+			&messageID, &ev.SHA, &ev.PRNumber, &ev.Detail); err != nil {
+			return nil, err
+		}
+		ev.MessageID = string(messageID)
+		evs = append(evs, ev)
+	}
+	return evs, rows.Err()
+}
+
+func (s *sqlStore) search(ctx context.Context, q searchQuery, limit int) ([]searchHit, error) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	// Each criterion gets a subquery of its own, rather than a disjunction
+	// across the join, so that each can use the index that serves it.
+	var matches []string
+	fromCommits := `SELECT c.pr_id, c.sha FROM pr_commits c WHERE `
+	fromPRs := `SELECT c.pr_id, c.sha FROM prs p JOIN pr_commits c ON c.pr_id = p.id WHERE `
+	if len(q.words) > 0 {
+		text := arg(s.db.dialect.textQuery(q.words))
+		matches = append(matches,
+			fromCommits+s.db.dialect.matchText("pr_commits", "c", text),
+			fromPRs+s.db.dialect.matchText("prs", "p", text))
+	}
+	if q.shaLow != nil {
+		matches = append(matches, fromCommits+`c.sha BETWEEN `+arg(q.shaLow)+` AND `+arg(q.shaHigh))
+	}
+	if q.prNumber != 0 {
+		matches = append(matches, fromPRs+`p.number = `+arg(q.prNumber))
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	query := `SELECT p.repo_id, p.number, p.title, p.open, p.merged_at, p.base_branch, c.sha, c.message_id, c.title
+		FROM (` + strings.Join(matches, " UNION ") + `) m
+		JOIN pr_commits c ON c.pr_id = m.pr_id AND c.sha = m.sha
+		JOIN prs p ON p.id = c.pr_id
+		JOIN repos r ON r.id = p.repo_id
+		WHERE r.tracked AND (p.merged_at IS NOT NULL OR p.open)
+		ORDER BY p.number DESC, p.repo_id, c.ordering
+		LIMIT ` + arg(limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hits []searchHit
+	for rows.Next() {
+		var h searchHit
+		if err := rows.Scan(&h.repoID, &h.number, &h.prTitle, &h.open, &h.mergedAt, &h.baseBranch,
+			&h.sha, &h.messageID, &h.title); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+func (s *sqlStore) predictions(ctx context.Context, repoID int64, branchTip sha) (map[string]prediction, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT sha, conflicts FROM conflict_predictions WHERE repo_id = $1 AND branch_tip = $2`,
+		repoID, branchTip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	predictions := map[string]prediction{}
+	for rows.Next() {
+		var c sha
+		var conflicts string
+		if err := rows.Scan(&c, &conflicts); err != nil {
+			return nil, err
+		}
+		predictions[string(c)] = parsePrediction(conflicts)
+	}
+	return predictions, rows.Err()
+}
+
+func (s *sqlStore) putPrediction(ctx context.Context, repoID int64, branchTip, c sha, p prediction) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO conflict_predictions (repo_id, branch_tip, sha, conflicts)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (repo_id, branch_tip, sha) DO UPDATE SET conflicts = excluded.conflicts`,
+		repoID, branchTip, c, p.encode())
+	return err
+}
+
+func (s *sqlStore) prunePredictions(ctx context.Context, repoID int64, tips []sha) error {
+	args := []interface{}{repoID}
+	query := `DELETE FROM conflict_predictions WHERE repo_id = $1`
+	if len(tips) > 0 {
+		var params []string
+		for _, tip := range tips {
+			args = append(args, tip)
+			params = append(params, fmt.Sprintf("$%d", len(args)))
+		}
+		query += ` AND branch_tip NOT IN (` + strings.Join(params, ", ") + `)`
+	}
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *sqlStore) dependencies(ctx context.Context, repoID int64, mergeBase sha) (map[string][]sha, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT sha, depends_on FROM commit_dependencies WHERE repo_id = $1 AND merge_base = $2`,
+		repoID, mergeBase)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deps := map[string][]sha{}
+	for rows.Next() {
+		var c sha
+		var dependsOn string
+		if err := rows.Scan(&c, &dependsOn); err != nil {
+			return nil, err
+		}
+		deps[string(c)], err = parseSHAList(dependsOn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deps, rows.Err()
+}
+
+func (s *sqlStore) putDependencies(ctx context.Context, repoID int64, mergeBase, c sha, deps []sha) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO commit_dependencies (repo_id, merge_base, sha, depends_on)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (repo_id, merge_base, sha) DO UPDATE SET depends_on = excluded.depends_on`,
+		repoID, mergeBase, c, formatSHAList(deps))
+	return err
+}
+
+func (s *sqlStore) cherryResult(ctx context.Context, repoID int64, branch string) (cherryResult, bool, error) {
+	var r cherryResult
+	var unmatched string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT branch_tip, master_tip, unmatched FROM cherry_results WHERE repo_id = $1 AND branch = $2`,
+		repoID, branch).Scan(&r.branchTip, &r.masterTip, &unmatched)
+	if err == sql.ErrNoRows {
+		return cherryResult{}, false, nil
+	} else if err != nil {
+		return cherryResult{}, false, err
+	}
+	r.unmatched, err = parseSHAList(unmatched)
+	if err != nil {
+		return cherryResult{}, false, err
+	}
+	return r, true, nil
+}
+
+func (s *sqlStore) putCherryResult(ctx context.Context, repoID int64, branch string, r cherryResult) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO cherry_results (repo_id, branch, branch_tip, master_tip, unmatched)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (repo_id, branch) DO UPDATE SET
+			branch_tip = excluded.branch_tip, master_tip = excluded.master_tip, unmatched = excluded.unmatched`,
+		repoID, branch, r.branchTip, r.masterTip, formatSHAList(r.unmatched))
+	return err
+}
+
+func (s *sqlStore) components(ctx context.Context, repoID int64) ([]component, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT name, globs FROM components WHERE repo_id = $1 ORDER BY name`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []component
+	for rows.Next() {
+		var c component
+		var globs string
+		if err := rows.Scan(&c.Name, &globs); err != nil {
+			return nil, err
+		}
+		c.Globs = parseGlobs(globs)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) putComponent(ctx context.Context, repoID int64, c component) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO components (repo_id, name, globs) VALUES ($1, $2, $3)
+		ON CONFLICT (repo_id, name) DO UPDATE SET globs = excluded.globs`,
+		repoID, c.Name, encodeGlobs(c.Globs))
+	return err
+}
+
+func (s *sqlStore) deleteComponent(ctx context.Context, repoID int64, name string) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM components WHERE repo_id = $1 AND name = $2`, repoID, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("no component named %q", name)
+	}
+	return nil
+}
+
+func (s *sqlStore) notifications(ctx context.Context, repoID int64) (map[notificationKey]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT channel, recipient, message_id, branch, sent_at FROM notifications WHERE repo_id = $1`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sent := map[notificationKey]time.Time{}
+	for rows.Next() {
+		var k notificationKey
+		var sentAt time.Time
+		if err := rows.Scan(&k.channel, &k.recipient, &k.messageID, &k.branch, &sentAt); err != nil {
+			return nil, err
+		}
+		sent[k] = sentAt
+	}
+	return sent, rows.Err()
+}
+
+func (s *sqlStore) putNotifications(ctx context.Context, repoID int64, keys []notificationKey, sentAt time.Time) error {
+	return s.db.dialect.executeTx(ctx, s.db.DB, func(tx *sql.Tx) error {
+		for _, k := range keys {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO notifications (repo_id, channel, recipient, message_id, branch, sent_at)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (repo_id, channel, recipient, message_id, branch) DO UPDATE SET sent_at = excluded.sent_at`,
+				repoID, k.channel, k.recipient, []byte(k.messageID), k.branch, sentAt,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) webhooks(ctx context.Context) ([]webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT url, secret_env, created_at FROM webhooks ORDER BY url`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []webhook
+	for rows.Next() {
+		var w webhook
+		if err := rows.Scan(&w.URL, &w.SecretEnv, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) putWebhook(ctx context.Context, w webhook) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO webhooks (url, secret_env, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (url) DO UPDATE SET secret_env = excluded.secret_env`,
+		w.URL, w.SecretEnv, w.CreatedAt)
+	return err
+}
+
+func (s *sqlStore) deleteWebhook(ctx context.Context, url string) error {
+	return s.db.dialect.executeTx(ctx, s.db.DB, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE url = $1)`, url,
+		); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE url = $1`, url)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("no webhook with URL %q", url)
+		}
+		return nil
+	})
+}
+
+const deliveryColumns = `d.id, d.created_at, d.attempts, d.last_attempt_at, d.last_error, d.delivered_at, d.next_attempt_at,
+	w.url, w.secret_env, w.created_at,
+	e.id, e.created_at, e.repo_id, e.branch, e.actor, e.kind, e.message_id, e.sha, e.pr_number, e.detail`
+
+const deliveryJoins = `webhook_deliveries AS d
+	JOIN webhooks AS w ON w.id = d.webhook_id
+	JOIN events AS e ON e.id = d.event_id`
+
+func (s *sqlStore) dueDeliveries(ctx context.Context, now time.Time, limit int) ([]webhookDelivery, error) {
+	return s.queryDeliveries(ctx,
+		`SELECT `+deliveryColumns+` FROM `+deliveryJoins+`
+		WHERE d.next_attempt_at <= $1
+		ORDER BY d.next_attempt_at, d.id LIMIT $2`,
+		now.UTC(), limit)
+}
+
+func (s *sqlStore) deliveries(ctx context.Context, limit int) ([]webhookDelivery, error) {
+	return s.queryDeliveries(ctx,
+		`SELECT `+deliveryColumns+` FROM `+deliveryJoins+`
+		ORDER BY d.id DESC LIMIT $1`,
+		limit)
+}
+
+func (s *sqlStore) queryDeliveries(ctx context.Context, query string, args ...interface{}) ([]webhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		var messageID []byte
+		if err := rows.Scan(&d.ID, &d.CreatedAt, &d.Attempts, &d.LastAttemptAt, &d.LastError,
+			&d.DeliveredAt, &d.NextAttemptAt,
+			&d.Webhook.URL, &d.Webhook.SecretEnv, &d.Webhook.CreatedAt,
+			&d.Event.ID, &d.Event.CreatedAt, &d.Event.RepoID, &d.Event.Branch, &d.Event.Actor, &d.Event.Kind,
+			&messageID, &d.Event.SHA, &d.Event.PRNumber, &d.Event.Detail); err != nil {
+			return nil, err
+		}
+		d.Event.MessageID = string(messageID)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) putDeliveryAttempt(ctx context.Context, d webhookDelivery) error {
+	utc := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		u := t.UTC()
+		return &u
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET
+			attempts = $1, last_attempt_at = $2, last_error = $3, delivered_at = $4, next_attempt_at = $5
+		WHERE id = $6`,
+		d.Attempts, d.LastAttemptAt, d.LastError, d.DeliveredAt, utc(d.NextAttemptAt), d.ID)
+	return err
+}
+
+func (s *sqlStore) commitStatuses(ctx context.Context, repoID int64) (map[string]commitStatus, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT sha, state, description FROM commit_statuses WHERE repo_id = $1`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	statuses := map[string]commitStatus{}
+	for rows.Next() {
+		var sha []byte
+		var cs commitStatus
+		if err := rows.Scan(&sha, &cs.State, &cs.Description); err != nil {
+			return nil, err
+		}
+		statuses[string(sha)] = cs
+	}
+	return statuses, rows.Err()
+}
+
+func (s *sqlStore) putCommitStatus(ctx context.Context, repoID int64, sha string, cs commitStatus, postedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO commit_statuses (repo_id, sha, state, description, posted_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (repo_id, sha) DO UPDATE SET
+			state = excluded.state, description = excluded.description, posted_at = excluded.posted_at`,
+		repoID, []byte(sha), cs.State, cs.Description, postedAt)
+	return err
+}
@@ -4,71 +4,23 @@ import (
 	"bufio"
 	"context"
 	"crypto/sha1"
-	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/cockroachdb/cockroach-go/crdb"
 	"github.com/google/go-github/github"
 	"github.com/lib/pq"
 )
 
-const schema = `
-CREATE TABLE IF NOT EXISTS repos (
-	id serial PRIMARY KEY,
-	github_owner string NOT NULL,
-	github_repo string NOT NULL,
-	UNIQUE (github_owner, github_repo)
-);
-
-CREATE TABLE IF NOT EXISTS prs (
-	id int PRIMARY KEY,
-	repo_id int REFERENCES repos,
-	number int,
-	title string,
-	body string,
-	open bool,
-	merged_at timestamptz,
-	base_sha bytes,
-	base_branch string,
-	author_username string,
-	updated_at timestamptz,
-	UNIQUE (repo_id, number)
-);
-
-CREATE TABLE IF NOT EXISTS pr_commits (
-	pr_id int REFERENCES prs,
-	sha bytes,
-	title string,
-	body string,
-	message_id bytes,
-	author_email string,
-	ordering int,
-	PRIMARY KEY (pr_id, sha)
-);
-
-CREATE TABLE IF NOT EXISTS exclusions (
-	message_id bytes PRIMARY KEY
-);
-
-CREATE TABLE IF NOT EXISTS commit_comments (
-	message_id bytes,
-	created_at timestamptz,
-	sha bytes,
-	user_email string,
-	body string,
-	PRIMARY KEY (message_id, created_at)
-);`
-
 // TODO(benesch): ewww
 var repoLock sync.RWMutex
 
@@ -76,26 +28,85 @@ type repo struct {
 	id          int64
 	githubOwner string
 	githubRepo  string
+	credentials credentials
 
+	// releaseBranches are ordered newest first, by version.
 	releaseBranches []string
 
 	masterCommits    commits
 	branchCommits    map[string]commits
 	branchMergeBases map[string]sha
+	branchTips       map[string]sha
+	// branchTags are the tags merged into each branch, oldest first, and
+	// firstTags the first of them to contain each commit that was
+	// backported to the branch, by branch and message ID.
+	branchTags map[string][]string
+	firstTags  map[string]map[string]string
+	// forwardPorts are the commits on each branch that have no counterpart
+	// on master, by branch.
+	forwardPorts map[string][]commit
 
 	masterPRs map[string]*pr            // by SHA
 	branchPRs map[string]map[string]*pr // by message ID
+
+	// branchGaps are the commits whose backports skip a release branch,
+	// before exclusions are taken into account; see findBranchGaps.
+	branchGaps []branchGap
+
+	// syncedEventID is the ID of the repo's newest event as of the last
+	// refresh, every event up to which the state above reflects.
+	syncedEventID int64
 }
 
+// cloneDir is the directory in which repos are mirrored, each at
+// <owner>/<name> within it.
+var cloneDir = "repos"
+
 func (r repo) path() string {
-	return filepath.Join("repos", r.githubRepo)
+	return filepath.Join(cloneDir, r.githubOwner, r.githubRepo)
+}
+
+// legacyPath is where r was mirrored before mirrors were namespaced by
+// owner, which let repos with the same name share one.
+func (r repo) legacyPath() string {
+	return filepath.Join(cloneDir, r.githubRepo)
+}
+
+func (r repo) creds() credentials {
+	if r.credentials == nil {
+		return noCredentials{}
+	}
+	return r.credentials
 }
 
 func (r repo) url() string {
-	return "https://github.com/" + path.Join(r.githubOwner, r.githubRepo) + ".git"
+	return r.creds().url(r.githubOwner, r.githubRepo)
 }
 
-func (r *repo) refresh(db *sql.DB) error {
+// spawnRemote runs a git command that talks to r's remote, authenticating
+// with r's credentials.
+func (r repo) spawnRemote(args ...string) error {
+	creds := r.creds()
+	env, err := creds.env()
+	if err != nil {
+		return fmt.Errorf("credentials for %s: %s", r, err)
+	}
+	// Never hang waiting for a password on a terminal that isn't there.
+	env = append(env, "GIT_TERMINAL_PROMPT=0")
+	gitArgs := append([]string{"git"}, creds.args()...)
+	return spawnEnv(env, append(gitArgs, args...)...)
+}
+
+func (r *repo) refresh(ctx context.Context, st store) error {
+	evs, err := st.events(ctx, eventFilter{repoID: r.id}, 1)
+	if err != nil {
+		return err
+	}
+	r.syncedEventID = 0
+	if len(evs) > 0 {
+		r.syncedEventID = evs[0].ID
+	}
+
 	cs, err := loadCommits(*r, "master")
 	if err != nil {
 		return err
@@ -103,6 +114,10 @@ func (r *repo) refresh(db *sql.DB) error {
 	r.masterCommits = cs
 	r.branchCommits = map[string]commits{}
 	r.branchMergeBases = map[string]sha{}
+	r.branchTips = map[string]sha{}
+	r.branchTags = map[string][]string{}
+	r.firstTags = map[string]map[string]string{}
+	r.forwardPorts = map[string][]commit{}
 	for _, branch := range r.releaseBranches {
 		cs, err = loadCommits(*r, branch, "^master")
 		if err != nil {
@@ -117,56 +132,85 @@ func (r *repo) refresh(db *sql.DB) error {
 		if err != nil {
 			return err
 		}
+		out, err = capture("git", "-C", r.path(), "rev-parse", branch)
+		if err != nil {
+			return err
+		}
+		r.branchTips[branch], err = parseSHA(out)
+		if err != nil {
+			return err
+		}
+		r.branchTags[branch], r.firstTags[branch], err = loadTags(*r, branch, cs)
+		if err != nil {
+			return err
+		}
+		r.forwardPorts[branch], err = loadForwardPorts(ctx, st, *r, branch, cs)
+		if err != nil {
+			return err
+		}
+	}
+	if err := r.loadCandidatePaths(); err != nil {
+		return err
 	}
 
 	// TODO(benesch): what if multiple PRs have the same commit?
 
-	r.masterPRs = map[string]*pr{}
-	rows, err := db.Query(
-		`SELECT number, merged_at, sha
-		FROM pr_commits JOIN prs ON pr_commits.pr_id = prs.id
-		WHERE merged_at IS NOT NULL AND base_branch = 'master'`)
+	prCommits, err := st.livePRCommits(ctx, r.id)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var s string
-		pr := &pr{repo: r}
-		if err := rows.Scan(&pr.number, &pr.mergedAt, &s); err != nil {
-			return err
+	r.masterPRs = map[string]*pr{}
+	r.branchPRs = map[string]map[string]*pr{}
+	for _, c := range prCommits {
+		p := &pr{repo: r, number: c.number, mergedAt: c.mergedAt}
+		if c.mergedAt.Valid && c.baseBranch == "master" {
+			r.masterPRs[string(c.sha)] = p
 		}
-		r.masterPRs[s] = pr
-	}
-	if err := rows.Err(); err != nil {
-		return err
+		if r.branchPRs[c.messageID] == nil {
+			r.branchPRs[c.messageID] = map[string]*pr{}
+		}
+		r.branchPRs[c.messageID][c.baseBranch] = p
 	}
+	r.branchGaps = r.findUnexcludedBranchGaps()
+	return nil
+}
 
-	r.branchPRs = map[string]map[string]*pr{}
-	rows, err = db.Query(
-		`SELECT number, merged_at, message_id, base_branch
-		FROM pr_commits JOIN prs ON pr_commits.pr_id = prs.id
-		WHERE merged_at IS NOT NULL OR open`)
-	if err != nil {
-		return err
+// loadCandidatePaths loads the paths changed by the master commits that are
+// candidates for backporting to any release branch: those after the oldest
+// merge base. Older commits are never filtered by path, so their paths are
+// left unloaded.
+func (r *repo) loadCandidatePaths() error {
+	if len(r.releaseBranches) == 0 {
+		return nil
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var messageID string
-		var baseBranch string
-		p := &pr{repo: r}
-		if err := rows.Scan(&p.number, &p.mergedAt, &messageID, &baseBranch); err != nil {
-			return err
+	index := map[string]int{}
+	for i, c := range r.masterCommits.commits {
+		index[string(c.sha)] = i
+	}
+	constraints := []string{"master"}
+	oldest := -1
+	for _, mergeBase := range r.branchMergeBases {
+		i, ok := index[string(mergeBase)]
+		if !ok {
+			// Merge bases are ancestors of master, so this can't happen;
+			// but if it did, no bound would be safe.
+			oldest = len(r.masterCommits.commits)
+			break
 		}
-		if r.branchPRs[messageID] == nil {
-			r.branchPRs[messageID] = map[string]*pr{}
+		if i > oldest {
+			oldest = i
 		}
-		r.branchPRs[messageID][baseBranch] = p
 	}
-	if err := rows.Err(); err != nil {
+	if oldest < len(r.masterCommits.commits) {
+		constraints = append(constraints, "^"+r.masterCommits.commits[oldest].sha.String())
+	}
+	paths, err := loadCommitPaths(*r, constraints...)
+	if err != nil {
 		return err
 	}
-
+	for i := range r.masterCommits.commits {
+		r.masterCommits.commits[i].paths = paths[string(r.masterCommits.commits[i].sha)]
+	}
 	return nil
 }
 
@@ -196,6 +240,17 @@ func (cs *commits) insert(c commit) {
 	cs.messageIDs[c.MessageID()] = struct{}{}
 }
 
+func (cs commits) find(sha sha) (commit, bool) {
+	if _, ok := cs.shas[string(sha)]; ok {
+		for _, c := range cs.commits {
+			if string(c.sha) == string(sha) {
+				return c, true
+			}
+		}
+	}
+	return commit{}, false
+}
+
 func (cs commits) subtract(cs0 commits) []commit {
 	var out []commit
 	for _, c := range cs.commits {
@@ -238,6 +293,10 @@ type commit struct {
 	title      string
 	body       string
 	merge      bool
+	// paths are the files the commit changed. Merge commits have none, and
+	// they are only loaded for master commits that are candidates for
+	// backporting.
+	paths []string
 }
 
 func (c commit) SHA() sha {
@@ -248,10 +307,12 @@ func (c commit) Title() string {
 	return c.title
 }
 
+// MessageID identifies the commit's change across cherry-picks. It hashes
+// only the title: cherry-picking with -x appends to the body, and message IDs
+// stored before bodies were loaded were computed with empty bodies.
 func (c commit) MessageID() string {
 	h := sha1.New()
 	io.WriteString(h, c.title)
-	io.WriteString(h, c.body)
 	return string(h.Sum(nil))
 }
 
@@ -264,10 +325,30 @@ type acommit struct {
 	MasterPRRowSpan   int
 	BackportPR        *pr
 	BackportPRRowSpan int
-}
-
-const commitFormat = "%H%x00%s%x00%cI%x00%aE%x00%P"
-
+	Exclusion         *exclusion
+	Comments          []comment
+	// Prediction is the predicted outcome of cherry-picking the commit onto
+	// the branch, if it is backportable and has been predicted.
+	Prediction *prediction
+	// Prerequisites are the commits that the commit depends on that have
+	// yet to land on the branch, if the commit itself has yet to land.
+	Prerequisites []commit
+	// Components are the names of the repo's components the commit touches.
+	Components []string
+	// Tag is the first tag on the branch to contain the commit, if it has
+	// been backported and released.
+	Tag string
+	// Gaps are the release branches that lack the commit even though it was
+	// backported to an older release branch.
+	Gaps []string
+}
+
+// commitFormat prints a commit as a record separator followed by
+// NUL-terminated fields. The body comes last, as it may span several lines.
+const commitFormat = "%x1e%H%x00%s%x00%cI%x00%aE%x00%P%x00%b%x00"
+
+// loadCommits loads the commits selected by constraints, without their
+// paths, which are costly to compute; see loadCommitPaths.
 func loadCommits(re repo, constraints ...string) (cs commits, err error) {
 	args := []string{
 		"git", "-C", re.path(), "log", "--topo-order", "--format=format:" + commitFormat,
@@ -278,9 +359,14 @@ func loadCommits(re repo, constraints ...string) (cs commits, err error) {
 		return commits{}, err
 	}
 	// TODO(benesch): stream this?
-	scanner := bufio.NewScanner(strings.NewReader(out))
-	for scanner.Scan() {
-		fields := strings.Split(scanner.Text(), "\x00")
+	for _, record := range strings.Split(out, "\x1e") {
+		if record == "" {
+			continue
+		}
+		fields := strings.Split(record, "\x00")
+		if len(fields) != 7 {
+			return commits{}, fmt.Errorf("malformed git log record %q", record)
+		}
 		sha, err := parseSHA(fields[0])
 		if err != nil {
 			return commits{}, err
@@ -290,15 +376,48 @@ func loadCommits(re repo, constraints ...string) (cs commits, err error) {
 			return commits{}, err
 		}
 		authorEmail := fields[3]
-		cs.insert(commit{
+		c := commit{
 			sha:        sha,
 			CommitDate: commitDate,
 			Author:     user{authorEmail},
 			title:      fields[1],
+			body:       strings.TrimSpace(fields[5]),
 			merge:      strings.Count(fields[4], " ") > 0,
-		})
+		}
+		cs.insert(c)
 	}
-	return cs, err
+	return cs, nil
+}
+
+// loadCommitPaths returns the paths changed by each of the commits selected
+// by constraints, by SHA. Renames are listed as a deletion and an addition,
+// so that both paths are known.
+func loadCommitPaths(re repo, constraints ...string) (map[string][]string, error) {
+	args := []string{
+		"git", "-C", re.path(), "-c", "core.quotePath=false", "log",
+		"--name-only", "--no-renames", "--format=format:%x1e%H",
+	}
+	out, err := capture(append(args, constraints...)...)
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string][]string{}
+	for _, record := range strings.Split(out, "\x1e") {
+		lines := strings.Split(record, "\n")
+		if lines[0] == "" {
+			continue
+		}
+		sha, err := parseSHA(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range lines[1:] {
+			if path != "" {
+				paths[string(sha)] = append(paths[string(sha)], path)
+			}
+		}
+	}
+	return paths, nil
 }
 
 type sha []byte
@@ -314,6 +433,20 @@ func parseSHA(s string) (sha, error) {
 	return sha(shaBytes), nil
 }
 
+// Scan implements sql.Scanner. Unlike the default conversion for byte slices,
+// it copies the scanned bytes, which the driver may reuse.
+func (s *sha) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*s = nil
+	case []byte:
+		*s = append(sha(nil), src...)
+	default:
+		return fmt.Errorf("cannot scan %T into sha", src)
+	}
+	return nil
+}
+
 func (s sha) Short() string {
 	return s.String()[0:9]
 }
@@ -354,21 +487,77 @@ func (p *pr) MergedAt() string {
 	return "(unknown)"
 }
 
-func syncAll(ctx context.Context, ghClient *github.Client, db *sql.DB) error {
+func syncAll(ctx context.Context, ghClient *github.Client, st store) error {
 	for i := range repos {
-		if err := syncRepo(ctx, ghClient, db, &repos[i]); err != nil {
+		if err := syncRepo(ctx, ghClient, st, &repos[i]); err != nil {
 			return err
 		}
+		repoLock.RLock()
+		re := repos[i]
+		repoLock.RUnlock()
+		analyzeRepo(ctx, st, re)
 	}
 	return nil
 }
 
-func syncRepo(ctx context.Context, ghClient *github.Client, db *sql.DB, repo *repo) error {
+// analyzeRepo predicts conflicts and analyzes dependencies for the commits of
+// re, a synced repo, and updates its outstanding backport gauges. The
+// analyses can take many git processes after a release branch moves, so
+// they run once re is published and outside its sync lock, and the board
+// picks up their results from the store as they land. A failed analysis
+// leaves the board without hints, which is no reason to fail the sync.
+func analyzeRepo(ctx context.Context, st store, re repo) {
+	if err := predictConflicts(ctx, st, re); err != nil {
+		log.Printf("predicting conflicts in %s: %s", re, err)
+	}
+	if err := analyzeDependencies(ctx, st, re); err != nil {
+		log.Printf("analyzing dependencies in %s: %s", re, err)
+	}
+	if err := updateOutstandingBackports(ctx, st, re); err != nil {
+		log.Printf("counting outstanding backports in %s: %s", re, err)
+	}
+}
+
+// syncLocks serialize the syncs of each repo, by ID. A sync fetches into the
+// repo's mirror and replaces the repo's entry in repos, so it must not race
+// with another sync of the same repo, like syncOnePR's.
+var syncLocks struct {
+	sync.Mutex
+	m map[int64]*sync.Mutex
+}
+
+// lockRepoSync locks the syncs of the repo with the given ID, returning a
+// function that unlocks them.
+func lockRepoSync(repoID int64) (unlock func()) {
+	syncLocks.Lock()
+	if syncLocks.m == nil {
+		syncLocks.m = map[int64]*sync.Mutex{}
+	}
+	mu := syncLocks.m[repoID]
+	if mu == nil {
+		mu = &sync.Mutex{}
+		syncLocks.m[repoID] = mu
+	}
+	syncLocks.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// syncRepo fetches repo and syncs its PRs, then refreshes it. It holds the
+// repo's sync lock throughout, so that it may read *repo without holding
+// repoLock: only syncs of the repo replace it.
+func syncRepo(ctx context.Context, ghClient *github.Client, st store, repo *repo) error {
+	repoLock.RLock()
+	repoID := repo.id
+	repoLock.RUnlock()
+	defer lockRepoSync(repoID)()
 	log.Printf("syncing %s", repo)
 	defer log.Printf("done syncing %s", repo)
-	if err := spawn("git", "-C", repo.path(), "fetch"); err != nil {
+	start := time.Now()
+	if err := repo.spawnRemote("-C", repo.path(), "fetch"); err != nil {
 		return err
 	}
+	gitFetchDuration.WithLabelValues(repo.String()).Observe(time.Since(start).Seconds())
 
 	opts := &github.PullRequestListOptions{
 		State:       "all",
@@ -389,7 +578,7 @@ func syncRepo(ctx context.Context, ghClient *github.Client, db *sql.DB, repo *re
 			break
 		}
 		lastPR := prs[len(prs)-1]
-		if ok, err := isPRUpToDate(ctx, db, lastPR); err != nil {
+		if ok, err := isPRUpToDate(ctx, st, lastPR); err != nil {
 			return err
 		} else if ok {
 			break
@@ -399,43 +588,42 @@ func syncRepo(ctx context.Context, ghClient *github.Client, db *sql.DB, repo *re
 
 	// process updates from least to most recent
 	for i := len(allPRs) - 1; i >= 0; i-- {
-		if err := syncPR(ctx, db, repo, allPRs[i]); err != nil {
+		if err := syncPR(ctx, st, repo, allPRs[i]); err != nil {
 			if allPRs[i].MergedAt == nil && allPRs[i].GetState() == "closed" {
 				log.Printf("ignoring error while syncing closed, unmerged pr %d: %s",
 					allPRs[i].GetNumber(), err)
 			} else {
 				return err
 			}
+		} else {
+			prsSynced.WithLabelValues(repo.String()).Inc()
 		}
 	}
 
 	repoCopy := *repo
-	if err := repoCopy.refresh(db); err != nil {
+	refreshStart := time.Now()
+	if err := repoCopy.refresh(ctx, st); err != nil {
 		return err
 	}
+	refreshDuration.WithLabelValues(repo.String()).Observe(time.Since(refreshStart).Seconds())
 
 	repoLock.Lock()
 	*repo = repoCopy
 	repoLock.Unlock()
+	syncDuration.WithLabelValues(repo.String()).Observe(time.Since(start).Seconds())
+	syncLastSuccess.WithLabelValues(repo.String()).SetToCurrentTime()
 	return nil
 }
 
-type queryer interface {
-	QueryRow(query string, args ...interface{}) *sql.Row
-}
-
-func isPRUpToDate(ctx context.Context, q queryer, pr *github.PullRequest) (bool, error) {
-	var updatedAt time.Time
-	err := q.QueryRow(`SELECT updated_at FROM prs WHERE id = $1`, pr.GetID()).Scan(&updatedAt)
-	if err == sql.ErrNoRows {
-		return false, nil
-	} else if err != nil {
+func isPRUpToDate(ctx context.Context, st store, pr *github.PullRequest) (bool, error) {
+	updatedAt, ok, err := st.prUpdatedAt(ctx, pr.GetID())
+	if err != nil || !ok {
 		return false, err
 	}
 	return updatedAt.Equal(pr.GetUpdatedAt()), nil
 }
 
-func syncPR(ctx context.Context, db *sql.DB, repo *repo, pr *github.PullRequest) error {
+func syncPR(ctx context.Context, st store, repo *repo, pr *github.PullRequest) error {
 	log.Printf("pr: %d", pr.GetNumber())
 
 	prBase := pr.GetBase().GetSHA()
@@ -444,72 +632,115 @@ func syncPR(ctx context.Context, db *sql.DB, repo *repo, pr *github.PullRequest)
 	if err != nil {
 		return err
 	}
+	var labels []string
+	for _, l := range pr.Labels {
+		labels = append(labels, l.GetName())
+	}
 
-	return crdb.ExecuteTx(ctx, db, nil /* txopts */, func(tx *sql.Tx) error {
-		if ok, err := isPRUpToDate(ctx, tx, pr); err != nil {
-			return err
-		} else if ok {
-			return nil
-		}
-		if _, err := tx.Exec(
-			`UPSERT INTO prs (id, repo_id, number, title, body, open, merged_at, base_sha, base_branch, author_username, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
-			pr.GetID(), repo.id, pr.GetNumber(),
-			pr.GetTitle(), pr.GetBody(),
-			pr.GetState() == "open", pr.MergedAt,
-			pr.GetBase().GetSHA(), pr.GetBase().GetRef(),
-			pr.GetUser().GetLogin(), pr.GetUpdatedAt(),
-		); err != nil {
-			return err
-		}
-		if _, err := tx.Exec(`DELETE FROM pr_commits WHERE pr_id = $1`, pr.GetID()); err != nil {
-			return err
+	return st.putPR(ctx, prRecord{
+		id:             pr.GetID(),
+		repoID:         repo.id,
+		number:         pr.GetNumber(),
+		title:          pr.GetTitle(),
+		body:           pr.GetBody(),
+		open:           pr.GetState() == "open",
+		mergedAt:       pr.MergedAt,
+		baseSHA:        pr.GetBase().GetSHA(),
+		baseBranch:     pr.GetBase().GetRef(),
+		headSHA:        pr.GetHead().GetSHA(),
+		authorUsername: pr.GetUser().GetLogin(),
+		updatedAt:      pr.GetUpdatedAt(),
+		labels:         labels,
+	}, commits.commits, func(prev *prState) []event {
+		return prTransitions(repo.id, prev, pr)
+	})
+}
+
+// loadRepos replaces repos with the repos tracked in st. If only is
+// nonempty, just the repo named by the "owner/name" string only is loaded.
+func loadRepos(ctx context.Context, st store, only string) error {
+	records, err := st.trackedRepos(ctx)
+	if err != nil {
+		return err
+	}
+	var loaded []repo
+	for _, r := range records {
+		if only != "" && only != r.owner+"/"+r.name {
+			continue
 		}
-		for i, c := range commits.commits {
-			if _, err := tx.Exec(
-				`INSERT INTO pr_commits (pr_id, sha, title, body, message_id, author_email, ordering)
-				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-				pr.GetID(),
-				c.sha,
-				c.title,
-				c.body,
-				c.MessageID(),
-				c.Author.Email,
-				i,
-			); err != nil {
-				return err
-			}
+		creds, err := parseCredentials(r.credentials)
+		if err != nil {
+			return fmt.Errorf("repo %s/%s: %s", r.owner, r.name, err)
 		}
-		return nil
-	})
+		loaded = append(loaded, repo{
+			id:          r.id,
+			githubOwner: r.owner,
+			githubRepo:  r.name,
+			credentials: creds,
+		})
+	}
+	if only != "" && len(loaded) == 0 {
+		return fmt.Errorf("repo %s is not tracked", only)
+	} else if len(loaded) == 0 {
+		return errors.New("no repos are tracked; add one with \"backboard repos add\"")
+	}
+	repoLock.Lock()
+	repos = loaded
+	repoLock.Unlock()
+	return nil
 }
 
-func bootstrap(ctx context.Context, db *sql.DB) error {
-	if _, err := db.Exec(schema); err != nil {
+// adoptLegacyMirror moves r's mirror from its legacy path to its current one,
+// if it is found there, to save cloning it again. A mirror at the legacy
+// path is only adopted if its remote is r, since another repo of the same
+// name may have claimed it.
+func adoptLegacyMirror(r repo) error {
+	legacy := r.legacyPath()
+	if _, err := os.Stat(r.path()); !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "HEAD")); err != nil {
+		return nil
+	}
+	remote, err := capture("git", "-C", legacy, "config", "remote.origin.url")
+	if err != nil {
+		return nil
+	}
+	remote = strings.TrimSuffix(strings.TrimSpace(remote), ".git")
+	if !strings.HasSuffix(remote, "/"+r.githubOwner+"/"+r.githubRepo) &&
+		!strings.HasSuffix(remote, ":"+r.githubOwner+"/"+r.githubRepo) {
+		return nil
+	}
+	log.Printf("moving the mirror of %s from %s to %s", r, legacy, r.path())
+	if err := os.MkdirAll(filepath.Dir(r.path()), 0755); err != nil {
 		return err
 	}
+	return os.Rename(legacy, r.path())
+}
+
+func bootstrap(ctx context.Context, st store) error {
 	for i := range repos {
-		var id int64
-		if err := db.QueryRowContext(
-			ctx,
-			`INSERT INTO repos (github_owner, github_repo)
-			VALUES ($1, $2)
-			ON CONFLICT (github_owner, github_repo) DO UPDATE SET github_owner = excluded.github_owner
-			RETURNING id`,
-			repos[i].githubOwner, repos[i].githubRepo,
-		).Scan(&id); err != nil {
+		id, err := st.ensureRepo(ctx, repos[i].githubOwner, repos[i].githubRepo)
+		if err != nil {
 			return err
 		}
 		repos[i].id = id
 
 		url, path := repos[i].url(), repos[i].path()
+		if err := adoptLegacyMirror(repos[i]); err != nil {
+			return err
+		}
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			log.Printf("cloning %s into %s", repos[i], path)
-			if err := spawn("git", "clone", "--mirror", url, path); err != nil {
+			if err := repos[i].spawnRemote("clone", "--mirror", url, path); err != nil {
 				return err
 			}
 		} else if err != nil {
 			return err
+		} else if _, err := capture("git", "-C", path, "remote", "set-url", "origin", url); err != nil {
+			// The credential strategy may have changed since the mirror was
+			// cloned, e.g. from https to ssh.
+			return err
 		}
 
 		out, err := capture("git", "-C", path, "branch", "--list", "release-*")
@@ -523,11 +754,39 @@ func bootstrap(ctx context.Context, db *sql.DB) error {
 		if err := scanner.Err(); err != nil {
 			return err
 		}
-		sort.Sort(sort.Reverse(sort.StringSlice(repos[i].releaseBranches)))
+		sortReleaseBranches(repos[i].releaseBranches)
 
-		if err := repos[i].refresh(db); err != nil {
+		if err := repos[i].refresh(ctx, st); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// sortReleaseBranches sorts release branches newest first, by the version
+// that follows "release-". Versions are compared component by component,
+// numerically where both components are numbers, so that release-20.1 sorts
+// before release-19.2, which sorts before release-2.1.
+func sortReleaseBranches(branches []string) {
+	split := func(branch string) []string {
+		return strings.Split(strings.TrimPrefix(branch, "release-"), ".")
+	}
+	newer := func(a, b string) bool {
+		as, bs := split(a), split(b)
+		for i := 0; i < len(as) && i < len(bs); i++ {
+			an, aErr := strconv.Atoi(as[i])
+			bn, bErr := strconv.Atoi(bs[i])
+			switch {
+			case aErr == nil && bErr == nil && an != bn:
+				return an > bn
+			case (aErr != nil || bErr != nil) && as[i] != bs[i]:
+				return as[i] > bs[i]
+			}
+		}
+		if len(as) != len(bs) {
+			return len(as) > len(bs)
+		}
+		return a > b
+	}
+	sort.Slice(branches, func(i, j int) bool { return newer(branches[i], branches[j]) })
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMirrorPaths(t *testing.T) {
+	oldCloneDir := cloneDir
+	t.Cleanup(func() { cloneDir = oldCloneDir })
+	cloneDir = tempDir(t)
+
+	acme := repo{githubOwner: "acme", githubRepo: "widget"}
+	other := repo{githubOwner: "other", githubRepo: "widget"}
+	if acme.path() == other.path() {
+		t.Fatalf("expected repos with the same name to have distinct mirrors, both got %s", acme.path())
+	}
+
+	// A mirror at the legacy path is adopted by the repo it mirrors, and
+	// only by that repo.
+	legacy := acme.legacyPath()
+	if _, err := capture("git", "init", "-q", "--bare", legacy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := capture("git", "-C", legacy, "remote", "add", "origin", "https://github.com/acme/widget.git"); err != nil {
+		t.Fatal(err)
+	}
+	if err := adoptLegacyMirror(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(other.path()); !os.IsNotExist(err) {
+		t.Errorf("expected other/widget not to adopt acme/widget's mirror, got %v", err)
+	}
+	if err := adoptLegacyMirror(acme); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(acme.path(), "HEAD")); err != nil {
+		t.Errorf("expected acme/widget to adopt its mirror: %s", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("expected the legacy mirror to be moved, got %v", err)
+	}
+}
+
+func TestSortReleaseBranches(t *testing.T) {
+	branches := []string{"release-2.0", "release-19.2", "release-2.1", "release-20.1", "release-2.1.1"}
+	sortReleaseBranches(branches)
+	expected := []string{"release-20.1", "release-19.2", "release-2.1.1", "release-2.1", "release-2.0"}
+	if !reflect.DeepEqual(branches, expected) {
+		t.Errorf("expected %q, got %q", expected, branches)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// missingBackportsError is returned by the status command when commits
+// remain to be backported, so that scripts can tell it apart from a failure
+// to compute the status.
+type missingBackportsError struct {
+	branch string
+	count  int
+}
+
+func (e missingBackportsError) Error() string {
+	return fmt.Sprintf("%d commits still need to be backported to %s", e.count, e.branch)
+}
+
+func setupStatus(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	cloneDirFlag(fs)
+	repoName := fs.String("repo", "", "`owner/name` of the repo (optional if only one repo is tracked)")
+	branchName := fs.String("branch", "", "release `branch` (default the newest)")
+	author := fs.String("author", "", "show only the commits by this `email`, or \"me\" for git's user.email")
+	missingOnly := fs.Bool("missing-only", false, "show only commits that still need to be backported")
+	format := fs.String("format", "table", "output `format`: table or json")
+	pathPrefix := fs.String("path", "", "show only commits that change files beneath this path `prefix`")
+	componentName := fs.String("component", "", "show only commits that change files in this `component`")
+	sinceLastTag := fs.Bool("since-last-tag", false, "show only commits that landed on the branch after its latest tag")
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("status", args, 0, 0); err != nil {
+			return err
+		}
+		if *format != "table" && *format != "json" {
+			return usageError{cmd: "status", msg: fmt.Sprintf("unknown format %q", *format)}
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		if err := loadRepos(ctx, st, *repoName); err != nil {
+			return err
+		}
+		if len(repos) > 1 {
+			return usageError{cmd: "status", msg: "several repos are tracked; choose one with --repo"}
+		}
+		if err := bootstrap(ctx, st); err != nil {
+			return fmt.Errorf("while bootstrapping: %s", err)
+		}
+		re := repos[0]
+		branch, err := findBranch(re, *branchName)
+		if err != nil {
+			return err
+		}
+
+		opts := boardOptions{branch: branch, author: *author, path: *pathPrefix, component: *componentName,
+			sinceLastTag: *sinceLastTag}
+		if *author == "me" {
+			email, err := capture("git", "config", "user.email")
+			if err != nil {
+				return fmt.Errorf("determining your email: %s", err)
+			}
+			opts.author = ""
+			opts.me = &identity{Emails: []string{strings.TrimSpace(email)}}
+		}
+		b, err := buildBoard(ctx, st, re, opts)
+		if err != nil {
+			return err
+		}
+		if opts.me != nil && b.Author == (user{}) {
+			// You have no commits on the board, so buildBoard fell back to
+			// showing everyone's.
+			b.Commits = nil
+		}
+
+		if err := printStatus(os.Stdout, b, *format, *missingOnly); err != nil {
+			return err
+		}
+		var missing int
+		for _, c := range b.Commits {
+			if c.Missing() {
+				missing++
+			}
+		}
+		if missing > 0 {
+			return missingBackportsError{branch: branch, count: missing}
+		}
+		return nil
+	}
+}
+
+// statusRow is the JSON representation of a commit on the board.
+type statusRow struct {
+	SHA             string `json:"sha"`
+	Title           string `json:"title"`
+	Author          string `json:"author"`
+	MasterPR        int    `json:"master_pr,omitempty"`
+	BackportPR      int    `json:"backport_pr,omitempty"`
+	Status          string `json:"status"`
+	ExclusionReason string `json:"exclusion_reason,omitempty"`
+	// Conflicts are the files predicted to conflict when the commit is
+	// cherry-picked.
+	Conflicts  []string `json:"conflicts,omitempty"`
+	Components []string `json:"components,omitempty"`
+	// Tag is the first tag to contain the backported commit.
+	Tag string `json:"tag,omitempty"`
+	// Gaps are the release branches that lack the commit even though it was
+	// backported to an older one.
+	Gaps []string `json:"gaps,omitempty"`
+}
+
+func newStatusRow(c acommit) statusRow {
+	row := statusRow{
+		SHA:        c.SHA().String(),
+		Title:      c.Title(),
+		Author:     c.Author.Email,
+		Components: c.Components,
+		Tag:        c.Tag,
+		Gaps:       c.Gaps,
+	}
+	if c.MasterPR != nil {
+		row.MasterPR = c.MasterPR.number
+	}
+	if c.BackportPR != nil {
+		row.BackportPR = c.BackportPR.number
+	}
+	if c.Prediction != nil {
+		row.Conflicts = c.Prediction.Conflicts
+	}
+	switch {
+	case c.Exclusion != nil:
+		row.Status = "excluded"
+		row.ExclusionReason = c.Exclusion.Reason
+	case c.BackportStatus == "✓":
+		row.Status = "backported"
+	case c.BackportStatus == "◷":
+		row.Status = "in progress"
+	default:
+		row.Status = "missing"
+	}
+	return row
+}
+
+// printStatus writes the commits on b to w in the given format.
+func printStatus(w io.Writer, b *board, format string, missingOnly bool) error {
+	var commits []acommit
+	for _, c := range b.Commits {
+		if !missingOnly || c.Missing() {
+			commits = append(commits, c)
+		}
+	}
+	switch format {
+	case "json":
+		rows := []statusRow{}
+		for _, c := range commits {
+			rows = append(rows, newStatusRow(c))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "SHA\tMASTER PR\tBACKPORT PR\tSTATUS\tAUTHOR\tTITLE\n")
+		for _, c := range commits {
+			r := newStatusRow(c)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.SHA().Short(), prNumberString(r.MasterPR),
+				prNumberString(r.BackportPR), r.Status, r.Author, r.Title)
+		}
+		return tw.Flush()
+	default:
+		return errors.New("unknown format " + format)
+	}
+}
+
+func prNumberString(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#%d", n)
+}
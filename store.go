@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// store persists backboard's state. The sync process and the web server talk
+// to the store exclusively through this interface, so that they can be
+// exercised against memStore in tests. sqlStore is the implementation used in
+// production.
+type store interface {
+	// ensureRepo returns the ID of the GitHub repository owner/name,
+	// registering it if necessary.
+	ensureRepo(ctx context.Context, owner, name string) (int64, error)
+	// putRepo starts tracking the GitHub repository described by r, or
+	// updates its configuration if it is already tracked.
+	putRepo(ctx context.Context, r repoRecord) error
+	// untrackRepo stops tracking the GitHub repository owner/name. Its
+	// history is retained, in case it is tracked again.
+	untrackRepo(ctx context.Context, owner, name string) error
+	// trackedRepos returns every tracked repo, sorted by owner and name.
+	trackedRepos(ctx context.Context) ([]repoRecord, error)
+
+	// prUpdatedAt returns the time at which the PR with the given GitHub ID
+	// was last updated, as of the last time it was synced. ok is false if the
+	// PR has never been synced.
+	prUpdatedAt(ctx context.Context, id int64) (updatedAt time.Time, ok bool, err error)
+	// putPR stores p and its commits, replacing any previous version, unless
+	// the stored version is already as up to date as p. The events returned
+	// by transitions, which is passed the state of the previous version (nil
+	// if there is none), are recorded atomically with p.
+	putPR(ctx context.Context, p prRecord, commits []commit, transitions func(prev *prState) []event) error
+	// livePRCommits returns the commits of every merged or open PR in the
+	// repo with ID repoID.
+	livePRCommits(ctx context.Context, repoID int64) ([]prCommit, error)
+	// prBodies returns the bodies of the PRs with the given numbers in the
+	// repo with ID repoID, by number.
+	prBodies(ctx context.Context, repoID int64, numbers []int) (map[int]string, error)
+	// mergedPRs returns the PRs in the repo with ID repoID that merged into
+	// baseBranch at or after since. Their commits are not filled in.
+	mergedPRs(ctx context.Context, repoID int64, baseBranch string, since time.Time) ([]prRecord, error)
+
+	// exclusions returns the exclusions for the given branch of the repo
+	// with ID repoID, by message ID.
+	exclusions(ctx context.Context, repoID int64, branch string) (map[string]exclusion, error)
+	// putExclusion and deleteExclusion add and remove the exclusion of the
+	// commit with the given message ID from a branch, recording ev
+	// atomically.
+	putExclusion(ctx context.Context, repoID int64, branch, messageID string, e exclusion, ev event) error
+	deleteExclusion(ctx context.Context, repoID int64, branch, messageID string, ev event) error
+
+	// comments returns the comments on the commits of the repo with ID
+	// repoID, by message ID, oldest first.
+	comments(ctx context.Context, repoID int64) (map[string][]comment, error)
+	// addComment adds a comment to the commit with the given message ID in
+	// the repo with ID repoID, recording ev atomically.
+	addComment(ctx context.Context, repoID int64, messageID string, c comment, ev event) error
+
+	// roles returns the roles assigned to username on the repo with ID
+	// repoID, by branch. The repo-wide role has an empty branch.
+	roles(ctx context.Context, repoID int64, username string) (map[string]role, error)
+	// roleGrants returns every role assigned on the repo with ID repoID,
+	// ordered by username and branch.
+	roleGrants(ctx context.Context, repoID int64) ([]roleGrant, error)
+	// grantRole assigns a role, recording ev atomically.
+	grantRole(ctx context.Context, repoID int64, branch, username string, r role, ev event) error
+
+	recordEvent(ctx context.Context, ev event) error
+	// events returns up to limit events matching f, newest first.
+	events(ctx context.Context, f eventFilter, limit int) ([]event, error)
+
+	// predictions returns the cached predictions of cherry-picking commits
+	// onto the release branch commit branchTip, by commit SHA.
+	predictions(ctx context.Context, repoID int64, branchTip sha) (map[string]prediction, error)
+	// putPrediction caches the prediction of cherry-picking c onto
+	// branchTip.
+	putPrediction(ctx context.Context, repoID int64, branchTip, c sha, p prediction) error
+	// prunePredictions deletes the cached predictions of the repo with ID
+	// repoID onto any branch tip other than those in tips.
+	prunePredictions(ctx context.Context, repoID int64, tips []sha) error
+
+	// dependencies returns the cached dependencies of master commits made
+	// since mergeBase, by commit SHA.
+	dependencies(ctx context.Context, repoID int64, mergeBase sha) (map[string][]sha, error)
+	// putDependencies caches the dependencies of c on commits made since
+	// mergeBase.
+	putDependencies(ctx context.Context, repoID int64, mergeBase, c sha, deps []sha) error
+
+	// cherryResult returns the cached result of matching the commits on
+	// branch with those on master, which is updated as either moves. ok is
+	// false if there is none cached.
+	cherryResult(ctx context.Context, repoID int64, branch string) (r cherryResult, ok bool, err error)
+	// putCherryResult caches r for branch, replacing any previous result.
+	putCherryResult(ctx context.Context, repoID int64, branch string, r cherryResult) error
+
+	// components returns the components of the repo with ID repoID, sorted
+	// by name.
+	components(ctx context.Context, repoID int64) ([]component, error)
+	// putComponent defines a component, replacing any component with the
+	// same name.
+	putComponent(ctx context.Context, repoID int64, c component) error
+	// deleteComponent removes the component with the given name.
+	deleteComponent(ctx context.Context, repoID int64, name string) error
+
+	// search returns up to limit commits of merged or open PRs in tracked
+	// repos that match q, newest PR first.
+	search(ctx context.Context, q searchQuery, limit int) ([]searchHit, error)
+
+	// notifications returns the time at which each notification about the
+	// repo with ID repoID was sent.
+	notifications(ctx context.Context, repoID int64) (map[notificationKey]time.Time, error)
+	// putNotifications records that the notifications keys were sent.
+	putNotifications(ctx context.Context, repoID int64, keys []notificationKey, sentAt time.Time) error
+
+	// webhooks returns the outgoing webhooks, sorted by URL. Every event
+	// recorded for which webhookEvent holds is queued for delivery to each
+	// of them, atomically with the event.
+	webhooks(ctx context.Context) ([]webhook, error)
+	// putWebhook adds an outgoing webhook, or updates the one with the same
+	// URL.
+	putWebhook(ctx context.Context, w webhook) error
+	// deleteWebhook removes the outgoing webhook with the given URL, along
+	// with its deliveries.
+	deleteWebhook(ctx context.Context, url string) error
+	// dueDeliveries returns up to limit undelivered webhook deliveries whose
+	// next attempt is due at now, longest due first, then oldest first.
+	dueDeliveries(ctx context.Context, now time.Time, limit int) ([]webhookDelivery, error)
+	// deliveries returns up to limit webhook deliveries, newest first.
+	deliveries(ctx context.Context, limit int) ([]webhookDelivery, error)
+	// putDeliveryAttempt records the outcome of an attempt to deliver d: its
+	// Attempts, LastAttemptAt, LastError, DeliveredAt and NextAttemptAt.
+	putDeliveryAttempt(ctx context.Context, d webhookDelivery) error
+
+	// commitStatuses returns the commit statuses last posted to commits in
+	// the repo with ID repoID, by hex SHA.
+	commitStatuses(ctx context.Context, repoID int64) (map[string]commitStatus, error)
+	// putCommitStatus records that s was posted to the commit with the
+	// given hex SHA.
+	putCommitStatus(ctx context.Context, repoID int64, sha string, s commitStatus, postedAt time.Time) error
+}
+
+const memoryConnString = "memory:"
+
+// openStore opens the store described by connString, which is either
+// "memory:", for a transient in-memory store, or anything accepted by
+// openDatabase. SQL databases are migrated to the latest schema. An in-memory
+// store lives only as long as the process, so it is of no use to commands,
+// which each run in a process of their own, and is not advertised by them.
+func openStore(ctx context.Context, connString string) (store, error) {
+	if connString == memoryConnString {
+		return newMemStore(), nil
+	}
+	db, err := openDatabase(ctx, connString)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(ctx, db); err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: db}, nil
+}
+
+// repoRecord is a repo's configuration as stored.
+type repoRecord struct {
+	id          int64
+	owner       string
+	name        string
+	credentials string
+}
+
+// prRecord is a PR as stored.
+type prRecord struct {
+	id             int64
+	repoID         int64
+	number         int
+	title          string
+	body           string
+	open           bool
+	mergedAt       *time.Time
+	baseSHA        string
+	baseBranch     string
+	headSHA        string
+	authorUsername string
+	updatedAt      time.Time
+	labels         []string
+}
+
+// prCommit is a commit of a PR along with the state of the PR.
+type prCommit struct {
+	number     int
+	open       bool
+	mergedAt   pq.NullTime
+	baseBranch string
+	sha        sha
+	messageID  string
+}
+
+// searchQuery is a parsed search of the synced PRs and their commits. A
+// commit matches if it meets any of the criteria that are set.
+type searchQuery struct {
+	// words must all appear in the title or body of the commit, or all in
+	// the title or body of its PR.
+	words []string
+	// shaLow and shaHigh bound the SHAs that begin with the hex prefix the
+	// query names, if any.
+	shaLow, shaHigh sha
+	// prNumber is the number of the PR the query names, if any.
+	prNumber int
+}
+
+// cherryResult is the outcome of matching the commits on a release branch
+// with those on master by patch ID, as git cherry does: the commits on the
+// branch at branchTip that have no counterpart on master at masterTip.
+type cherryResult struct {
+	branchTip sha
+	masterTip sha
+	unmatched []sha
+}
+
+// notificationKey identifies a notification sent through a channel to a
+// recipient about the backport of the commit with the given message ID to a
+// branch.
+type notificationKey struct {
+	channel   string
+	recipient string
+	messageID string
+	branch    string
+}
+
+// searchHit is a commit that matched a search.
+type searchHit struct {
+	prCommit
+	repoID  int64
+	prTitle string
+	title   string
+}
+
+// matchEventFilter reports whether ev matches f, given the numbers of the
+// PRs that contain f.messageID. It mirrors the query built by sqlStore.events.
+func matchEventFilter(ev event, f eventFilter, prNumbers map[int]bool) bool {
+	if f.repoID != 0 && ev.RepoID != f.repoID {
+		return false
+	}
+	if f.messageID != "" && ev.MessageID != f.messageID && !prNumbers[ev.PRNumber] {
+		return false
+	}
+	if f.before != 0 && ev.ID >= f.before {
+		return false
+	}
+	return true
+}
+
+// resolveRole picks the role that applies on branch from roles, as returned
+// by store.roles: a branch's role takes precedence over the repo-wide one.
+func resolveRole(roles map[string]role, branch string) (role, bool) {
+	if r, ok := roles[branch]; ok {
+		return r, true
+	}
+	r, ok := roles[""]
+	return r, ok
+}
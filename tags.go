@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+)
+
+// loadTags returns the tags of re that are merged into branch, oldest first,
+// along with the first of them to contain each of cs, the commits on branch
+// that aren't on master, by message ID.
+func loadTags(re repo, branch string, cs commits) (tags []string, firstTags map[string]string, err error) {
+	out, err := capture("git", "-C", re.path(), "for-each-ref", "--merged="+branch,
+		"--sort=creatordate", "--format=%(refname:short) %(objectname) %(*objectname)", "refs/tags")
+	if err != nil {
+		return nil, nil, err
+	}
+	// The commit each tag points at, peeling annotated tags.
+	targets := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		tags = append(tags, fields[0])
+		targets[fields[0]] = fields[len(fields)-1]
+	}
+
+	// Walk the branch's commits down from each tag in turn, in one pass:
+	// the commits a tag reaches that no older tag did are first contained
+	// by it. Every commit a tag contains that isn't on master is reached
+	// without leaving the branch's commits, as its descendants aren't on
+	// master either.
+	out, err = capture("git", "-C", re.path(), "log", "--format=%H %P", branch, "^master")
+	if err != nil {
+		return nil, nil, err
+	}
+	parents := map[string][]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			parents[fields[0]] = fields[1:]
+		}
+	}
+	messageIDs := map[string]string{}
+	for _, c := range cs.commits {
+		messageIDs[c.sha.String()] = c.MessageID()
+	}
+	firstTags = map[string]string{}
+	reached := map[string]bool{}
+	for _, tag := range tags {
+		stack := []string{targets[tag]}
+		for len(stack) > 0 {
+			s := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if _, onBranch := parents[s]; !onBranch || reached[s] {
+				continue
+			}
+			reached[s] = true
+			if id, ok := messageIDs[s]; ok {
+				firstTags[id] = tag
+			}
+			stack = append(stack, parents[s]...)
+		}
+	}
+	return tags, firstTags, nil
+}
+
+// latestTag returns the newest tag merged into branch, if any.
+func (r repo) latestTag(branch string) string {
+	tags := r.branchTags[branch]
+	if len(tags) == 0 {
+		return ""
+	}
+	return tags[len(tags)-1]
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	e := newTestEnv(t, newMemStore())
+	setupBackports(e)
+	e.sync()
+	var c acommit
+	for _, ac := range e.board(boardOptions{branch: "release-1.0"}).Commits {
+		if ac.Title() == "c" {
+			c = ac
+		}
+	}
+	u := e.upstream
+	u.git("", "tag", "v1.0.1", "release-1.0")
+	pr, _ := u.openPR("alice@example.com", "release-1.0", "release-1.0: fix c",
+		testCommit{cherryPick: c.SHA().String()})
+	u.merge(pr)
+	e.sync()
+
+	tags := func(opts boardOptions) map[string]string {
+		t.Helper()
+		opts.branch = "release-1.0"
+		out := map[string]string{}
+		for _, ac := range e.board(opts).Commits {
+			out[ac.Title()] = ac.Tag
+		}
+		return out
+	}
+	if b := e.board(boardOptions{branch: "release-1.0"}); b.LatestTag != "v1.0.1" {
+		t.Errorf("expected latest tag v1.0.1, got %q", b.LatestTag)
+	}
+	if actual, expected := tags(boardOptions{}), map[string]string{"a": "v1.0.1", "b1": "", "b2": "", "c": ""}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected tags %v, got %v", expected, actual)
+	}
+	if actual, expected := tags(boardOptions{sinceLastTag: true}), map[string]string{"c": ""}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("since last tag: expected %v, got %v", expected, actual)
+	}
+
+	// Annotated tags are followed to the commits they tag.
+	u.git("", "tag", "-a", "-m", "v1.0.2", "v1.0.2", "release-1.0")
+	e.sync()
+	if actual, expected := tags(boardOptions{}), map[string]string{"a": "v1.0.1", "b1": "", "b2": "", "c": "v1.0.2"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected tags %v, got %v", expected, actual)
+	}
+	if actual := tags(boardOptions{sinceLastTag: true}); len(actual) != 0 {
+		t.Errorf("since last tag: expected no commits, got %v", actual)
+	}
+}
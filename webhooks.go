@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// webhook is an outgoing webhook, to which events about backports are
+// posted as JSON.
+type webhook struct {
+	URL string
+	// SecretEnv names the env var holding the secret with which deliveries
+	// are signed, or is empty if they are not signed. The secret itself is
+	// never stored.
+	SecretEnv string
+	CreatedAt time.Time
+}
+
+const defaultWebhookSecretEnvVar = "BACKBOARD_WEBHOOK_SECRET"
+
+// webhookDelivery is the queued delivery of an event to a webhook.
+type webhookDelivery struct {
+	ID        int64
+	Webhook   webhook
+	Event     event
+	CreatedAt time.Time
+	Attempts  int
+	// LastAttemptAt and LastError describe the last attempt, if any.
+	LastAttemptAt *time.Time
+	LastError     string
+	DeliveredAt   *time.Time
+	// NextAttemptAt is nil once the event is delivered or delivery is
+	// abandoned.
+	NextAttemptAt *time.Time
+}
+
+// Status summarizes the state of the delivery for the delivery log.
+func (d webhookDelivery) Status() string {
+	switch {
+	case d.DeliveredAt != nil:
+		return "delivered " + d.DeliveredAt.Format("2006-01-02 15:04:05")
+	case d.NextAttemptAt == nil:
+		return "abandoned"
+	case d.Attempts == 0:
+		return "pending"
+	default:
+		return "retrying " + d.NextAttemptAt.Format("2006-01-02 15:04:05")
+	}
+}
+
+// webhookEvent reports whether ev changes the state of a backport, and so
+// is delivered to webhooks.
+func webhookEvent(ev event) bool {
+	if ev.Branch == "master" {
+		return false
+	}
+	return webhookStatus(ev.Kind) != ""
+}
+
+// webhookStatus returns the backport status that an event of the given kind
+// leaves its commits in, as reported by the status command, or the empty
+// string if the event doesn't change it.
+func webhookStatus(kind string) string {
+	switch kind {
+	case eventPROpened, eventPRReopened:
+		return "in progress"
+	case eventPRMerged:
+		return "backported"
+	case eventPRClosed, eventUnexcluded:
+		return "missing"
+	case eventExcluded:
+		return "excluded"
+	default:
+		return ""
+	}
+}
+
+// webhookPayload is the JSON body of a webhook delivery.
+type webhookPayload struct {
+	Delivery int64     `json:"delivery"`
+	Event    string    `json:"event"`
+	At       time.Time `json:"at"`
+	Repo     string    `json:"repo"`
+	Branch   string    `json:"branch"`
+	Status   string    `json:"status"`
+	Actor    string    `json:"actor,omitempty"`
+	// PR is the backport PR, for events about PRs.
+	PR int `json:"pr,omitempty"`
+	// Reason is the reason given for an exclusion.
+	Reason  string          `json:"reason,omitempty"`
+	Commits []webhookCommit `json:"commits"`
+}
+
+// webhookCommit describes a master commit whose backport the event
+// concerns.
+type webhookCommit struct {
+	SHA      string `json:"sha"`
+	Title    string `json:"title"`
+	Author   string `json:"author"`
+	MasterPR int    `json:"master_pr,omitempty"`
+}
+
+// newWebhookPayload describes d's event, looking up its commits in re.
+func newWebhookPayload(re repo, d webhookDelivery) webhookPayload {
+	ev := d.Event
+	p := webhookPayload{
+		Delivery: d.ID,
+		Event:    ev.Kind,
+		At:       ev.CreatedAt,
+		Repo:     re.String(),
+		Branch:   ev.Branch,
+		Status:   webhookStatus(ev.Kind),
+		Actor:    ev.Actor,
+		PR:       ev.PRNumber,
+		Commits:  []webhookCommit{},
+	}
+	if ev.Kind == eventExcluded {
+		p.Reason = ev.Detail
+	}
+	messageIDs := map[string]bool{}
+	if ev.MessageID != "" {
+		messageIDs[ev.MessageID] = true
+	}
+	if ev.PRNumber != 0 {
+		for messageID, prs := range re.branchPRs {
+			if bp := prs[ev.Branch]; bp != nil && bp.number == ev.PRNumber {
+				messageIDs[messageID] = true
+			}
+		}
+	}
+	for _, c := range re.masterCommits.commits {
+		if !messageIDs[c.MessageID()] {
+			continue
+		}
+		wc := webhookCommit{SHA: c.sha.String(), Title: c.title, Author: c.Author.Email}
+		if mp := re.masterPRs[string(c.sha)]; mp != nil {
+			wc.MasterPR = mp.number
+		}
+		p.Commits = append(p.Commits, wc)
+	}
+	return p
+}
+
+const webhookSignatureHeader = "X-Backboard-Signature-256"
+
+// signWebhook returns the signature of body under secret: the hex-encoded
+// HMAC-SHA256 of the body, prefixed with "sha256=".
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send posts payload to d's webhook, signing it if the webhook has a secret.
+func (d webhookDelivery) send(ctx context.Context, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Backboard-Event", d.Event.Kind)
+	header.Set("X-Backboard-Delivery", strconv.FormatInt(d.ID, 10))
+	if d.Webhook.SecretEnv != "" {
+		secret := os.Getenv(d.Webhook.SecretEnv)
+		if secret == "" {
+			return fmt.Errorf("missing %s env var", d.Webhook.SecretEnv)
+		}
+		header.Set(webhookSignatureHeader, signWebhook(secret, body))
+	}
+	return post(ctx, d.Webhook.URL, body, header)
+}
+
+const (
+	// webhookBatchSize is the number of deliveries attempted at once.
+	webhookBatchSize = 100
+	// maxWebhookAttempts is the number of failed attempts after which a
+	// delivery is abandoned. With webhookBackoff, that is about a day.
+	maxWebhookAttempts  = 12
+	webhookPollInterval = 10 * time.Second
+)
+
+// webhookBackoff returns the time to wait before the next attempt of a
+// delivery that has failed attempts times: 30s, doubling with each further
+// failure up to 6h.
+func webhookBackoff(attempts int) time.Duration {
+	const max = 6 * time.Hour
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// deliverWebhooks attempts the deliveries that are due at now, rescheduling
+// those that fail with webhookBackoff. Events about PRs wait until the board
+// reflects the sync that recorded them, so that their commits can be looked
+// up; their deliveries are rescheduled a poll interval later, behind the
+// deliveries that are already due. The last error from a failed delivery is
+// returned once every delivery has been attempted.
+func deliverWebhooks(ctx context.Context, st store, now time.Time) error {
+	ds, err := st.dueDeliveries(ctx, now, webhookBatchSize)
+	if err != nil {
+		return err
+	}
+	repoLock.RLock()
+	reposByID := map[int64]repo{}
+	for _, re := range repos {
+		reposByID[re.id] = re
+	}
+	repoLock.RUnlock()
+
+	var lastErr error
+	for _, d := range ds {
+		re, ok := reposByID[d.Event.RepoID]
+		if ok && d.Event.PRNumber != 0 && d.Event.ID > re.syncedEventID {
+			next := now.Add(webhookPollInterval)
+			d.NextAttemptAt = &next
+			if err := st.putDeliveryAttempt(ctx, d); err != nil {
+				return err
+			}
+			continue
+		}
+		err := d.send(ctx, newWebhookPayload(re, d))
+		d.Attempts++
+		d.LastAttemptAt = &now
+		d.NextAttemptAt = nil
+		if err == nil {
+			d.DeliveredAt, d.LastError = &now, ""
+		} else {
+			d.LastError = err.Error()
+			lastErr = fmt.Errorf("delivering %s to %s: %s", d.Event.Kind, d.Webhook.URL, err)
+			log.Print(lastErr)
+			if d.Attempts < maxWebhookAttempts {
+				next := now.Add(webhookBackoff(d.Attempts))
+				d.NextAttemptAt = &next
+			}
+		}
+		if err := st.putDeliveryAttempt(ctx, d); err != nil {
+			return err
+		}
+	}
+	return lastErr
+}
+
+func webhookLoop(ctx context.Context, st store, interval time.Duration) {
+	for {
+		if err := deliverWebhooks(ctx, st, time.Now()); err != nil {
+			log.Printf("webhook error: %s", err)
+		}
+		<-time.After(interval)
+	}
+}
+
+var webhooksTemplate = template.Must(template.New("webhooks.html").Parse(`<!doctype html>
+<html>
+<head>
+    <style>
+        body {
+            font-family: helvetica, sans-serif;
+            font-size: 14px;
+        }
+
+        h1 {
+            margin: 0 0 10px;
+        }
+
+        h1 a {
+            color: inherit;
+            text-decoration: none;
+        }
+
+        .header {
+            margin: 0 auto;
+            text-align: center;
+        }
+
+        #delivery-table {
+            border-collapse: collapse;
+            margin: 1em auto 0;
+        }
+
+        #delivery-table td {
+            border-top: 1px solid #bbb;
+            padding: 0.3em 0.3em;
+        }
+
+        .sha {
+            font-family: monospace;
+        }
+
+        .error {
+            color: #c00;
+        }
+    </style>
+    <title>Webhook deliveries · backboard</title>
+</head>
+<body>
+<div class="header">
+    <h1><a href="/">backboard</a></h1>
+    <h2>Webhook deliveries</h2>
+</div>
+<table id="delivery-table">
+    <thead>
+    <tr>
+        <th>Queued</th>
+        <th>Webhook</th>
+        <th>Event</th>
+        <th>Repo</th>
+        <th>Branch</th>
+        <th>PR</th>
+        <th>SHA</th>
+        <th>Attempts</th>
+        <th>Status</th>
+        <th>Last error</th>
+    </tr>
+    </thead>
+    <tbody>
+    {{range .Deliveries}}
+        <tr>
+            <td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Webhook.URL}}</td>
+            <td>{{.Event.Kind}}</td>
+            <td>{{.Repo}}</td>
+            <td>{{.Event.Branch}}</td>
+            <td>{{with .PR}}<a href="{{.URL}}">{{.}}</a>{{end}}</td>
+            <td class="sha">{{if .Event.SHA}}<a href="{{.HistoryURL}}" title="{{.Event.SHA}}">{{.Event.SHA.Short}}</a>{{end}}</td>
+            <td>{{.Attempts}}</td>
+            <td>{{.Status}}</td>
+            <td class="error">{{.LastError}}</td>
+        </tr>
+    {{else}}
+        <tr><td colspan="10">No deliveries.</td></tr>
+    {{end}}
+    </tbody>
+</table>
+</body>
+</html>`))
+
+const deliveriesPerPage = 200
+
+// deliveryView decorates a webhook delivery with what's needed to render it.
+type deliveryView struct {
+	webhookDelivery
+	Repo repo
+	PR   *pr
+}
+
+func (d deliveryView) HistoryURL() string {
+	return historyURL(d.Repo, d.Event.SHA)
+}
+
+// serveWebhooks renders the log of recent webhook deliveries. Webhook URLs
+// may embed credentials, so only admins may see it.
+func (s *server) serveWebhooks(w http.ResponseWriter, r *http.Request) error {
+	if id := identityFromContext(r.Context()); id == nil || !admins[id.Login] {
+		role := roleViewer
+		if id != nil {
+			role = defaultSignedInRole
+		}
+		return errForbidden{id: id, role: role}
+	}
+	ds, err := s.store.deliveries(r.Context(), deliveriesPerPage)
+	if err != nil {
+		return err
+	}
+
+	repoLock.RLock()
+	reposByID := map[int64]*repo{}
+	for i := range repos {
+		re := repos[i]
+		reposByID[re.id] = &re
+	}
+	repoLock.RUnlock()
+
+	var views []deliveryView
+	for _, d := range ds {
+		v := deliveryView{webhookDelivery: d}
+		if re := reposByID[d.Event.RepoID]; re != nil {
+			v.Repo = *re
+			if d.Event.PRNumber != 0 {
+				v.PR = &pr{repo: re, number: d.Event.PRNumber}
+			}
+		}
+		views = append(views, v)
+	}
+	return webhooksTemplate.Execute(w, struct {
+		Deliveries []deliveryView
+	}{
+		Deliveries: views,
+	})
+}
+
+// parseWebhookURL validates the URL of a webhook.
+func parseWebhookURL(cmd, s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", usageError{cmd: cmd, msg: fmt.Sprintf("malformed webhook URL %q, want an http or https URL", s)}
+	}
+	return s, nil
+}
+
+func setupWebhooksAdd(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	secretEnv := fs.String("secret-env", defaultWebhookSecretEnvVar,
+		"`name` of the env var holding the secret to sign deliveries with, or empty to not sign them")
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("webhooks add", args, 1, 1); err != nil {
+			return err
+		}
+		u, err := parseWebhookURL("webhooks add", args[0])
+		if err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		return st.putWebhook(ctx, webhook{URL: u, SecretEnv: *secretEnv, CreatedAt: time.Now()})
+	}
+}
+
+func setupWebhooksRemove(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("webhooks remove", args, 1, 1); err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		return st.deleteWebhook(ctx, args[0])
+	}
+}
+
+func setupWebhooksList(fs *flag.FlagSet, cfg *config) func(context.Context, []string) error {
+	cfg.dbFlag(fs)
+	return func(ctx context.Context, args []string) error {
+		if err := checkArgs("webhooks list", args, 0, 0); err != nil {
+			return err
+		}
+		st, err := cfg.openStore(ctx)
+		if err != nil {
+			return err
+		}
+		hooks, err := st.webhooks(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "URL\tSECRET ENV VAR\n")
+		for _, w := range hooks {
+			fmt.Fprintf(tw, "%s\t%s\n", w.URL, w.SecretEnv)
+		}
+		return tw.Flush()
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st store) {
+		t.Setenv("TEST_WEBHOOK_SECRET", "hunter2")
+		var mu sync.Mutex
+		var received []string
+		var fail bool
+		hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if sig := r.Header.Get(webhookSignatureHeader); sig != signWebhook("hunter2", body) {
+				http.Error(w, "bad signature "+sig, http.StatusUnauthorized)
+				return
+			}
+			var p webhookPayload
+			if err := json.Unmarshal(body, &p); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if fail {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			var commits []string
+			for _, c := range p.Commits {
+				commits = append(commits, fmt.Sprintf("%s (#%d)", c.Title, c.MasterPR))
+			}
+			received = append(received, fmt.Sprintf("%s %s #%d %s %s %v",
+				p.Event, p.Branch, p.PR, p.Status, p.Reason, commits))
+		}))
+		t.Cleanup(hook.Close)
+		take := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			r := received
+			received = nil
+			return r
+		}
+		setFail := func(f bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			fail = f
+		}
+
+		e := newTestEnv(t, st)
+		if err := st.putWebhook(e.ctx, webhook{URL: hook.URL, SecretEnv: "TEST_WEBHOOK_SECRET", CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		setupBackports(e)
+		e.sync()
+
+		// Only the events on release branches are delivered.
+		now := time.Now()
+		if err := deliverWebhooks(e.ctx, st, now); err != nil {
+			t.Fatal(err)
+		}
+		if actual, expected := take(), []string{
+			"pr-opened release-1.0 #4 in progress  [a (#1)]",
+			"pr-merged release-1.0 #4 backported  [a (#1)]",
+			"pr-opened release-1.0 #5 in progress  [b1 (#2)]",
+		}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected deliveries %q, got %q", expected, actual)
+		}
+
+		// Failed deliveries are retried with backoff.
+		re := e.repo()
+		var c commit
+		for _, mc := range re.masterCommits.commits {
+			if mc.title == "c" {
+				c = mc
+			}
+		}
+		setFail(true)
+		if err := st.putExclusion(e.ctx, re.id, "release-1.0", c.MessageID(),
+			exclusion{CreatedBy: "carol", Reason: "not needed"},
+			event{RepoID: re.id, Branch: "release-1.0", Actor: "carol", Kind: eventExcluded,
+				MessageID: c.MessageID(), SHA: c.sha, Detail: "not needed"}); err != nil {
+			t.Fatal(err)
+		}
+		now = time.Now()
+		if err := deliverWebhooks(e.ctx, st, now); err == nil {
+			t.Error("expected the failed delivery to be reported")
+		}
+		ds, err := st.deliveries(e.ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := ds[0]; d.Attempts != 1 || !strings.Contains(d.LastError, "503") ||
+			d.NextAttemptAt == nil || !d.NextAttemptAt.Equal(now.Add(30*time.Second)) {
+			t.Errorf("unexpected failed delivery %+v", d)
+		}
+		setFail(false)
+		if err := deliverWebhooks(e.ctx, st, now.Add(10*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if actual := take(); len(actual) != 0 {
+			t.Errorf("expected no deliveries before the retry was due, got %q", actual)
+		}
+		if err := deliverWebhooks(e.ctx, st, now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if actual, expected := take(), []string{
+			"excluded release-1.0 #0 excluded not needed [c (#3)]",
+		}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected deliveries %q, got %q", expected, actual)
+		}
+
+		// Deliveries of PR events that the board doesn't yet reflect are
+		// pushed back, rather than holding up the deliveries behind them.
+		if err := st.recordEvent(e.ctx, event{RepoID: re.id, Branch: "release-1.0", Kind: eventPRReopened, PRNumber: 5}); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.putExclusion(e.ctx, re.id, "release-1.0", c.MessageID(),
+			exclusion{CreatedBy: "carol", Reason: "still not needed"},
+			event{RepoID: re.id, Branch: "release-1.0", Actor: "carol", Kind: eventExcluded,
+				MessageID: c.MessageID(), SHA: c.sha, Detail: "still not needed"}); err != nil {
+			t.Fatal(err)
+		}
+		due, err := st.dueDeliveries(e.ctx, now.Add(time.Minute), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 1 || due[0].Event.Kind != eventPRReopened {
+			t.Fatalf("expected the reopened delivery to be due first, got %+v", due)
+		}
+		if err := deliverWebhooks(e.ctx, st, now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if actual, expected := take(), []string{
+			"excluded release-1.0 #0 excluded still not needed [c (#3)]",
+		}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected deliveries %q, got %q", expected, actual)
+		}
+		due, err = st.dueDeliveries(e.ctx, now.Add(time.Minute), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 0 {
+			t.Errorf("expected the reopened delivery to be pushed back, got %+v", due)
+		}
+		e.sync()
+		if err := deliverWebhooks(e.ctx, st, now.Add(time.Minute+webhookPollInterval)); err != nil {
+			t.Fatal(err)
+		}
+		if actual, expected := take(), []string{
+			"pr-reopened release-1.0 #5 in progress  [b1 (#2)]",
+		}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected deliveries %q, got %q", expected, actual)
+		}
+
+		// Only admins may see the delivery log.
+		admins["carol"] = true
+		t.Cleanup(func() { delete(admins, "carol") })
+		s := &server{store: st, auth: authConfig{trustedUserHeader: "X-User"}}
+		get := func(login string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", "/webhooks", nil)
+			req.Header.Set("X-User", login)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			return w
+		}
+		if w := get("bob"); w.Code != http.StatusForbidden {
+			t.Errorf("expected bob to be forbidden, got %d", w.Code)
+		}
+		if w := get("carol"); w.Code != http.StatusOK ||
+			!strings.Contains(w.Body.String(), hook.URL) || !strings.Contains(w.Body.String(), "delivered") {
+			t.Errorf("unexpected delivery log (%d):\n%s", w.Code, w.Body)
+		}
+	})
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		11: 6 * time.Hour,
+	} {
+		if actual := webhookBackoff(attempts); actual != expected {
+			t.Errorf("after %d attempts: expected %s, got %s", attempts, expected, actual)
+		}
+	}
+}